@@ -0,0 +1,221 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/json"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// ConversionDataAnnotation holds, as JSON, the fields of a v1beta1 Cluster
+// that v1alpha1 has no place for, to restore them when it is converted back.
+const ConversionDataAnnotation = "clusterregistry.k8s.io/conversion-data"
+
+// conversionData is what a v1alpha1 Cluster cannot represent of v1beta1.
+// Conditions in v1alpha1 have a heartbeat instead of an observed
+// generation: the OK condition's heartbeat is the v1beta1 LastProbeTime,
+// the others' is their transition time.
+type conversionData struct {
+	Source              *v1beta1.SourceReference    `json:"source,omitempty"`
+	LastProbeTime       *metav1.Time                `json:"lastProbeTime,omitempty"`
+	Version             string                      `json:"version,omitempty"`
+	Provider            string                      `json:"provider,omitempty"`
+	Region              string                      `json:"region,omitempty"`
+	Capacity            corev1.ResourceList         `json:"capacity,omitempty"`
+	Nodes               *v1beta1.NodeSummary        `json:"nodes,omitempty"`
+	CNI                 string                      `json:"cni,omitempty"`
+	LastInventoryTime   *metav1.Time                `json:"lastInventoryTime,omitempty"`
+	Certificates        *v1beta1.CertificatesStatus `json:"certificates,omitempty"`
+	ObservedGenerations []int64                     `json:"observedGenerations,omitempty"`
+}
+
+var _ conversion.Convertible = &Cluster{}
+
+// ConvertTo converts this Cluster to the Hub version (v1beta1).
+func (src *Cluster) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.Cluster)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	convertSpecTo(&src.Spec, &dst.Spec)
+
+	dst.Status = v1beta1.ClusterStatus{}
+	for _, c := range src.Status.Conditions {
+		dst.Status.Conditions = append(dst.Status.Conditions, v1beta1.Condition{
+			Type:               v1beta1.ClusterConditionType(c.Type),
+			Status:             c.Status,
+			LastTransitionTime: c.LastTransitionTime,
+			Reason:             c.Reason,
+			Message:            c.Message,
+		})
+		if c.Type == ClusterOK && !c.LastHeartbeatTime.IsZero() {
+			probed := c.LastHeartbeatTime
+			dst.Status.LastProbeTime = &probed
+		}
+	}
+
+	data, ok := dst.Annotations[ConversionDataAnnotation]
+	if !ok {
+		return nil
+	}
+	delete(dst.Annotations, ConversionDataAnnotation)
+	if len(dst.Annotations) == 0 {
+		dst.Annotations = nil
+	}
+	restored := &conversionData{}
+	if err := json.Unmarshal([]byte(data), restored); err != nil {
+		return err
+	}
+	dst.Spec.Source = restored.Source
+	if dst.Status.LastProbeTime == nil {
+		dst.Status.LastProbeTime = restored.LastProbeTime
+	}
+	dst.Status.Version = restored.Version
+	dst.Status.Provider = restored.Provider
+	dst.Status.Region = restored.Region
+	dst.Status.Capacity = restored.Capacity
+	dst.Status.Nodes = restored.Nodes
+	dst.Status.CNI = restored.CNI
+	dst.Status.LastInventoryTime = restored.LastInventoryTime
+	dst.Status.Certificates = restored.Certificates
+	for i := range dst.Status.Conditions {
+		if i < len(restored.ObservedGenerations) {
+			dst.Status.Conditions[i].ObservedGeneration = restored.ObservedGenerations[i]
+		}
+	}
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+func (dst *Cluster) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.Cluster)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	convertSpecFrom(&src.Spec, &dst.Spec)
+
+	dst.Status = ClusterStatus{}
+	data := conversionData{
+		Source:   src.Spec.Source,
+		Version:  src.Status.Version,
+		Provider: src.Status.Provider,
+		Region:   src.Status.Region,
+		Capacity: src.Status.Capacity,
+		Nodes:    src.Status.Nodes,
+		CNI:      src.Status.CNI,
+
+		LastInventoryTime: src.Status.LastInventoryTime,
+		Certificates:      src.Status.Certificates,
+	}
+	probed, observed := false, false
+	for _, c := range src.Status.Conditions {
+		condition := ClusterCondition{
+			Type:               ClusterConditionType(c.Type),
+			Status:             c.Status,
+			LastHeartbeatTime:  c.LastTransitionTime,
+			LastTransitionTime: c.LastTransitionTime,
+			Reason:             c.Reason,
+			Message:            c.Message,
+		}
+		if condition.Type == ClusterOK {
+			condition.LastHeartbeatTime = metav1.Time{}
+			if src.Status.LastProbeTime != nil {
+				condition.LastHeartbeatTime = *src.Status.LastProbeTime
+			}
+			probed = true
+		}
+		dst.Status.Conditions = append(dst.Status.Conditions, condition)
+		data.ObservedGenerations = append(data.ObservedGenerations, c.ObservedGeneration)
+		observed = observed || c.ObservedGeneration != 0
+	}
+	if !probed {
+		data.LastProbeTime = src.Status.LastProbeTime
+	}
+	if !observed {
+		data.ObservedGenerations = nil
+	}
+
+	lossy := data.Source != nil || data.LastProbeTime != nil || data.Version != "" ||
+		data.Provider != "" || data.Region != "" || len(data.Capacity) > 0 || data.Nodes != nil ||
+		data.CNI != "" || data.LastInventoryTime != nil || data.Certificates != nil || observed
+	if !lossy {
+		delete(dst.Annotations, ConversionDataAnnotation)
+		return nil
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if dst.Annotations == nil {
+		dst.Annotations = map[string]string{}
+	}
+	dst.Annotations[ConversionDataAnnotation] = string(raw)
+	return nil
+}
+
+var _ conversion.Convertible = &ClusterList{}
+
+// ConvertTo converts this ClusterList to the Hub version (v1beta1).
+func (src *ClusterList) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.ClusterList)
+	src.ListMeta.DeepCopyInto(&dst.ListMeta)
+	dst.Items = make([]v1beta1.Cluster, len(src.Items))
+	for i := range src.Items {
+		if err := src.Items[i].ConvertTo(&dst.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+func (dst *ClusterList) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.ClusterList)
+	src.ListMeta.DeepCopyInto(&dst.ListMeta)
+	dst.Items = make([]Cluster, len(src.Items))
+	for i := range src.Items {
+		if err := dst.Items[i].ConvertFrom(&src.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func convertSpecTo(src *ClusterSpec, dst *v1beta1.ClusterSpec) {
+	dst.KubernetesAPIEndpoints = v1beta1.KubernetesAPIEndpoints{CABundle: src.KubernetesAPIEndpoints.CABundle}
+	for _, endpoint := range src.KubernetesAPIEndpoints.ServerEndpoints {
+		dst.KubernetesAPIEndpoints.ServerEndpoints = append(dst.KubernetesAPIEndpoints.ServerEndpoints,
+			v1beta1.ServerAddressByClientCIDR{ClientCIDR: endpoint.ClientCIDR, ServerAddress: endpoint.ServerAddress})
+	}
+	dst.AuthInfo = v1beta1.AuthInfo{
+		User:       (*v1beta1.ObjectReference)(src.AuthInfo.User),
+		Controller: (*v1beta1.ObjectReference)(src.AuthInfo.Controller),
+	}
+	dst.Source = nil
+}
+
+func convertSpecFrom(src *v1beta1.ClusterSpec, dst *ClusterSpec) {
+	dst.KubernetesAPIEndpoints = KubernetesAPIEndpoints{CABundle: src.KubernetesAPIEndpoints.CABundle}
+	for _, endpoint := range src.KubernetesAPIEndpoints.ServerEndpoints {
+		dst.KubernetesAPIEndpoints.ServerEndpoints = append(dst.KubernetesAPIEndpoints.ServerEndpoints,
+			ServerAddressByClientCIDR{ClientCIDR: endpoint.ClientCIDR, ServerAddress: endpoint.ServerAddress})
+	}
+	dst.AuthInfo = AuthInfo{
+		User:       (*ObjectReference)(src.AuthInfo.User),
+		Controller: (*ObjectReference)(src.AuthInfo.Controller),
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	fuzz "github.com/google/gofuzz"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	runtimeserializer "k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/diff"
+
+	"github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// conditionFuzzerFuncs make OK conditions likely
+func conditionFuzzerFuncs(_ runtimeserializer.CodecFactory) []interface{} {
+	return []interface{}{
+		func(c *ClusterCondition, f fuzz.Continue) {
+			f.FuzzNoCustom(c)
+			if f.RandBool() {
+				c.Type = ClusterOK
+			}
+		},
+		func(c *v1beta1.Condition, f fuzz.Continue) {
+			f.FuzzNoCustom(c)
+			if f.RandBool() {
+				c.Type = v1beta1.ClusterOK
+			}
+		},
+	}
+}
+
+// storedHeartbeats sets the condition heartbeats of status to what survives
+// a round trip through v1beta1, as documented on LastHeartbeatTime
+func storedHeartbeats(status *ClusterStatus) {
+	var probed metav1.Time
+	for _, c := range status.Conditions {
+		if c.Type == ClusterOK && !c.LastHeartbeatTime.IsZero() {
+			probed = c.LastHeartbeatTime
+		}
+	}
+	for i := range status.Conditions {
+		c := &status.Conditions[i]
+		if c.Type == ClusterOK {
+			c.LastHeartbeatTime = probed
+		} else {
+			c.LastHeartbeatTime = c.LastTransitionTime
+		}
+	}
+}
+
+func newFuzzer(t *testing.T) *fuzz.Fuzzer {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return fuzzer.FuzzerFor(
+		fuzzer.MergeFuzzerFuncs(metafuzzer.Funcs, conditionFuzzerFuncs),
+		rand.NewSource(rand.Int63()),
+		runtimeserializer.NewCodecFactory(scheme),
+	)
+}
+
+func TestClusterConversionFromHub(t *testing.T) {
+	f := newFuzzer(t)
+	for i := 0; i < 1000; i++ {
+		hub := &v1beta1.Cluster{}
+		f.Fuzz(hub)
+
+		spoke := &Cluster{}
+		if err := spoke.ConvertFrom(hub.DeepCopy()); err != nil {
+			t.Fatal(err)
+		}
+		after := &v1beta1.Cluster{}
+		if err := spoke.ConvertTo(after); err != nil {
+			t.Fatal(err)
+		}
+		if !apiequality.Semantic.DeepEqual(hub, after) {
+			t.Fatalf("v1beta1 -> v1alpha1 -> v1beta1 is lossy:\n%s", diff.ObjectReflectDiff(hub, after))
+		}
+	}
+}
+
+func TestClusterConversionToHub(t *testing.T) {
+	f := newFuzzer(t)
+	for i := 0; i < 1000; i++ {
+		spoke := &Cluster{}
+		f.Fuzz(spoke)
+
+		hub := &v1beta1.Cluster{}
+		if err := spoke.DeepCopy().ConvertTo(hub); err != nil {
+			t.Fatal(err)
+		}
+		after := &Cluster{}
+		if err := after.ConvertFrom(hub); err != nil {
+			t.Fatal(err)
+		}
+		storedHeartbeats(&spoke.Status)
+		if !apiequality.Semantic.DeepEqual(spoke, after) {
+			t.Fatalf("v1alpha1 -> v1beta1 -> v1alpha1 is lossy:\n%s", diff.ObjectReflectDiff(spoke, after))
+		}
+	}
+}
+
+func TestClusterConversionHeartbeats(t *testing.T) {
+	transitioned := metav1.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	probed := metav1.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	spoke := &Cluster{Status: ClusterStatus{Conditions: []ClusterCondition{
+		{Type: ClusterOK, Status: corev1.ConditionTrue, LastHeartbeatTime: probed, LastTransitionTime: transitioned},
+		{Type: "Decommissioned", Status: corev1.ConditionFalse, LastHeartbeatTime: probed, LastTransitionTime: transitioned},
+		{Type: ClusterOK, Status: corev1.ConditionTrue, LastTransitionTime: transitioned},
+	}}}
+
+	hub := &v1beta1.Cluster{}
+	if err := spoke.ConvertTo(hub); err != nil {
+		t.Fatal(err)
+	}
+	if hub.Status.LastProbeTime == nil || !hub.Status.LastProbeTime.Equal(&probed) {
+		t.Errorf("expected the OK heartbeat as the probe time, got %v", hub.Status.LastProbeTime)
+	}
+	after := &Cluster{}
+	if err := after.ConvertFrom(hub); err != nil {
+		t.Fatal(err)
+	}
+	for i, heartbeat := range []metav1.Time{probed, transitioned, probed} {
+		if c := after.Status.Conditions[i]; !c.LastHeartbeatTime.Equal(&heartbeat) {
+			t.Errorf("condition %d: expected heartbeat %v, got %v", i, heartbeat, c.LastHeartbeatTime)
+		}
+	}
+}
+
+func TestClusterListConversion(t *testing.T) {
+	f := newFuzzer(t)
+	for i := 0; i < 100; i++ {
+		hub := &v1beta1.ClusterList{}
+		f.Fuzz(hub)
+
+		spoke := &ClusterList{}
+		if err := spoke.ConvertFrom(hub.DeepCopy()); err != nil {
+			t.Fatal(err)
+		}
+		after := &v1beta1.ClusterList{}
+		if err := spoke.ConvertTo(after); err != nil {
+			t.Fatal(err)
+		}
+		if !apiequality.Semantic.DeepEqual(hub, after) {
+			t.Fatalf("v1beta1 -> v1alpha1 -> v1beta1 is lossy:\n%s", diff.ObjectReflectDiff(hub, after))
+		}
+	}
+}
+
+func TestClusterWebhooksUseHub(t *testing.T) {
+	cluster := &Cluster{}
+	cluster.Spec.KubernetesAPIEndpoints.ServerEndpoints = []ServerAddressByClientCIDR{{ServerAddress: "10.0.0.1:6443"}}
+	cluster.Default()
+	if endpoint := cluster.Spec.KubernetesAPIEndpoints.ServerEndpoints[0]; endpoint.ClientCIDR != v1beta1.DefaultClientCIDR ||
+		endpoint.ServerAddress != "https://10.0.0.1:6443" {
+		t.Errorf("expected v1beta1 defaults, got %+v", endpoint)
+	}
+	if err := cluster.ValidateCreate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	old := cluster.DeepCopy()
+	cluster.Spec.KubernetesAPIEndpoints.CABundle = []byte("ca")
+	if err := cluster.ValidateUpdate(old); err == nil {
+		t.Error("expected the invalid CA bundle to be rejected")
+	}
+}
@@ -152,6 +152,9 @@ type ClusterCondition struct {
 	Status v1.ConditionStatus `json:"status" protobuf:"bytes,2,opt,name=status,casttype=ConditionStatus"`
 
 	// LastHeartbeatTime is the last time this condition was updated.
+	// Clusters are stored as v1beta1, which keeps a single probe time, so
+	// a condition other than OK reads back its LastTransitionTime, and every
+	// OK condition the heartbeat of the last OK condition that has one.
 	// +optional
 	LastHeartbeatTime metav1.Time `json:"lastHeartbeatTime,omitempty" protobuf:"bytes,3,opt,name=lastHeartbeatTime"`
 
@@ -0,0 +1,75 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks
+// of Cluster, which treat a v1alpha1 Cluster as its v1beta1 conversion
+func (r *Cluster) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-clusterregistry-k8s-io-v1alpha1-cluster,mutating=true,failurePolicy=fail,groups=clusterregistry.k8s.io,resources=clusters,verbs=create;update,versions=v1alpha1,name=mcluster-v1alpha1.kb.io
+
+var _ webhook.Defaulter = &Cluster{}
+
+// Default applies the v1beta1 defaults to the spec
+func (r *Cluster) Default() {
+	hub := &v1beta1.Cluster{}
+	convertSpecTo(&r.Spec, &hub.Spec)
+	hub.Default()
+	convertSpecFrom(&hub.Spec, &r.Spec)
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-clusterregistry-k8s-io-v1alpha1-cluster,mutating=false,failurePolicy=fail,groups=clusterregistry.k8s.io,resources=clusters,versions=v1alpha1,name=vcluster-v1alpha1.kb.io
+
+var _ webhook.Validator = &Cluster{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Cluster) ValidateCreate() error {
+	hub := &v1beta1.Cluster{}
+	if err := r.DeepCopy().ConvertTo(hub); err != nil {
+		return err
+	}
+	return hub.ValidateCreate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Cluster) ValidateUpdate(old runtime.Object) error {
+	hub, oldHub := &v1beta1.Cluster{}, &v1beta1.Cluster{}
+	if err := r.DeepCopy().ConvertTo(hub); err != nil {
+		return err
+	}
+	if err := old.(*Cluster).DeepCopy().ConvertTo(oldHub); err != nil {
+		return err
+	}
+	return hub.ValidateUpdate(oldHub)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Cluster) ValidateDelete() error {
+	return nil
+}
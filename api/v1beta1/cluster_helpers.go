@@ -0,0 +1,99 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"net"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServerAddressFor returns the address of the server endpoint whose
+// ClientCIDR matches the client IP most specifically, or false if none
+// matches. Endpoints with an unparseable ClientCIDR are skipped.
+func (e *KubernetesAPIEndpoints) ServerAddressFor(ip net.IP) (string, bool) {
+	address, best := "", -1
+	for _, endpoint := range e.ServerEndpoints {
+		_, cidr, err := net.ParseCIDR(endpoint.ClientCIDR)
+		if err != nil || !cidr.Contains(ip) {
+			continue
+		}
+		if ones, _ := cidr.Mask.Size(); ones > best {
+			address, best = endpoint.ServerAddress, ones
+		}
+	}
+	return address, best >= 0
+}
+
+// GetCondition returns the condition of the given type, or nil if the
+// cluster does not report it.
+func (s *ClusterStatus) GetCondition(t ClusterConditionType) *Condition {
+	return getCondition(s.Conditions, t)
+}
+
+// SetCondition adds or replaces the condition of the same type. The
+// LastTransitionTime of an existing condition is kept unless its status
+// changes, and defaults to now otherwise.
+func (s *ClusterStatus) SetCondition(c Condition) {
+	s.Conditions = setCondition(s.Conditions, c)
+}
+
+// RemoveCondition removes the condition of the given type, if reported.
+func (s *ClusterStatus) RemoveCondition(t ClusterConditionType) {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == t {
+			s.Conditions = append(s.Conditions[:i], s.Conditions[i+1:]...)
+			return
+		}
+	}
+}
+
+// GetCondition returns the condition of the given type, or nil if the set
+// does not report it.
+func (s *ClusterSetStatus) GetCondition(t ClusterConditionType) *Condition {
+	return getCondition(s.Conditions, t)
+}
+
+// SetCondition adds or replaces the condition of the same type, as
+// ClusterStatus.SetCondition does.
+func (s *ClusterSetStatus) SetCondition(c Condition) {
+	s.Conditions = setCondition(s.Conditions, c)
+}
+
+func getCondition(conditions []Condition, t ClusterConditionType) *Condition {
+	for i := range conditions {
+		if conditions[i].Type == t {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+func setCondition(conditions []Condition, c Condition) []Condition {
+	if c.LastTransitionTime.IsZero() {
+		c.LastTransitionTime = metav1.Now()
+	}
+	existing := getCondition(conditions, c.Type)
+	if existing == nil {
+		return append(conditions, c)
+	}
+	if existing.Status == c.Status {
+		c.LastTransitionTime = existing.LastTransitionTime
+	}
+	*existing = c
+	return conditions
+}
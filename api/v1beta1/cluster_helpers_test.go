@@ -0,0 +1,69 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"net"
+	"testing"
+)
+
+func TestServerAddressFor(t *testing.T) {
+	endpoints := KubernetesAPIEndpoints{
+		ServerEndpoints: []ServerAddressByClientCIDR{
+			{ClientCIDR: "0.0.0.0/0", ServerAddress: "https://external:6443"},
+			{ClientCIDR: "10.0.0.0/8", ServerAddress: "https://internal:6443"},
+			{ClientCIDR: "10.1.0.0/16", ServerAddress: "https://zone:6443"},
+			{ClientCIDR: "not-a-cidr", ServerAddress: "https://broken:6443"},
+		},
+	}
+	for ip, expected := range map[string]string{
+		"192.168.1.1": "https://external:6443",
+		"10.2.0.1":    "https://internal:6443",
+		"10.1.2.3":    "https://zone:6443",
+	} {
+		if address, ok := endpoints.ServerAddressFor(net.ParseIP(ip)); !ok || address != expected {
+			t.Errorf("%s: expected %s, got %s", ip, expected, address)
+		}
+	}
+
+	if _, ok := endpoints.ServerAddressFor(net.ParseIP("fd00::1")); ok {
+		t.Error("expected no endpoint for an IPv6 client")
+	}
+}
+
+func TestSetCondition(t *testing.T) {
+	status := ClusterStatus{}
+	status.SetCondition(Condition{Type: ClusterOK, Status: "True", Reason: "first"})
+	first := *status.GetCondition(ClusterOK)
+
+	status.SetCondition(Condition{Type: ClusterOK, Status: "True", Reason: "second"})
+	if len(status.Conditions) != 1 || status.Conditions[0].Reason != "second" {
+		t.Errorf("expected the condition to be replaced, got %v", status.Conditions)
+	}
+	if !status.Conditions[0].LastTransitionTime.Equal(&first.LastTransitionTime) {
+		t.Error("expected the transition time to be kept while the status is unchanged")
+	}
+}
+
+func TestRemoveCondition(t *testing.T) {
+	status := ClusterStatus{Conditions: []Condition{{Type: ClusterOK}, {Type: CertificateExpiring}, {Type: SourceReady}}}
+	status.RemoveCondition(CertificateExpiring)
+	status.RemoveCondition(Decommissioned)
+	if len(status.Conditions) != 2 || status.GetCondition(CertificateExpiring) != nil || status.GetCondition(SourceReady) == nil {
+		t.Errorf("expected only CertificateExpiring to be removed, got %v", status.Conditions)
+	}
+}
@@ -0,0 +1,363 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+// +kubebuilder:printcolumn:name="Version",type="string",JSONPath=".status.version",description="Kubernetes version of the API server"
+// +kubebuilder:printcolumn:name="Nodes",type="integer",JSONPath=".status.nodes.total",description="Number of nodes"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// Cluster contains information about a cluster in a cluster registry.
+type Cluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec is the specification of the cluster.
+	// +optional
+	Spec ClusterSpec `json:"spec,omitempty"`
+
+	// Status is the status of the cluster, as observed by the registry.
+	// +optional
+	Status ClusterStatus `json:"status,omitempty"`
+}
+
+// ClusterSpec contains the specification of a cluster.
+type ClusterSpec struct {
+	// KubernetesAPIEndpoints represents the endpoints of the API server for this
+	// cluster.
+	// +optional
+	KubernetesAPIEndpoints KubernetesAPIEndpoints `json:"kubernetesApiEndpoints,omitempty"`
+
+	// AuthInfo contains information that can be used to authenticate to and
+	// authorize with this cluster.
+	// +optional
+	AuthInfo AuthInfo `json:"authInfo,omitempty"`
+
+	// Source references the object the cluster is registered from, such as a
+	// Cluster API Cluster or a kubeconfig Secret. It is empty for clusters
+	// registered by hand.
+	// +optional
+	Source *SourceReference `json:"source,omitempty"`
+}
+
+// KubernetesAPIEndpoints represents the endpoints for one and only one
+// Kubernetes API server.
+type KubernetesAPIEndpoints struct {
+	// ServerEndpoints specifies the address(es) of the Kubernetes API server’s
+	// network identity or identities.
+	// +optional
+	ServerEndpoints []ServerAddressByClientCIDR `json:"serverEndpoints,omitempty"`
+
+	// CABundle contains the certificate authority information.
+	// +optional
+	CABundle []byte `json:"caBundle,omitempty"`
+}
+
+// ServerAddressByClientCIDR helps clients determine the server address that
+// they should use, depending on the ClientCIDR that they match.
+type ServerAddressByClientCIDR struct {
+	// The CIDR with which clients can match their IP to figure out if they should
+	// use the corresponding server address.
+	// +optional
+	ClientCIDR string `json:"clientCIDR,omitempty"`
+	// Address of this server, suitable for a client that matches the above CIDR.
+	// This can be a hostname, hostname:port, IP or IP:port.
+	// +optional
+	ServerAddress string `json:"serverAddress,omitempty"`
+}
+
+// AuthInfo holds information that describes how a client can get
+// credentials to access the cluster.
+type AuthInfo struct {
+	// User references an object that contains implementation-specific details
+	// about how a user should authenticate against this cluster.
+	// +optional
+	User *ObjectReference `json:"user,omitempty"`
+
+	// Controller references a Secret whose kubeconfig key holds the
+	// credentials controllers authenticate against this cluster with.
+	// +optional
+	Controller *ObjectReference `json:"controller,omitempty"`
+}
+
+// ObjectReference contains enough information to let you inspect or modify the referred object.
+type ObjectReference struct {
+	// Kind contains the kind of the referent, e.g., Secret or ConfigMap
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Name contains the name of the referent.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Namespace contains the namespace of the referent, which must be the
+	// namespace of the cluster. Defaults to it.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// SourceReference identifies the object a cluster is registered from.
+type SourceReference struct {
+	// APIVersion of the source object, e.g. cluster.x-k8s.io/v1alpha3
+	APIVersion string `json:"apiVersion"`
+
+	// Kind of the source object, e.g. Cluster or Secret
+	Kind string `json:"kind"`
+
+	// Namespace of the source object
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name of the source object
+	Name string `json:"name"`
+
+	// UID of the source object
+	// +optional
+	UID types.UID `json:"uid,omitempty"`
+}
+
+// ClusterStatus contains the status of a cluster.
+type ClusterStatus struct {
+	// Conditions contains the different condition statuses for this cluster.
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// LastProbeTime is the last time the registry probed the API server,
+	// whatever the outcome recorded in the OK condition.
+	// +optional
+	LastProbeTime *metav1.Time `json:"lastProbeTime,omitempty"`
+
+	// Version is the Kubernetes version the API server reports, e.g. v1.17.3.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Provider is the infrastructure provider running the cluster, e.g. aws.
+	// +optional
+	Provider string `json:"provider,omitempty"`
+
+	// Region is the region the cluster runs in.
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// Capacity is the total allocatable capacity of the cluster's nodes.
+	// +optional
+	Capacity corev1.ResourceList `json:"capacity,omitempty"`
+
+	// Nodes counts the cluster's nodes.
+	// +optional
+	Nodes *NodeSummary `json:"nodes,omitempty"`
+
+	// CNI is the network plugin detected from the DaemonSets in kube-system,
+	// e.g. calico.
+	// +optional
+	CNI string `json:"cni,omitempty"`
+
+	// LastInventoryTime is the last time the version, nodes, capacity,
+	// provider, region and CNI of the cluster were collected.
+	// +optional
+	LastInventoryTime *metav1.Time `json:"lastInventoryTime,omitempty"`
+
+	// Certificates records when the certificates used to reach the cluster expire.
+	// +optional
+	Certificates *CertificatesStatus `json:"certificates,omitempty"`
+}
+
+// CertificatesStatus records when the certificates used to reach a cluster
+// expire, the earliest expiring one where several are given.
+type CertificatesStatus struct {
+	// CANotAfter is when the CA bundle expires.
+	// +optional
+	CANotAfter *metav1.Time `json:"caNotAfter,omitempty"`
+
+	// ClientNotAfter is when the client certificate of the controller
+	// credential, or of the kubeconfig of the source, expires.
+	// +optional
+	ClientNotAfter *metav1.Time `json:"clientNotAfter,omitempty"`
+}
+
+// NodeSummary counts the nodes of a cluster.
+type NodeSummary struct {
+	// Total is the number of nodes.
+	Total int32 `json:"total"`
+
+	// Roles counts the nodes by their node-role.kubernetes.io label. Nodes
+	// without one count as worker.
+	// +optional
+	Roles map[string]int32 `json:"roles,omitempty"`
+}
+
+// Well-known labels classifying registry Clusters, for consumers to select
+// clusters by. They are set by users or the cluster source, the registry
+// does not require them.
+const (
+	// EnvironmentLabel is the environment of the cluster, e.g. prod or staging.
+	EnvironmentLabel = "clusterregistry.k8s.io/environment"
+	// RegionLabel is the region of the cluster, as in Status.Region.
+	RegionLabel = "clusterregistry.k8s.io/region"
+	// ProviderLabel is the infrastructure provider, as in Status.Provider.
+	ProviderLabel = "clusterregistry.k8s.io/provider"
+	// KubernetesVersionLabel is the Kubernetes version the cluster was
+	// provisioned with, e.g. v1.17.3.
+	KubernetesVersionLabel = "clusterregistry.k8s.io/kubernetes-version"
+)
+
+// SourceLabel is set on a registry Cluster to the name of the cluster source
+// that created it, e.g. "cluster-api".
+const SourceLabel = "clusterregistry.k8s.io/source"
+
+// KubeconfigSecretLabel marks a Secret holding a kubeconfig whose contexts
+// are registered as clusters when set to "true".
+const KubeconfigSecretLabel = "clusterregistry.k8s.io/kubeconfig"
+
+// Labels on the Secrets an exporter publishes for a registry Cluster, mapping
+// them back to it.
+const (
+	// ExporterLabel is the name of the exporter, e.g. argocd.
+	ExporterLabel = "clusterregistry.k8s.io/exporter"
+	// ClusterNamespaceLabel is the namespace of the exported registry Cluster.
+	ClusterNamespaceLabel = "clusterregistry.k8s.io/cluster-namespace"
+	// ClusterNameLabel is the name of the exported registry Cluster, cut
+	// and suffixed with a hash when longer than a label value allows.
+	ClusterNameLabel = "clusterregistry.k8s.io/cluster-name"
+)
+
+// ClusterAnnotation is set on the Secrets an exporter publishes to the
+// namespace/name of the exported registry Cluster, in full.
+const ClusterAnnotation = "clusterregistry.k8s.io/cluster"
+
+// InsecureSkipTLSVerifyAnnotation is set to "true" on a registry Cluster whose
+// API server certificate is not verified, as in its source kubeconfig.
+const InsecureSkipTLSVerifyAnnotation = "clusterregistry.k8s.io/insecure-skip-tls-verify"
+
+// Annotations recording the comma separated label and annotation keys the
+// source sets on a registry Cluster, so the ones it stops setting are removed.
+const (
+	SourceLabelsAnnotation      = "clusterregistry.k8s.io/source-labels"
+	SourceAnnotationsAnnotation = "clusterregistry.k8s.io/source-annotations"
+)
+
+// ServerEndpointsAnnotation on a source object, such as a Cluster API Cluster,
+// holds a YAML list of endpoint rules deriving the server endpoint for each
+// client CIDR, overriding the rules configured on the controller.
+const ServerEndpointsAnnotation = "clusterregistry.k8s.io/server-endpoints"
+
+// ControllerKubeconfigKey is the key of the kubeconfig in the Secret that
+// Spec.AuthInfo.Controller references, for controllers to reach the cluster.
+const ControllerKubeconfigKey = "kubeconfig"
+
+// ClusterFinalizer holds a registry Cluster that is being deleted until all of
+// its pre-delete hooks are done.
+const ClusterFinalizer = "clusterregistry.k8s.io/finalizer"
+
+// SourceFinalizer holds a source object, such as a Cluster API Cluster, until
+// the deletion policy has been applied to the registry Clusters created for it.
+const SourceFinalizer = "clusterregistry.k8s.io/registered"
+
+// PreDeleteHookAnnotationPrefix prefixes annotations consumers set on a
+// registry Cluster, e.g. "predelete.hook.clusterregistry.k8s.io/dns", to
+// keep it from disappearing until they have cleaned up and removed them.
+const PreDeleteHookAnnotationPrefix = "predelete.hook.clusterregistry.k8s.io/"
+
+// DeletionPolicyAnnotation overrides the deletion policy of a registry Cluster.
+const DeletionPolicyAnnotation = "clusterregistry.k8s.io/deletion-policy"
+
+// DeletionPolicy decides what happens to a registry Cluster when its source
+// no longer describes it, e.g. because the Cluster API Cluster was deleted.
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyCascade deletes the registry Cluster with its source.
+	DeletionPolicyCascade DeletionPolicy = "Cascade"
+	// DeletionPolicyOrphan keeps the registry Cluster, no longer owned by the source.
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+	// DeletionPolicyRetain keeps the registry Cluster like Orphan and marks it
+	// with the Decommissioned condition.
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+)
+
+// ClusterConditionType marks the kind of cluster condition being reported.
+type ClusterConditionType string
+
+const (
+	// ClusterOK means that the cluster's API server is reachable and ready.
+	ClusterOK ClusterConditionType = "OK"
+
+	// KubeconfigValid means the kubeconfig the cluster is registered from
+	// could be parsed and resolved to an API server. When it is False the
+	// registry keeps the last good endpoints.
+	KubeconfigValid ClusterConditionType = "KubeconfigValid"
+
+	// Decommissioned means the source of the cluster is gone and the registry
+	// entry is only retained for reference. It is no longer probed.
+	Decommissioned ClusterConditionType = "Decommissioned"
+
+	// SourceReady means the source of the cluster passes all gates of its
+	// readiness policy. When it is False the message names the blocking gate,
+	// and the registry keeps the cluster as last registered.
+	SourceReady ClusterConditionType = "SourceReady"
+
+	// CertificateExpiring means a certificate recorded in Status.Certificates
+	// has expired or expires within the window the registry is configured with.
+	CertificateExpiring ClusterConditionType = "CertificateExpiring"
+)
+
+// Condition contains details for one aspect of the current state of a
+// cluster. It has the shape of metav1.Condition in newer Kubernetes releases.
+type Condition struct {
+	// Type of the condition, in CamelCase.
+	Type ClusterConditionType `json:"type"`
+
+	// Status of the condition, one of True, False, Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+
+	// ObservedGeneration is the .metadata.generation the condition was set
+	// based upon.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastTransitionTime is the last time the condition changed from one status to another.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// Reason is a programmatic identifier in CamelCase for the condition's last transition.
+	Reason string `json:"reason"`
+
+	// Message is a human readable message indicating details about the transition.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterList contains a list of cluster
+type ClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Cluster `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Cluster{}, &ClusterList{})
+}
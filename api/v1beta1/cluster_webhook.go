@@ -0,0 +1,270 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// DefaultClientCIDR is the ClientCIDR of a server endpoint serving every client
+const DefaultClientCIDR = "0.0.0.0/0"
+
+// log is for logging in this package.
+var clusterlog = logf.Log.WithName("cluster-resource")
+
+// SetupWebhookWithManager registers the defaulting, validating and conversion
+// webhooks of Cluster
+func (r *Cluster) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-clusterregistry-k8s-io-v1beta1-cluster,mutating=true,failurePolicy=fail,groups=clusterregistry.k8s.io,resources=clusters,verbs=create;update,versions=v1beta1,name=mcluster.kb.io
+
+var _ webhook.Defaulter = &Cluster{}
+
+// Default fills in the ClientCIDR of server endpoints and normalizes their
+// addresses to https://host:port, leaving values it cannot parse to validation
+func (r *Cluster) Default() {
+	clusterlog.Info("default", "name", r.Name)
+
+	endpoints := r.Spec.KubernetesAPIEndpoints.ServerEndpoints
+	for i := range endpoints {
+		if endpoints[i].ClientCIDR == "" {
+			endpoints[i].ClientCIDR = DefaultClientCIDR
+		} else if _, cidr, err := net.ParseCIDR(endpoints[i].ClientCIDR); err == nil {
+			endpoints[i].ClientCIDR = cidr.String()
+		}
+		if address, err := normalizeServerAddress(endpoints[i].ServerAddress); err == nil {
+			endpoints[i].ServerAddress = address
+		}
+	}
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-clusterregistry-k8s-io-v1beta1-cluster,mutating=false,failurePolicy=fail,groups=clusterregistry.k8s.io,resources=clusters,versions=v1beta1,name=vcluster.kb.io
+
+var _ webhook.Validator = &Cluster{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Cluster) ValidateCreate() error {
+	clusterlog.Info("validate create", "name", r.Name)
+	return r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type.
+// Only the errors an update introduces are rejected, so clusters stored
+// before a rule existed can still be labeled, finalized and deleted.
+func (r *Cluster) ValidateUpdate(old runtime.Object) error {
+	clusterlog.Info("validate update", "name", r.Name)
+	oldCluster, ok := old.(*Cluster)
+	if !ok {
+		return r.validate()
+	}
+	if r.DeletionTimestamp != nil || apiequality.Semantic.DeepEqual(r.Spec, oldCluster.Spec) {
+		return nil
+	}
+
+	existing := map[string]bool{}
+	for _, err := range oldCluster.validationErrors() {
+		existing[err.Error()] = true
+	}
+	var errs field.ErrorList
+	for _, err := range r.validationErrors() {
+		if !existing[err.Error()] {
+			errs = append(errs, err)
+		}
+	}
+	return r.invalid(errs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Cluster) ValidateDelete() error {
+	return nil
+}
+
+func (r *Cluster) validate() error {
+	return r.invalid(r.validationErrors())
+}
+
+func (r *Cluster) validationErrors() field.ErrorList {
+	var errs field.ErrorList
+	errs = append(errs, validateEndpoints(&r.Spec.KubernetesAPIEndpoints, field.NewPath("spec", "kubernetesApiEndpoints"))...)
+	errs = append(errs, validateAuthInfo(&r.Spec.AuthInfo, r.Namespace, field.NewPath("spec", "authInfo"))...)
+	errs = append(errs, validateSource(r.Spec.Source, field.NewPath("spec", "source"))...)
+	return errs
+}
+
+func (r *Cluster) invalid(errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Cluster").GroupKind(), r.Name, errs)
+}
+
+// Server endpoints need a valid CIDR and address each. Nested CIDRs are
+// fine, the most specific one wins, but the same network given twice leaves
+// clients with two addresses to pick from.
+func validateEndpoints(endpoints *KubernetesAPIEndpoints, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	seen := map[string]int{}
+	for i, endpoint := range endpoints.ServerEndpoints {
+		p := path.Child("serverEndpoints").Index(i)
+		if _, cidr, err := net.ParseCIDR(endpoint.ClientCIDR); err != nil {
+			errs = append(errs, field.Invalid(p.Child("clientCIDR"), endpoint.ClientCIDR, err.Error()))
+		} else if first, ok := seen[cidr.String()]; ok {
+			errs = append(errs, field.Invalid(p.Child("clientCIDR"), endpoint.ClientCIDR,
+				fmt.Sprintf("overlaps serverEndpoints[%d]", first)))
+		} else {
+			seen[cidr.String()] = i
+		}
+		if _, err := normalizeServerAddress(endpoint.ServerAddress); err != nil {
+			errs = append(errs, field.Invalid(p.Child("serverAddress"), endpoint.ServerAddress, err.Error()))
+		}
+	}
+
+	if len(endpoints.CABundle) > 0 {
+		if err := validateCABundle(endpoints.CABundle); err != nil {
+			errs = append(errs, field.Invalid(path.Child("caBundle"), "", err.Error()))
+		}
+	}
+	return errs
+}
+
+// validateCABundle requires PEM encoded certificates and nothing else
+func validateCABundle(data []byte) error {
+	rest := data
+	certificates := 0
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return err
+		}
+		certificates++
+	}
+	if certificates == 0 || len(strings.TrimSpace(string(rest))) > 0 {
+		return fmt.Errorf("not a PEM encoded certificate bundle")
+	}
+	return nil
+}
+
+// The kinds AuthInfo may reference. Controller credentials are always kept
+// in Secrets, user information may be public.
+var (
+	supportedUserKinds       = []string{"Secret", "ConfigMap"}
+	supportedControllerKinds = []string{"Secret"}
+)
+
+func validateAuthInfo(authInfo *AuthInfo, namespace string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	errs = append(errs, validateReference(authInfo.User, supportedUserKinds, namespace, path.Child("user"))...)
+	errs = append(errs, validateReference(authInfo.Controller, supportedControllerKinds, namespace, path.Child("controller"))...)
+	return errs
+}
+
+// References stay within the namespace of the cluster, so creating a Cluster
+// grants no access to objects of other namespaces
+func validateReference(ref *ObjectReference, kinds []string, namespace string, path *field.Path) field.ErrorList {
+	if ref == nil {
+		return nil
+	}
+	var errs field.ErrorList
+	supported := false
+	for _, kind := range kinds {
+		supported = supported || ref.Kind == kind
+	}
+	if !supported {
+		errs = append(errs, field.NotSupported(path.Child("kind"), ref.Kind, kinds))
+	}
+	if ref.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), ""))
+	}
+	if ref.Namespace != "" && ref.Namespace != namespace {
+		errs = append(errs, field.Invalid(path.Child("namespace"), ref.Namespace, "must be empty or the namespace of the cluster"))
+	}
+	return errs
+}
+
+func validateSource(source *SourceReference, path *field.Path) field.ErrorList {
+	if source == nil {
+		return nil
+	}
+	var errs field.ErrorList
+	if source.APIVersion == "" {
+		errs = append(errs, field.Required(path.Child("apiVersion"), ""))
+	}
+	if source.Kind == "" {
+		errs = append(errs, field.Required(path.Child("kind"), ""))
+	}
+	if source.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), ""))
+	}
+	return errs
+}
+
+// normalizeServerAddress turns host, host:port or a URL into
+// scheme://host:port, defaulting to https and the scheme's port
+func normalizeServerAddress(address string) (string, error) {
+	if address == "" {
+		return "", fmt.Errorf("server address is required")
+	}
+	if !strings.Contains(address, "://") {
+		address = "https://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("server address has no host")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	u.Host = net.JoinHostPort(u.Hostname(), port)
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newCABundle returns a PEM encoded self-signed CA certificate
+func newCABundle(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubernetes"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestNormalizeServerAddress(t *testing.T) {
+	for address, expected := range map[string]string{
+		"10.0.0.1":                   "https://10.0.0.1:443",
+		"10.0.0.1:6443":              "https://10.0.0.1:6443",
+		"https://api.example.com":    "https://api.example.com:443",
+		"http://api.example.com":     "http://api.example.com:80",
+		"https://api.example.com/":   "https://api.example.com:443",
+		"https://[fd00::1]:6443":     "https://[fd00::1]:6443",
+		"https://proxy/k8s/clusters": "https://proxy:443/k8s/clusters",
+	} {
+		if normalized, err := normalizeServerAddress(address); err != nil || normalized != expected {
+			t.Errorf("%s: expected %s, got %s, %v", address, expected, normalized, err)
+		}
+	}
+
+	for _, address := range []string{"", "ftp://api.example.com", "https://", "https://api:port", "https://api:70000"} {
+		if _, err := normalizeServerAddress(address); err == nil {
+			t.Errorf("%q: expected an error", address)
+		}
+	}
+}
+
+func TestClusterDefault(t *testing.T) {
+	cluster := &Cluster{}
+	cluster.Spec.KubernetesAPIEndpoints.ServerEndpoints = []ServerAddressByClientCIDR{
+		{ServerAddress: "10.0.0.1:6443"},
+		{ClientCIDR: "10.1.2.3/8", ServerAddress: "not a url:"},
+	}
+	cluster.Default()
+
+	endpoints := cluster.Spec.KubernetesAPIEndpoints.ServerEndpoints
+	if endpoints[0].ClientCIDR != DefaultClientCIDR || endpoints[0].ServerAddress != "https://10.0.0.1:6443" {
+		t.Errorf("unexpected defaults %+v", endpoints[0])
+	}
+	if endpoints[1].ClientCIDR != "10.0.0.0/8" || endpoints[1].ServerAddress != "not a url:" {
+		t.Errorf("expected the CIDR normalized and the address left to validation, got %+v", endpoints[1])
+	}
+}
+
+func TestClusterValidate(t *testing.T) {
+	ca := newCABundle(t)
+	valid := func() *Cluster {
+		cluster := &Cluster{}
+		cluster.Name = "valid"
+		cluster.Spec.KubernetesAPIEndpoints = KubernetesAPIEndpoints{
+			ServerEndpoints: []ServerAddressByClientCIDR{
+				{ClientCIDR: "0.0.0.0/0", ServerAddress: "https://external:6443"},
+				{ClientCIDR: "10.0.0.0/8", ServerAddress: "https://internal:6443"},
+			},
+			CABundle: ca,
+		}
+		cluster.Spec.AuthInfo.Controller = &ObjectReference{Kind: "Secret", Name: "credentials"}
+		return cluster
+	}
+	if err := valid().ValidateCreate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for field, mutate := range map[string]func(*Cluster){
+		"clientCIDR": func(c *Cluster) { c.Spec.KubernetesAPIEndpoints.ServerEndpoints[1].ClientCIDR = "10.0.0.0" },
+		"serverEndpoints[1].clientCIDR": func(c *Cluster) {
+			c.Spec.KubernetesAPIEndpoints.ServerEndpoints[1].ClientCIDR = "0.1.2.3/0"
+		},
+		"serverAddress": func(c *Cluster) { c.Spec.KubernetesAPIEndpoints.ServerEndpoints[0].ServerAddress = "ftp://external" },
+		"caBundle":      func(c *Cluster) { c.Spec.KubernetesAPIEndpoints.CABundle = []byte("ca") },
+		"caBundle ":     func(c *Cluster) { c.Spec.KubernetesAPIEndpoints.CABundle = append(ca, []byte("trailing")...) },
+		"controller.kind": func(c *Cluster) {
+			c.Spec.AuthInfo.Controller.Kind = "ConfigMap"
+		},
+		"user.name": func(c *Cluster) { c.Spec.AuthInfo.User = &ObjectReference{Kind: "ConfigMap"} },
+		"controller.namespace": func(c *Cluster) {
+			c.Spec.AuthInfo.Controller.Namespace = "other-tenant"
+		},
+		"source.apiVersion": func(c *Cluster) {
+			c.Spec.Source = &SourceReference{Kind: "Cluster", Name: "workload"}
+		},
+	} {
+		cluster := valid()
+		mutate(cluster)
+		err := cluster.ValidateUpdate(valid())
+		if err == nil || !strings.Contains(err.Error(), strings.TrimSpace(field)) {
+			t.Errorf("%s: expected an error on the field, got %v", field, err)
+		}
+	}
+}
+
+func TestClusterValidateUpdateOfInvalidCluster(t *testing.T) {
+	stored := &Cluster{}
+	stored.Name = "stored"
+	stored.Spec.KubernetesAPIEndpoints.ServerEndpoints = []ServerAddressByClientCIDR{
+		{ClientCIDR: "0.0.0.0/0", ServerAddress: "https://external:6443"},
+	}
+	// stored before the CA bundle was validated
+	stored.Spec.KubernetesAPIEndpoints.CABundle = []byte("ca")
+
+	labeled := stored.DeepCopy()
+	labeled.Labels = map[string]string{EnvironmentLabel: "prod"}
+	if err := labeled.ValidateUpdate(stored); err != nil {
+		t.Errorf("expected a metadata update to be accepted, got %v", err)
+	}
+
+	moved := stored.DeepCopy()
+	moved.Spec.KubernetesAPIEndpoints.ServerEndpoints[0].ServerAddress = "https://moved:6443"
+	if err := moved.ValidateUpdate(stored); err != nil {
+		t.Errorf("expected an update keeping the invalid CA bundle to be accepted, got %v", err)
+	}
+	moved.Spec.KubernetesAPIEndpoints.ServerEndpoints[0].ServerAddress = "ftp://moved"
+	if err := moved.ValidateUpdate(stored); err == nil || !strings.Contains(err.Error(), "serverAddress") || strings.Contains(err.Error(), "caBundle") {
+		t.Errorf("expected only the new invalid address to be rejected, got %v", err)
+	}
+
+	deleting := moved.DeepCopy()
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	deleting.Finalizers = nil
+	if err := deleting.ValidateUpdate(moved); err != nil {
+		t.Errorf("expected the finalizer removal of a deleted cluster to be accepted, got %v", err)
+	}
+}
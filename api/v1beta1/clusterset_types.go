@@ -0,0 +1,106 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type="integer",JSONPath=".status.ready",description="Number of member clusters whose OK condition is True"
+// +kubebuilder:printcolumn:name="Total",type="integer",JSONPath=".status.total",description="Number of member clusters"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ClusterSet groups registry Clusters of its namespace, for tools to target
+// all of them as one object.
+type ClusterSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec selects the member clusters of the set.
+	// +optional
+	Spec ClusterSetSpec `json:"spec,omitempty"`
+
+	// Status lists the member clusters of the set and their health.
+	// +optional
+	Status ClusterSetStatus `json:"status,omitempty"`
+}
+
+// ClusterSetSpec selects the member clusters of a ClusterSet: the registry
+// Clusters the selector matches and those listed by name.
+type ClusterSetSpec struct {
+	// Selector matches the labels of member clusters. An empty selector
+	// matches all clusters of the namespace, a missing one none.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// Clusters lists member clusters by name.
+	// +optional
+	Clusters []string `json:"clusters,omitempty"`
+}
+
+// ClusterSetStatus lists the member clusters of a ClusterSet as last observed.
+type ClusterSetStatus struct {
+	// ObservedGeneration is the .metadata.generation the status was computed for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Clusters lists the member clusters, sorted by name.
+	// +optional
+	Clusters []ClusterSetMember `json:"clusters,omitempty"`
+
+	// Missing lists the clusters in Spec.Clusters that do not exist.
+	// +optional
+	Missing []string `json:"missing,omitempty"`
+
+	// Ready counts the member clusters whose OK condition is True.
+	Ready int32 `json:"ready"`
+
+	// Total counts the member clusters.
+	Total int32 `json:"total"`
+
+	// Conditions contains the different condition statuses for this set.
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// ClusterSetSelectorValid tells whether Spec.Selector of a ClusterSet parses.
+// While it is False, the members last observed are kept.
+const ClusterSetSelectorValid ClusterConditionType = "SelectorValid"
+
+// ClusterSetMember is a member cluster of a ClusterSet.
+type ClusterSetMember struct {
+	// Name of the registry Cluster.
+	Name string `json:"name"`
+
+	// Ready tells whether the OK condition of the cluster is True.
+	Ready bool `json:"ready"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterSetList contains a list of ClusterSet
+type ClusterSetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterSet `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterSet{}, &ClusterSetList{})
+}
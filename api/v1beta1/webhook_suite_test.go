@@ -0,0 +1,272 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1alpha1"
+	. "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+var k8sClient client.Client
+var testEnv *envtest.Environment
+var stopMgr chan struct{}
+
+func TestAPIs(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecsWithDefaultAndCustomReporters(t,
+		"Webhook Suite",
+		[]Reporter{})
+}
+
+// loadWebhooks reads the webhook configurations of config/webhook. envtest
+// turns service paths into URLs as "https://host/" + path, so the leading
+// slash is dropped to keep the webhook server from redirecting.
+func loadWebhooks() (mutating, validating []runtime.Object) {
+	data, err := ioutil.ReadFile(filepath.Join("..", "..", "config", "webhook", "manifests.yaml"))
+	Expect(err).ToNot(HaveOccurred())
+
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(string(data)), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			break
+		}
+		if obj.Object == nil {
+			continue
+		}
+		webhooks, _, _ := unstructured.NestedSlice(obj.Object, "webhooks")
+		for _, w := range webhooks {
+			service := w.(map[string]interface{})["clientConfig"].(map[string]interface{})["service"].(map[string]interface{})
+			service["path"] = strings.TrimPrefix(service["path"].(string), "/")
+		}
+		Expect(unstructured.SetNestedSlice(obj.Object, webhooks, "webhooks")).To(Succeed())
+		switch obj.GetKind() {
+		case "MutatingWebhookConfiguration":
+			mutating = append(mutating, obj)
+		case "ValidatingWebhookConfiguration":
+			validating = append(validating, obj)
+		}
+	}
+	return mutating, validating
+}
+
+var _ = BeforeSuite(func(done Done) {
+	logf.SetLogger(zap.LoggerTo(GinkgoWriter, true))
+
+	By("bootstrapping test environment with webhooks")
+	mutating, validating := loadWebhooks()
+	testEnv = &envtest.Environment{
+		CRDDirectoryPaths: []string{filepath.Join("..", "..", "config", "crd", "bases")},
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			MutatingWebhooks:   mutating,
+			ValidatingWebhooks: validating,
+		},
+	}
+
+	cfg, err := testEnv.Start()
+	Expect(err).ToNot(HaveOccurred())
+	Expect(cfg).ToNot(BeNil())
+
+	Expect(AddToScheme(scheme.Scheme)).To(Succeed())
+	Expect(v1alpha1.AddToScheme(scheme.Scheme)).To(Succeed())
+	Expect(apiextensionsv1beta1.AddToScheme(scheme.Scheme)).To(Succeed())
+
+	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	Expect(err).ToNot(HaveOccurred())
+
+	options := testEnv.WebhookInstallOptions
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             scheme.Scheme,
+		MetricsBindAddress: "0",
+		Host:               options.LocalServingHost,
+		Port:               options.LocalServingPort,
+		CertDir:            options.LocalServingCertDir,
+	})
+	Expect(err).ToNot(HaveOccurred())
+	Expect((&Cluster{}).SetupWebhookWithManager(mgr)).To(Succeed())
+	Expect((&v1alpha1.Cluster{}).SetupWebhookWithManager(mgr)).To(Succeed())
+
+	stopMgr = make(chan struct{})
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(stopMgr)).To(Succeed())
+	}()
+
+	By("waiting for the webhook server")
+	address := net.JoinHostPort(options.LocalServingHost, fmt.Sprint(options.LocalServingPort))
+	Eventually(func() error {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}, 10*time.Second).Should(Succeed())
+
+	By("converting Clusters with the webhook server")
+	ca, err := ioutil.ReadFile(filepath.Join(options.LocalServingCertDir, "tls.crt"))
+	Expect(err).ToNot(HaveOccurred())
+	crd := &apiextensionsv1beta1.CustomResourceDefinition{}
+	Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: "clusters.clusterregistry.k8s.io"}, crd)).To(Succeed())
+	url := fmt.Sprintf("https://%s/convert", address)
+	crd.Spec.Conversion = &apiextensionsv1beta1.CustomResourceConversion{
+		Strategy:                 apiextensionsv1beta1.WebhookConverter,
+		WebhookClientConfig:      &apiextensionsv1beta1.WebhookClientConfig{URL: &url, CABundle: ca},
+		ConversionReviewVersions: []string{"v1beta1"},
+	}
+	Expect(k8sClient.Update(context.Background(), crd)).To(Succeed())
+
+	close(done)
+}, 60)
+
+var _ = AfterSuite(func() {
+	By("tearing down the test environment")
+	close(stopMgr)
+	Expect(testEnv.Stop()).To(Succeed())
+})
+
+var _ = Describe("Cluster webhooks", func() {
+	ctx := context.Background()
+
+	newCluster := func(name string, endpoints ...ServerAddressByClientCIDR) *Cluster {
+		return &Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: ClusterSpec{
+				KubernetesAPIEndpoints: KubernetesAPIEndpoints{ServerEndpoints: endpoints},
+			},
+		}
+	}
+
+	It("defaults the client CIDR and normalizes the server address", func() {
+		cluster := newCluster("defaulted", ServerAddressByClientCIDR{ServerAddress: "10.0.0.1:6443"})
+		Expect(k8sClient.Create(ctx, cluster)).To(Succeed())
+
+		stored := &Cluster{}
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "defaulted"}, stored)).To(Succeed())
+		Expect(stored.Spec.KubernetesAPIEndpoints.ServerEndpoints).To(Equal([]ServerAddressByClientCIDR{
+			{ClientCIDR: DefaultClientCIDR, ServerAddress: "https://10.0.0.1:6443"},
+		}))
+	})
+
+	It("accepts nested client CIDRs", func() {
+		cluster := newCluster("nested",
+			ServerAddressByClientCIDR{ClientCIDR: "0.0.0.0/0", ServerAddress: "https://external:6443"},
+			ServerAddressByClientCIDR{ClientCIDR: "10.0.0.0/8", ServerAddress: "https://internal:6443"})
+		Expect(k8sClient.Create(ctx, cluster)).To(Succeed())
+	})
+
+	DescribeTable("rejects invalid clusters",
+		func(field string, mutate func(*Cluster)) {
+			cluster := newCluster("invalid", ServerAddressByClientCIDR{ClientCIDR: "0.0.0.0/0", ServerAddress: "https://external:6443"})
+			mutate(cluster)
+			err := k8sClient.Create(ctx, cluster)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "expected an invalid error, got %v", err)
+			Expect(err.Error()).To(ContainSubstring(field))
+		},
+		Entry("invalid CIDR", "clientCIDR", func(c *Cluster) {
+			c.Spec.KubernetesAPIEndpoints.ServerEndpoints[0].ClientCIDR = "10.0.0.0/33"
+		}),
+		Entry("unparseable server address", "serverAddress", func(c *Cluster) {
+			c.Spec.KubernetesAPIEndpoints.ServerEndpoints[0].ServerAddress = "ftp://external"
+		}),
+		Entry("overlapping CIDRs", "overlaps", func(c *Cluster) {
+			c.Spec.KubernetesAPIEndpoints.ServerEndpoints = append(c.Spec.KubernetesAPIEndpoints.ServerEndpoints,
+				ServerAddressByClientCIDR{ClientCIDR: "0.0.0.0/0", ServerAddress: "https://other:6443"})
+		}),
+		Entry("malformed PEM", "caBundle", func(c *Cluster) {
+			c.Spec.KubernetesAPIEndpoints.CABundle = []byte("-----BEGIN CERTIFICATE-----\nnot base64\n-----END CERTIFICATE-----\n")
+		}),
+		Entry("unsupported controller kind", "controller.kind", func(c *Cluster) {
+			c.Spec.AuthInfo.Controller = &ObjectReference{Kind: "ServiceAccount", Name: "registry"}
+		}),
+	)
+
+	It("validates updates", func() {
+		cluster := newCluster("updated", ServerAddressByClientCIDR{ServerAddress: "https://external:6443"})
+		Expect(k8sClient.Create(ctx, cluster)).To(Succeed())
+
+		cluster.Spec.KubernetesAPIEndpoints.CABundle = []byte("ca")
+		err := k8sClient.Update(ctx, cluster)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "expected an invalid error, got %v", err)
+	})
+
+	It("defaults and validates v1alpha1 Clusters as v1beta1", func() {
+		cluster := &v1alpha1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "alpha", Namespace: "default"},
+		}
+		cluster.Spec.KubernetesAPIEndpoints.ServerEndpoints = []v1alpha1.ServerAddressByClientCIDR{{ServerAddress: "10.0.0.2:6443"}}
+		Expect(k8sClient.Create(ctx, cluster)).To(Succeed())
+
+		stored := &Cluster{}
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "alpha"}, stored)).To(Succeed())
+		Expect(stored.Spec.KubernetesAPIEndpoints.ServerEndpoints).To(Equal([]ServerAddressByClientCIDR{
+			{ClientCIDR: DefaultClientCIDR, ServerAddress: "https://10.0.0.2:6443"},
+		}))
+
+		invalid := &v1alpha1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "alpha-invalid", Namespace: "default"},
+		}
+		invalid.Spec.KubernetesAPIEndpoints.ServerEndpoints = []v1alpha1.ServerAddressByClientCIDR{{ServerAddress: "ftp://external"}}
+		err := k8sClient.Create(ctx, invalid)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "expected an invalid error, got %v", err)
+	})
+
+	It("keeps v1beta1 fields through v1alpha1 updates", func() {
+		cluster := newCluster("sourced", ServerAddressByClientCIDR{ServerAddress: "https://external:6443"})
+		cluster.Spec.Source = &SourceReference{APIVersion: "cluster.x-k8s.io/v1alpha3", Kind: "Cluster", Namespace: "default", Name: "workload"}
+		Expect(k8sClient.Create(ctx, cluster)).To(Succeed())
+
+		alpha := &v1alpha1.Cluster{}
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "sourced"}, alpha)).To(Succeed())
+		Expect(alpha.Annotations).To(HaveKey(v1alpha1.ConversionDataAnnotation))
+		alpha.Labels = map[string]string{EnvironmentLabel: "prod"}
+		Expect(k8sClient.Update(ctx, alpha)).To(Succeed())
+
+		stored := &Cluster{}
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "sourced"}, stored)).To(Succeed())
+		Expect(stored.Labels).To(HaveKeyWithValue(EnvironmentLabel, "prod"))
+		Expect(stored.Spec.Source).To(Equal(cluster.Spec.Source))
+		Expect(stored.Annotations).NotTo(HaveKey(v1alpha1.ConversionDataAnnotation))
+	})
+})
@@ -0,0 +1,163 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+	"github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/controllers"
+)
+
+// Credentials the kubeconfig command can inline
+const (
+	credentialNone       = "none"
+	credentialController = "controller"
+)
+
+// kubeconfigCommand prints a kubeconfig for a registry Cluster, or merges it
+// into the kubeconfig of the caller
+type kubeconfigCommand struct {
+	clientIP   string
+	credential string
+	user       string
+	merge      bool
+}
+
+func (c *kubeconfigCommand) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&c.clientIP, "client-ip", "", "Address to pick the server endpoint for, the local address routing to the hub cluster when empty")
+	flags.StringVar(&c.credential, "credential", credentialNone, "Credential to inline: none, or controller for the controller credential of the cluster")
+	flags.StringVar(&c.user, "user", "", "User of your kubeconfig for the context to use, e.g. an OIDC login; needs --merge")
+	flags.BoolVar(&c.merge, "merge", false, "Merge the cluster into your kubeconfig instead of printing it")
+}
+
+func (c *kubeconfigCommand) run(p *plugin, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("kubeconfig takes the name of a registry Cluster, got %v", args)
+	}
+	if c.user != "" && (!c.merge || c.credential != credentialNone) {
+		return fmt.Errorf("--user needs --merge and no --credential")
+	}
+
+	ctx := context.Background()
+	clusterreg := &clusterregistryv1beta1.Cluster{}
+	if err := p.client.Get(ctx, client.ObjectKey{Namespace: p.namespace, Name: args[0]}, clusterreg); err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(c.clientIP)
+	if c.clientIP == "" {
+		local, err := localAddress(p.host)
+		if err != nil {
+			return fmt.Errorf("unable to tell the local address, set --client-ip: %v", err)
+		}
+		ip = local
+	} else if ip == nil {
+		return fmt.Errorf("invalid --client-ip %q", c.clientIP)
+	}
+	server, ok := clusterreg.Spec.KubernetesAPIEndpoints.ServerAddressFor(ip)
+	if !ok {
+		return fmt.Errorf("no server endpoint of %s serves clients at %s, pick another --client-ip", clusterreg.Name, ip)
+	}
+
+	var credential []byte
+	switch c.credential {
+	case credentialNone:
+	case credentialController:
+		kubeconfig, err := controllers.ControllerKubeconfig(ctx, p.client, clusterreg)
+		if err != nil {
+			return err
+		}
+		if kubeconfig == nil {
+			return fmt.Errorf("registry Cluster %s has no controller credential", clusterreg.Name)
+		}
+		credential = kubeconfig
+	default:
+		return fmt.Errorf("unknown --credential %q, use %s or %s", c.credential, credentialNone, credentialController)
+	}
+	config, err := controllers.KubeconfigFor(clusterreg, server, credential)
+	if err != nil {
+		return err
+	}
+
+	if !c.merge {
+		data, err := clientcmd.Write(*config)
+		if err != nil {
+			return err
+		}
+		_, err = p.out.Write(data)
+		return err
+	}
+	options := clientcmd.NewDefaultPathOptions()
+	existing, err := options.GetStartingConfig()
+	if err != nil {
+		return err
+	}
+	if err := mergeKubeconfig(existing, config, clusterreg.Name, c.user); err != nil {
+		return err
+	}
+	if err := clientcmd.ModifyConfig(options, *existing, false); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "Context %q reaches %s at %s, switch to it with kubectl config use-context %s\n",
+		clusterreg.Name, clusterreg.Name, server, clusterreg.Name)
+	return nil
+}
+
+// Merge the current context of a generated kubeconfig into an existing one as
+// the cluster, context and user name, replacing entries of that name. The
+// context uses the existing user when one is given, which must exist.
+func mergeKubeconfig(into, from *clientcmdapi.Config, name, user string) error {
+	current := from.Contexts[from.CurrentContext]
+	if current == nil || from.Clusters[current.Cluster] == nil {
+		return fmt.Errorf("kubeconfig of %s has no current context", name)
+	}
+	into.Clusters[name] = from.Clusters[current.Cluster]
+	context := clientcmdapi.NewContext()
+	context.Cluster = name
+	switch {
+	case user != "":
+		if into.AuthInfos[user] == nil {
+			return fmt.Errorf("your kubeconfig has no user %q", user)
+		}
+		context.AuthInfo = user
+	case from.AuthInfos[current.AuthInfo] != nil:
+		into.AuthInfos[name] = from.AuthInfos[current.AuthInfo]
+		context.AuthInfo = name
+	}
+	into.Contexts[name] = context
+	return nil
+}
+
+// The local address routing to host:port, as the server endpoints of a
+// registry Cluster are picked by the client CIDR of the caller. Dialing UDP
+// only looks up the route, nothing is sent.
+func localAddress(host string) (net.IP, error) {
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func generatedKubeconfig(user *clientcmdapi.AuthInfo) *clientcmdapi.Config {
+	config := clientcmdapi.NewConfig()
+	config.Clusters["prod-1"] = &clientcmdapi.Cluster{Server: "https://10.0.0.10:6443"}
+	config.Contexts["prod-1"] = &clientcmdapi.Context{Cluster: "prod-1"}
+	if user != nil {
+		config.AuthInfos["registry-controller"] = user
+		config.Contexts["prod-1"].AuthInfo = "registry-controller"
+	}
+	config.CurrentContext = "prod-1"
+	return config
+}
+
+func TestMergeKubeconfig(t *testing.T) {
+	existing := func() *clientcmdapi.Config {
+		config := clientcmdapi.NewConfig()
+		config.Clusters["hub"] = &clientcmdapi.Cluster{Server: "https://hub:6443"}
+		config.AuthInfos["oidc"] = &clientcmdapi.AuthInfo{Token: "id-token"}
+		config.Contexts["hub"] = &clientcmdapi.Context{Cluster: "hub", AuthInfo: "oidc"}
+		config.Contexts["prod-1"] = &clientcmdapi.Context{Cluster: "stale"}
+		config.CurrentContext = "hub"
+		return config
+	}
+
+	into := existing()
+	if err := mergeKubeconfig(into, generatedKubeconfig(&clientcmdapi.AuthInfo{Token: "controller"}), "prod-1", ""); err != nil {
+		t.Fatal(err)
+	}
+	context := into.Contexts["prod-1"]
+	if context.Cluster != "prod-1" || context.AuthInfo != "prod-1" || into.AuthInfos["prod-1"].Token != "controller" ||
+		into.Clusters["prod-1"].Server != "https://10.0.0.10:6443" {
+		t.Errorf("expected the cluster and its credential to be merged, got %+v", context)
+	}
+	if into.CurrentContext != "hub" || into.Contexts["hub"].AuthInfo != "oidc" {
+		t.Errorf("expected the existing entries to be kept, got %+v", into)
+	}
+
+	into = existing()
+	if err := mergeKubeconfig(into, generatedKubeconfig(nil), "prod-1", "oidc"); err != nil {
+		t.Fatal(err)
+	}
+	if into.Contexts["prod-1"].AuthInfo != "oidc" || into.AuthInfos["prod-1"] != nil {
+		t.Errorf("expected the context to use the existing user, got %+v", into.Contexts["prod-1"])
+	}
+
+	if err := mergeKubeconfig(existing(), generatedKubeconfig(nil), "prod-1", "missing"); err == nil {
+		t.Error("expected a missing user to be rejected")
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// listCommand lists registry Clusters with their health
+type listCommand struct {
+	allNamespaces bool
+	selector      string
+}
+
+func (c *listCommand) addFlags(flags *pflag.FlagSet) {
+	flags.BoolVarP(&c.allNamespaces, "all-namespaces", "A", false, "List the registry Clusters of all namespaces")
+	flags.StringVarP(&c.selector, "selector", "l", "", "Label selector of the registry Clusters to list")
+}
+
+func (c *listCommand) run(p *plugin, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("list takes no arguments, got %v", args)
+	}
+	var opts []client.ListOption
+	if !c.allNamespaces {
+		opts = append(opts, client.InNamespace(p.namespace))
+	}
+	if c.selector != "" {
+		selector, err := labels.Parse(c.selector)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, client.MatchingLabelsSelector{Selector: selector})
+	}
+	list := &clusterregistryv1beta1.ClusterList{}
+	if err := p.client.List(context.Background(), list, opts...); err != nil {
+		return err
+	}
+	return printClusters(p.out, list.Items, c.allNamespaces, time.Now())
+}
+
+// Print registry Clusters as a table in the way of kubectl get, with the
+// status and reason of their OK condition
+func printClusters(out io.Writer, clusters []clusterregistryv1beta1.Cluster, namespaces bool, now time.Time) error {
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Namespace != clusters[j].Namespace {
+			return clusters[i].Namespace < clusters[j].Namespace
+		}
+		return clusters[i].Name < clusters[j].Name
+	})
+
+	w := tabwriter.NewWriter(out, 6, 4, 3, ' ', 0)
+	if namespaces {
+		fmt.Fprint(w, "NAMESPACE\t")
+	}
+	fmt.Fprintln(w, "NAME\tOK\tREASON\tVERSION\tNODES\tAGE")
+	for _, cluster := range clusters {
+		ok, reason := "Unknown", "<none>"
+		if condition := cluster.Status.GetCondition(clusterregistryv1beta1.ClusterOK); condition != nil {
+			ok = string(condition.Status)
+			if condition.Reason != "" {
+				reason = condition.Reason
+			}
+		}
+		version := cluster.Status.Version
+		if version == "" {
+			version = "<none>"
+		}
+		nodes := "<none>"
+		if cluster.Status.Nodes != nil {
+			nodes = fmt.Sprint(cluster.Status.Nodes.Total)
+		}
+		age := "<unknown>"
+		if !cluster.CreationTimestamp.IsZero() {
+			age = duration.HumanDuration(now.Sub(cluster.CreationTimestamp.Time))
+		}
+
+		if namespaces {
+			fmt.Fprintf(w, "%s\t", cluster.Namespace)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", cluster.Name, ok, reason, version, nodes, age)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+func TestPrintClusters(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	clusters := []clusterregistryv1beta1.Cluster{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "prod-2", Namespace: "clusters", CreationTimestamp: metav1.NewTime(now.Add(-3 * time.Hour))},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "prod-1", Namespace: "clusters", CreationTimestamp: metav1.NewTime(now.Add(-48 * time.Hour))},
+			Status: clusterregistryv1beta1.ClusterStatus{
+				Conditions: []clusterregistryv1beta1.Condition{{Type: clusterregistryv1beta1.ClusterOK, Status: corev1.ConditionFalse, Reason: "HealthCheckFailed"}},
+				Version:    "v1.17.3",
+				Nodes:      &clusterregistryv1beta1.NodeSummary{Total: 5},
+			},
+		},
+	}
+
+	out := &bytes.Buffer{}
+	if err := printClusters(out, clusters, true, now); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a header and two clusters, got %q", out.String())
+	}
+	for i, want := range [][]string{
+		{"NAMESPACE", "NAME", "OK", "REASON", "VERSION", "NODES", "AGE"},
+		{"clusters", "prod-1", "False", "HealthCheckFailed", "v1.17.3", "5", "2d"},
+		{"clusters", "prod-2", "Unknown", "<none>", "<none>", "<none>", "3h"},
+	} {
+		if got := strings.Fields(lines[i]); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("line %d: expected %v, got %v", i, want, got)
+		}
+	}
+}
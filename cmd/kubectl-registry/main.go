@@ -0,0 +1,153 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-registry is a kubectl plugin to inspect the cluster registry of a
+// hub cluster: kubectl registry list|kubeconfig|why
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+const usage = `Inspect the registry Clusters of the hub cluster.
+
+Usage:
+  kubectl registry list [-A] [-l SELECTOR]
+  kubectl registry kubeconfig NAME [--client-ip IP] [--credential none|controller] [--user USER] [--merge]
+  kubectl registry why NAME [--readiness-policies NAMESPACE/NAME]
+
+Commands:
+  list        List registry Clusters with their health
+  kubeconfig  Print a kubeconfig for a registry Cluster, or merge it into your kubeconfig
+  why         Explain why a Cluster API Cluster is not registered (yet)
+
+Every command takes --kubeconfig, --context and -n/--namespace to select the
+hub cluster and namespace; run kubectl registry COMMAND --help for its flags.
+`
+
+// plugin holds the connection to the hub cluster the commands run against
+type plugin struct {
+	client    client.Client
+	discovery discovery.DiscoveryInterface
+	// namespace of the registry Clusters, the one of the context by default
+	namespace string
+	// host of the hub API server, whose route tells the caller's address
+	host string
+	out  io.Writer
+}
+
+// command is a subcommand with its own flags, run on the remaining arguments
+type command interface {
+	addFlags(flags *pflag.FlagSet)
+	run(p *plugin, args []string) error
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Fprint(out, usage)
+		return nil
+	}
+
+	var cmd command
+	switch args[0] {
+	case "list":
+		cmd = &listCommand{}
+	case "kubeconfig":
+		cmd = &kubeconfigCommand{}
+	case "why":
+		cmd = &whyCommand{}
+	default:
+		return fmt.Errorf("unknown command %q, see kubectl registry help", args[0])
+	}
+
+	flags := pflag.NewFlagSet("kubectl registry "+args[0], pflag.ContinueOnError)
+	overrides := &clientcmd.ConfigOverrides{}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	flags.StringVar(&rules.ExplicitPath, "kubeconfig", "", "Path to the kubeconfig of the hub cluster")
+	flags.StringVar(&overrides.CurrentContext, "context", "", "Kubeconfig context of the hub cluster")
+	flags.StringVarP(&overrides.Context.Namespace, "namespace", "n", "", "Namespace of the registry Clusters, the one of the context when empty")
+	cmd.addFlags(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		if err == pflag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	p, err := connect(clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides), out)
+	if err != nil {
+		return err
+	}
+	return cmd.run(p, flags.Args())
+}
+
+// Connect to the hub cluster of a kubeconfig
+func connect(config clientcmd.ClientConfig, out io.Writer) (*plugin, error) {
+	namespace, _, err := config.Namespace()
+	if err != nil {
+		return nil, err
+	}
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := clusterregistryv1beta1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	host := restConfig.Host
+	if u, err := url.Parse(restConfig.Host); err == nil && u.Host != "" {
+		host = u.Host
+		if u.Port() == "" {
+			host += ":443"
+		}
+	}
+	return &plugin{client: c, discovery: discoveryClient, namespace: namespace, host: host, out: out}, nil
+}
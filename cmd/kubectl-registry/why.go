@@ -0,0 +1,202 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/duration"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+	"github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/controllers"
+	"github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/options"
+)
+
+// recentEvents is how many of the latest Events on a Cluster API Cluster why shows
+const recentEvents = 5
+
+// whyCommand explains why a Cluster API Cluster is not registered (yet)
+type whyCommand struct {
+	readinessPolicies string
+}
+
+func (c *whyCommand) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&c.readinessPolicies, "readiness-policies", "",
+		"Namespace/name of the readiness policies ConfigMap the manager runs with, the built-in readiness check if empty")
+}
+
+func (c *whyCommand) run(p *plugin, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("why takes the name of a Cluster API Cluster, got %v", args)
+	}
+	ctx := context.Background()
+	policies, err := options.ParseObjectKey(c.readinessPolicies)
+	if err != nil {
+		return fmt.Errorf("--readiness-policies: %v", err)
+	}
+	version, err := controllers.DetectClusterAPIVersion(p.discovery)
+	if err != nil {
+		return err
+	}
+	cluster := &unstructured.Unstructured{}
+	cluster.SetGroupVersionKind(schema.GroupVersionKind{Group: controllers.ClusterAPIGroup, Version: version, Kind: "Cluster"})
+	if err := p.client.Get(ctx, client.ObjectKey{Namespace: p.namespace, Name: args[0]}, cluster); err != nil {
+		return err
+	}
+	source := &controllers.ClusterApiSource{Client: p.client, Log: ctrl.Log, Version: version, ReadinessPolicies: policies}
+	notReady, err := readiness(ctx, source, cluster)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{}
+	if err := p.client.Get(ctx, client.ObjectKey{Namespace: p.namespace, Name: args[0] + "-kubeconfig"}, secret); apierrors.IsNotFound(err) {
+		secret = nil
+	} else if err != nil {
+		return err
+	}
+
+	list := &clusterregistryv1beta1.ClusterList{}
+	if err := p.client.List(ctx, list, client.InNamespace(p.namespace)); err != nil {
+		return err
+	}
+	var registered []clusterregistryv1beta1.Cluster
+	for _, clusterreg := range list.Items {
+		if source := clusterreg.Spec.Source; source != nil && source.UID == cluster.GetUID() {
+			registered = append(registered, clusterreg)
+		}
+	}
+
+	eventList := &corev1.EventList{}
+	if err := p.client.List(ctx, eventList, client.InNamespace(p.namespace)); err != nil {
+		return err
+	}
+	var events []corev1.Event
+	for _, event := range eventList.Items {
+		if event.InvolvedObject.UID == cluster.GetUID() {
+			events = append(events, event)
+		}
+	}
+
+	return printWhy(p.out, cluster, notReady, secret, registered, events, time.Now())
+}
+
+// Why the Cluster API source does not consider a cluster ready, with the
+// readiness policy that applies to it; empty if it is ready
+func readiness(ctx context.Context, source *controllers.ClusterApiSource, cluster *unstructured.Unstructured) (string, error) {
+	ready, reason, err := source.Ready(ctx, cluster)
+	if err != nil || ready {
+		return "", err
+	}
+	return reason, nil
+}
+
+// Print what keeps a Cluster API Cluster from being registered, followed by
+// the registry Clusters registered from it and its latest Events
+func printWhy(out io.Writer, cluster *unstructured.Unstructured, notReady string, secret *corev1.Secret, registered []clusterregistryv1beta1.Cluster, events []corev1.Event, now time.Time) error {
+	phase, _, _ := unstructured.NestedString(cluster.Object, "status", "phase")
+	if phase == "" {
+		phase = "<none>"
+	}
+	fmt.Fprintf(out, "Cluster API Cluster %s/%s (%s), phase %s\n", cluster.GetNamespace(), cluster.GetName(), cluster.GetAPIVersion(), phase)
+
+	reasons := explain(cluster, notReady, secret, registered)
+	if len(reasons) == 0 {
+		fmt.Fprintln(out, "Nothing keeps it from being registered.")
+	} else {
+		fmt.Fprintln(out, "\nBlocking:")
+		for _, reason := range reasons {
+			fmt.Fprintf(out, "  - %s\n", reason)
+		}
+	}
+
+	w := tabwriter.NewWriter(out, 6, 4, 3, ' ', 0)
+	if len(registered) > 0 {
+		fmt.Fprintln(w, "\nRegistered as:")
+		for _, clusterreg := range registered {
+			var conditions string
+			for _, condition := range clusterreg.Status.Conditions {
+				conditions += fmt.Sprintf("%s=%s ", condition.Type, condition.Status)
+			}
+			fmt.Fprintf(w, "  %s\t%s\n", clusterreg.Name, conditions)
+		}
+	} else {
+		fmt.Fprintln(w, "\nNot registered.")
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].LastTimestamp.Before(&events[j].LastTimestamp)
+	})
+	if len(events) > recentEvents {
+		events = events[len(events)-recentEvents:]
+	}
+	if len(events) > 0 {
+		fmt.Fprintln(w, "\nEvents:")
+		for _, event := range events {
+			age := "<unknown>"
+			if !event.LastTimestamp.IsZero() {
+				age = duration.HumanDuration(now.Sub(event.LastTimestamp.Time))
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", age, event.Type, event.Reason, event.Message)
+		}
+	}
+	return w.Flush()
+}
+
+// What keeps a Cluster API Cluster from being registered, or from its
+// registry Clusters being up to date: why the Cluster API source does not
+// consider it ready, its kubeconfig Secret as the source reads it, and the
+// conditions the source sets on the registry Clusters it already registered
+func explain(cluster *unstructured.Unstructured, notReady string, secret *corev1.Secret, registered []clusterregistryv1beta1.Cluster) []string {
+	var reasons []string
+	if cluster.GetDeletionTimestamp() != nil {
+		reasons = append(reasons, "the Cluster is being deleted")
+	}
+	if paused, _, _ := unstructured.NestedBool(cluster.Object, "spec", "paused"); paused {
+		reasons = append(reasons, "the Cluster is paused")
+	}
+	if notReady != "" {
+		reasons = append(reasons, "not ready: "+notReady)
+	}
+
+	if secret == nil {
+		reasons = append(reasons, fmt.Sprintf("kubeconfig Secret %s-kubeconfig does not exist yet", cluster.GetName()))
+	} else if _, err := controllers.ClusterAPIRegistry(secret, cluster); err != nil {
+		reasons = append(reasons, fmt.Sprintf("kubeconfig is rejected: %v", err))
+	}
+
+	for _, clusterreg := range registered {
+		for _, conditionType := range []clusterregistryv1beta1.ClusterConditionType{clusterregistryv1beta1.SourceReady, clusterregistryv1beta1.KubeconfigValid} {
+			if condition := clusterreg.Status.GetCondition(conditionType); condition != nil && condition.Status == corev1.ConditionFalse {
+				reasons = append(reasons, fmt.Sprintf("registry Cluster %s: %s is False: %s (%s)", clusterreg.Name, conditionType, condition.Reason, condition.Message))
+			}
+		}
+	}
+	return reasons
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+	"github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/controllers"
+)
+
+const validKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: workload
+  cluster:
+    server: https://10.0.0.10:6443
+contexts:
+- name: workload
+  context:
+    cluster: workload
+current-context: workload
+`
+
+// unresolvableKubeconfig has clusters none of which its current context or
+// the cluster name picks
+const unresolvableKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: a
+  cluster:
+    server: https://10.0.0.10:6443
+- name: b
+  cluster:
+    server: https://10.0.0.11:6443
+current-context: other
+`
+
+func clusterAPICluster(phase string, conditions ...map[string]interface{}) *unstructured.Unstructured {
+	cluster := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cluster.x-k8s.io/v1beta1",
+		"kind":       "Cluster",
+		"metadata":   map[string]interface{}{"name": "workload", "namespace": "default", "uid": "uid-1"},
+		"status":     map[string]interface{}{"phase": phase},
+	}}
+	if len(conditions) > 0 {
+		var list []interface{}
+		for _, condition := range conditions {
+			list = append(list, condition)
+		}
+		_ = unstructured.SetNestedSlice(cluster.Object, list, "status", "conditions")
+	}
+	return cluster
+}
+
+func kubeconfigSecret(value string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload-kubeconfig", Namespace: "default"},
+		Data:       map[string][]byte{"value": []byte(value)},
+	}
+}
+
+const readinessPolicies = `
+default:
+  conditions: [Ready]
+  annotations:
+    example.com/approved: "true"
+`
+
+func TestExplain(t *testing.T) {
+	ready := map[string]interface{}{"type": "Ready", "status": "True"}
+	blocked := clusterregistryv1beta1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Status: clusterregistryv1beta1.ClusterStatus{Conditions: []clusterregistryv1beta1.Condition{{
+			Type:    clusterregistryv1beta1.SourceReady,
+			Status:  corev1.ConditionFalse,
+			Reason:  "ReadinessGateBlocked",
+			Message: "Cluster api condition Ready is False",
+		}}},
+	}
+	policies := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "readiness", Namespace: "registry"},
+		Data:       map[string]string{controllers.ReadinessPoliciesKey: readinessPolicies},
+	}
+
+	for name, tc := range map[string]struct {
+		cluster    *unstructured.Unstructured
+		policies   *corev1.ConfigMap
+		secret     *corev1.Secret
+		registered []clusterregistryv1beta1.Cluster
+		reasons    []string
+	}{
+		"registered": {
+			cluster: clusterAPICluster("Provisioned", ready),
+			secret:  kubeconfigSecret(validKubeconfig),
+		},
+		"provisioning": {
+			cluster: clusterAPICluster("Provisioning",
+				map[string]interface{}{"type": "Ready", "status": "False", "reason": "WaitingForControlPlane"},
+			),
+			reasons: []string{
+				"not ready: Cluster api condition Ready is False",
+				"kubeconfig Secret workload-kubeconfig does not exist yet",
+			},
+		},
+		"phase": {
+			cluster: clusterAPICluster("Provisioning"),
+			secret:  kubeconfigSecret(validKubeconfig),
+			reasons: []string{"not ready: Cluster api phase is Provisioning"},
+		},
+		"readiness policy": {
+			cluster:  clusterAPICluster("Provisioned", ready),
+			policies: policies,
+			secret:   kubeconfigSecret(validKubeconfig),
+			reasons:  []string{"not ready: gate annotation example.com/approved: annotation is missing"},
+		},
+		"unparseable": {
+			cluster: clusterAPICluster("Provisioned", ready),
+			secret:  kubeconfigSecret("clusters: ["),
+			reasons: []string{"kubeconfig is rejected: secret workload-kubeconfig: unable to parse kubeconfig"},
+		},
+		"empty": {
+			cluster: clusterAPICluster("Provisioned", ready),
+			secret:  kubeconfigSecret(""),
+			reasons: []string{"kubeconfig is rejected"},
+		},
+		"CA file": {
+			cluster: clusterAPICluster("Provisioned", ready),
+			secret:  kubeconfigSecret(strings.Replace(validKubeconfig, "    server:", "    certificate-authority: /etc/ca.crt\n    server:", 1)),
+			reasons: []string{"kubeconfig is rejected: secret workload-kubeconfig: certificate-authority refers to the file /etc/ca.crt"},
+		},
+		"unresolvable context": {
+			cluster: clusterAPICluster("Provisioned", ready),
+			secret:  kubeconfigSecret(unresolvableKubeconfig),
+			reasons: []string{`kubeconfig is rejected: secret workload-kubeconfig: current context "other" does not resolve`},
+		},
+		"source not ready": {
+			cluster:    clusterAPICluster("Provisioned", ready),
+			secret:     kubeconfigSecret(validKubeconfig),
+			registered: []clusterregistryv1beta1.Cluster{blocked},
+			reasons:    []string{"registry Cluster workload: SourceReady is False: ReadinessGateBlocked (Cluster api condition Ready is False)"},
+		},
+	} {
+		source := &controllers.ClusterApiSource{Client: fake.NewFakeClientWithScheme(clientgoscheme.Scheme), Log: ctrl.Log}
+		if tc.policies != nil {
+			source.Client = fake.NewFakeClientWithScheme(clientgoscheme.Scheme, tc.policies)
+			source.ReadinessPolicies = types.NamespacedName{Namespace: tc.policies.Namespace, Name: tc.policies.Name}
+		}
+		notReady, err := readiness(context.Background(), source, tc.cluster)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		reasons := explain(tc.cluster, notReady, tc.secret, tc.registered)
+		if len(reasons) != len(tc.reasons) {
+			t.Errorf("%s: expected %q, got %q", name, tc.reasons, reasons)
+			continue
+		}
+		for i := range reasons {
+			if !strings.HasPrefix(reasons[i], tc.reasons[i]) {
+				t.Errorf("%s: expected %q, got %q", name, tc.reasons[i], reasons[i])
+			}
+		}
+	}
+}
+
+func TestPrintWhy(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	var events []corev1.Event
+	for i := 0; i < 7; i++ {
+		events = append(events, corev1.Event{
+			Type:          corev1.EventTypeNormal,
+			Reason:        "RegistrationBlocked",
+			Message:       fmt.Sprintf("attempt %d", i),
+			LastTimestamp: metav1.NewTime(now.Add(time.Duration(i-7) * time.Minute)),
+		})
+	}
+
+	out := &bytes.Buffer{}
+	if err := printWhy(out, clusterAPICluster("Provisioning"), "Cluster api phase is Provisioning", nil, nil, events, now); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"phase Provisioning", "Blocking:", "Not registered.", "attempt 6", "attempt 2"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "attempt 1") {
+		t.Errorf("expected only the %d latest events in\n%s", recentEvents, out.String())
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// ArgoCDExporterName is the name of the Argo CD exporter
+const ArgoCDExporterName = "argocd"
+
+const (
+	// ArgoCDSecretTypeLabel marks the Secrets Argo CD reads clusters from
+	ArgoCDSecretTypeLabel = "argocd.argoproj.io/secret-type"
+	// ArgoCDProjectAnnotation on a registry Cluster scopes its Argo CD
+	// cluster to an AppProject
+	ArgoCDProjectAnnotation = "clusterregistry.k8s.io/argocd-project"
+	// argoCDAnnotationPrefix marks the annotations of a registry Cluster
+	// passed through to its Argo CD cluster Secret
+	argoCDAnnotationPrefix = "argocd.argoproj.io/"
+
+	defaultArgoCDNamespace = "argocd"
+)
+
+func init() {
+	RegisterExporter(ArgoCDExporterName, func(mgr ctrl.Manager) (ClusterExporter, error) {
+		return &ArgoCDExporter{
+			Namespace: defaultArgoCDNamespace,
+		}, nil
+	})
+}
+
+// ArgoCDExporter exports registry Clusters as Argo CD cluster Secrets, named
+// <namespace>-<name> after the registry Cluster. The labels of the registry
+// Cluster, for ApplicationSet cluster generators to select on, and its
+// argocd.argoproj.io/ annotations are passed through.
+type ArgoCDExporter struct {
+	// Namespace Argo CD runs in
+	Namespace string
+	// ClientIP picks the server endpoint Argo CD reaches clusters on, the
+	// first one when unset
+	ClientIP net.IP
+}
+
+// argoCDClusterConfig is the config key of an Argo CD cluster Secret
+type argoCDClusterConfig struct {
+	Username        string                `json:"username,omitempty"`
+	Password        string                `json:"password,omitempty"`
+	BearerToken     string                `json:"bearerToken,omitempty"`
+	TLSClientConfig argoCDTLSClientConfig `json:"tlsClientConfig"`
+}
+
+type argoCDTLSClientConfig struct {
+	Insecure   bool   `json:"insecure"`
+	ServerName string `json:"serverName,omitempty"`
+	CertData   []byte `json:"certData,omitempty"`
+	KeyData    []byte `json:"keyData,omitempty"`
+	CAData     []byte `json:"caData,omitempty"`
+}
+
+func (e *ArgoCDExporter) Name() string {
+	return ArgoCDExporterName
+}
+
+// Export a registry Cluster with a server endpoint and a controller
+// credential, which Argo CD deploys with
+func (e *ArgoCDExporter) Export(ctx context.Context, clusterreg *clusterregistryv1beta1.Cluster, kubeconfig []byte) (*corev1.Secret, error) {
+	if kubeconfig == nil {
+		return nil, nil
+	}
+	config, err := restConfigForCluster(clusterreg, e.ClientIP, 0)
+	if err == errNoServerEndpoint {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := withCredential(config, kubeconfig); err != nil {
+		return nil, fmt.Errorf("controller credential of %s: %v", clusterreg.Name, err)
+	}
+
+	clusterConfig := argoCDClusterConfig{
+		Username:    config.Username,
+		Password:    config.Password,
+		BearerToken: config.BearerToken,
+		TLSClientConfig: argoCDTLSClientConfig{
+			Insecure:   config.Insecure,
+			ServerName: config.ServerName,
+			CertData:   config.CertData,
+			KeyData:    config.KeyData,
+			CAData:     config.CAData,
+		},
+	}
+	raw, err := json.Marshal(clusterConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      clusterreg.Namespace + "-" + clusterreg.Name,
+			Namespace: e.Namespace,
+			Labels:    map[string]string{},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"name":   []byte(clusterreg.Name),
+			"server": []byte(config.Host),
+			"config": raw,
+		},
+	}
+	for key, value := range clusterreg.Labels {
+		secret.Labels[key] = value
+	}
+	secret.Labels[ArgoCDSecretTypeLabel] = "cluster"
+	for key, value := range clusterreg.Annotations {
+		if strings.HasPrefix(key, argoCDAnnotationPrefix) {
+			if secret.Annotations == nil {
+				secret.Annotations = map[string]string{}
+			}
+			secret.Annotations[key] = value
+		}
+	}
+	if project := clusterreg.Annotations[ArgoCDProjectAnnotation]; project != "" {
+		secret.Data["project"] = []byte(project)
+	}
+	return secret, nil
+}
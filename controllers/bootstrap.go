@@ -0,0 +1,592 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+const (
+	// BootstrapTemplateKey is the ConfigMap key holding the bootstrap template
+	BootstrapTemplateKey = "template"
+
+	// bootstrapHook holds a registry Cluster until the ServiceAccount
+	// bootstrapped for it is revoked. Its value names the admin kubeconfig
+	// secret to revoke with.
+	bootstrapHook = clusterregistryv1beta1.PreDeleteHookAnnotationPrefix + "service-account"
+	// bootstrapLabel marks the objects bootstrapped in a member cluster
+	bootstrapLabel = "clusterregistry.k8s.io/bootstrap"
+	// bootstrapOwnerLabelPrefix prefixes a label per registry Cluster the
+	// bootstrapped objects are used by, so a member cluster registered twice
+	// keeps them until both registrations are deleted
+	bootstrapOwnerLabelPrefix = "owner.clusterregistry.k8s.io/"
+	// serviceAccountUserPrefix names the kubeconfig user of a bootstrapped credential
+	serviceAccountUserPrefix = "system:serviceaccount:"
+
+	defaultBootstrapNamespace      = "cluster-registry"
+	defaultBootstrapServiceAccount = "cluster-registry-controller"
+)
+
+// BootstrapTemplate scopes the credential bootstrapped in member clusters: a
+// ServiceAccount in Namespace bound to a ClusterRole of the same name with Rules.
+type BootstrapTemplate struct {
+	Namespace      string              `json:"namespace,omitempty"`
+	ServiceAccount string              `json:"serviceAccount,omitempty"`
+	Rules          []rbacv1.PolicyRule `json:"rules"`
+}
+
+// ParseBootstrapTemplate reads a YAML bootstrap template, defaulting the
+// namespace and ServiceAccount names
+func ParseBootstrapTemplate(data string) (*BootstrapTemplate, error) {
+	template := &BootstrapTemplate{}
+	if err := yaml.UnmarshalStrict([]byte(data), template); err != nil {
+		return nil, err
+	}
+	if len(template.Rules) == 0 {
+		return nil, errors.New("bootstrap template grants no rules")
+	}
+	if template.Namespace == "" {
+		template.Namespace = defaultBootstrapNamespace
+	}
+	if template.ServiceAccount == "" {
+		template.ServiceAccount = defaultBootstrapServiceAccount
+	}
+	return template, nil
+}
+
+// user names the kubeconfig user of the template's ServiceAccount
+func (t *BootstrapTemplate) user() string {
+	return serviceAccountUserPrefix + t.Namespace + ":" + t.ServiceAccount
+}
+
+// bootstrapOwnerLabel is the label marking the objects bootstrapped for a
+// registry Cluster, keyed by a hash of its namespace, name and UID
+func bootstrapOwnerLabel(clusterreg *clusterregistryv1beta1.Cluster) string {
+	return bootstrapOwnerLabelPrefix + nameHash(clusterreg.Namespace+"/"+clusterreg.Name+"/"+string(clusterreg.UID))
+}
+
+// bootstrapLabels are the labels of the objects bootstrapped for a registry
+// Cluster, the owner label naming it
+func bootstrapLabels(clusterreg *clusterregistryv1beta1.Cluster) map[string]string {
+	return map[string]string{
+		bootstrapLabel:                  "true",
+		bootstrapOwnerLabel(clusterreg): hashedName(clusterreg.Namespace+"."+clusterreg.Name, validation.LabelValueMaxLength),
+	}
+}
+
+// withLabels adds labels to an object, telling whether any was missing
+func withLabels(meta *metav1.ObjectMeta, labels map[string]string) bool {
+	changed := false
+	for key, value := range labels {
+		if meta.Labels[key] == value {
+			continue
+		}
+		if meta.Labels == nil {
+			meta.Labels = map[string]string{}
+		}
+		meta.Labels[key] = value
+		changed = true
+	}
+	return changed
+}
+
+// sharedBootstrap tells whether an object bootstrapped for the owner label
+// is used by another registry Cluster too
+func sharedBootstrap(labels map[string]string, owner string) bool {
+	for key := range labels {
+		if key != owner && strings.HasPrefix(key, bootstrapOwnerLabelPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceAccountBootstrap replaces the admin kubeconfig of member clusters by
+// a least privilege ServiceAccount token. The admin kubeconfig is only used
+// to create the ServiceAccount and to revoke it again.
+type ServiceAccountBootstrap struct {
+	Client client.Client
+	Log    logr.Logger
+
+	// Template names the ConfigMap holding the BootstrapTemplate
+	Template client.ObjectKey
+
+	// newClient connects to a member cluster, replaced in tests
+	newClient func(*rest.Config) (kubernetes.Interface, error)
+}
+
+// Load the bootstrap template from its ConfigMap
+func (b *ServiceAccountBootstrap) template(ctx context.Context) (*BootstrapTemplate, error) {
+	configMap := &corev1.ConfigMap{}
+	if err := b.Client.Get(ctx, b.Template, configMap); err != nil {
+		return nil, err
+	}
+	template, err := ParseBootstrapTemplate(configMap.Data[BootstrapTemplateKey])
+	if err != nil {
+		return nil, fmt.Errorf("bootstrap template ConfigMap %s: %v", b.Template, err)
+	}
+	return template, nil
+}
+
+// Connect to a member cluster with a kubeconfig
+func (b *ServiceAccountBootstrap) connect(kubeconfig []byte) (kubernetes.Interface, *rest.Config, error) {
+	config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	newClient := b.newClient
+	if newClient == nil {
+		newClient = func(config *rest.Config) (kubernetes.Interface, error) {
+			return kubernetes.NewForConfig(config)
+		}
+	}
+	clientset, err := newClient(config)
+	return clientset, config, err
+}
+
+// Bootstrap creates the ServiceAccount of the template for a registry
+// Cluster in the member cluster the admin kubeconfig points to, and returns a
+// kubeconfig with its token. A NotFound error means the token is not issued yet.
+func (b *ServiceAccountBootstrap) Bootstrap(ctx context.Context, clusterreg *clusterregistryv1beta1.Cluster, admin []byte) ([]byte, error) {
+	template, err := b.template(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return b.bootstrap(template, bootstrapLabels(clusterreg), admin)
+}
+
+func (b *ServiceAccountBootstrap) bootstrap(template *BootstrapTemplate, labels map[string]string, admin []byte) ([]byte, error) {
+	clientset, config, err := b.connect(admin)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := metav1.ObjectMeta{Name: template.ServiceAccount, Namespace: template.Namespace, Labels: labels}
+	tokenMeta := metav1.ObjectMeta{
+		Name:        template.ServiceAccount + "-token",
+		Namespace:   template.Namespace,
+		Labels:      labels,
+		Annotations: map[string]string{corev1.ServiceAccountNameKey: template.ServiceAccount},
+	}
+
+	if _, err := clientset.CoreV1().Namespaces().Create(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: template.Namespace},
+	}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	if _, err := clientset.CoreV1().ServiceAccounts(template.Namespace).Create(&corev1.ServiceAccount{
+		ObjectMeta: meta,
+	}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	if err := grant(clientset, template, labels); err != nil {
+		return nil, err
+	}
+	// token secrets are no longer created for ServiceAccounts automatically
+	if _, err := clientset.CoreV1().Secrets(template.Namespace).Create(&corev1.Secret{
+		ObjectMeta: tokenMeta,
+		Type:       corev1.SecretTypeServiceAccountToken,
+	}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	// either may have been bootstrapped for another registration of the member
+	if err := claim(clientset, template, labels); err != nil {
+		return nil, err
+	}
+
+	token, err := clientset.CoreV1().Secrets(template.Namespace).Get(tokenMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(token.Data[corev1.ServiceAccountTokenKey]) == 0 {
+		return nil, apierrors.NewNotFound(corev1.Resource("secrets"), tokenMeta.Name+" token")
+	}
+
+	ca := token.Data[corev1.ServiceAccountRootCAKey]
+	if len(ca) == 0 {
+		ca = config.CAData
+	}
+	user := template.user()
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters["cluster"] = &clientcmdapi.Cluster{Server: config.Host, CertificateAuthorityData: ca}
+	kubeconfig.AuthInfos[user] = &clientcmdapi.AuthInfo{Token: string(token.Data[corev1.ServiceAccountTokenKey])}
+	kubeconfig.Contexts[user] = &clientcmdapi.Context{Cluster: "cluster", AuthInfo: user}
+	kubeconfig.CurrentContext = user
+	return clientcmd.Write(*kubeconfig)
+}
+
+// grant creates or updates the ClusterRole and ClusterRoleBinding of the
+// template, so template changes reach clusters bootstrapped before, and adds
+// the labels of a registry Cluster to them
+func grant(clientset kubernetes.Interface, template *BootstrapTemplate, labels map[string]string) error {
+	meta := metav1.ObjectMeta{Name: template.ServiceAccount, Labels: labels}
+	roleRef := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: template.ServiceAccount}
+	subjects := []rbacv1.Subject{{
+		Kind:      rbacv1.ServiceAccountKind,
+		Name:      template.ServiceAccount,
+		Namespace: template.Namespace,
+	}}
+
+	roles := clientset.RbacV1().ClusterRoles()
+	role, err := roles.Get(meta.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		_, err = roles.Create(&rbacv1.ClusterRole{ObjectMeta: meta, Rules: template.Rules})
+	case err == nil:
+		changed := withLabels(&role.ObjectMeta, labels)
+		if !reflect.DeepEqual(role.Rules, template.Rules) {
+			role.Rules = template.Rules
+			changed = true
+		}
+		if changed {
+			_, err = roles.Update(role)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	bindings := clientset.RbacV1().ClusterRoleBindings()
+	binding, err := bindings.Get(meta.Name, metav1.GetOptions{})
+	if err == nil && binding.RoleRef != roleRef {
+		// the role of a binding cannot be changed, replace it
+		if err := bindings.Delete(binding.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		// keeping the owner labels of other registrations
+		meta.Labels = binding.Labels
+		withLabels(&meta, labels)
+		err = apierrors.NewNotFound(rbacv1.Resource("clusterrolebindings"), binding.Name)
+	}
+	switch {
+	case apierrors.IsNotFound(err):
+		_, err = bindings.Create(&rbacv1.ClusterRoleBinding{ObjectMeta: meta, RoleRef: roleRef, Subjects: subjects})
+	case err == nil:
+		changed := withLabels(&binding.ObjectMeta, labels)
+		if !reflect.DeepEqual(binding.Subjects, subjects) {
+			binding.Subjects = subjects
+			changed = true
+		}
+		if changed {
+			_, err = bindings.Update(binding)
+		}
+	}
+	return err
+}
+
+// claim adds the labels of a registry Cluster to the ServiceAccount of the
+// template and its token Secret
+func claim(clientset kubernetes.Interface, template *BootstrapTemplate, labels map[string]string) error {
+	accounts := clientset.CoreV1().ServiceAccounts(template.Namespace)
+	account, err := accounts.Get(template.ServiceAccount, metav1.GetOptions{})
+	if err == nil && withLabels(&account.ObjectMeta, labels) {
+		_, err = accounts.Update(account)
+	}
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	secrets := clientset.CoreV1().Secrets(template.Namespace)
+	token, err := secrets.Get(template.ServiceAccount+"-token", metav1.GetOptions{})
+	if err == nil && withLabels(&token.ObjectMeta, labels) {
+		_, err = secrets.Update(token)
+	}
+	return client.IgnoreNotFound(err)
+}
+
+// Revoke deletes what was bootstrapped for a registry Cluster in the member
+// cluster the admin kubeconfig points to. Objects other registry Clusters of
+// the same member use are only unlabeled.
+func (b *ServiceAccountBootstrap) Revoke(ctx context.Context, clusterreg *clusterregistryv1beta1.Cluster, admin []byte) error {
+	clientset, _, err := b.connect(admin)
+	if err != nil {
+		return err
+	}
+	owner := bootstrapOwnerLabel(clusterreg)
+	selector := metav1.ListOptions{LabelSelector: owner}
+	del := &metav1.DeleteOptions{}
+
+	bindings, err := clientset.RbacV1().ClusterRoleBindings().List(selector)
+	if err != nil {
+		return err
+	}
+	for i := range bindings.Items {
+		binding := &bindings.Items[i]
+		if sharedBootstrap(binding.Labels, owner) {
+			delete(binding.Labels, owner)
+			_, err = clientset.RbacV1().ClusterRoleBindings().Update(binding)
+		} else {
+			err = clientset.RbacV1().ClusterRoleBindings().Delete(binding.Name, del)
+		}
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	roles, err := clientset.RbacV1().ClusterRoles().List(selector)
+	if err != nil {
+		return err
+	}
+	for i := range roles.Items {
+		role := &roles.Items[i]
+		if sharedBootstrap(role.Labels, owner) {
+			delete(role.Labels, owner)
+			_, err = clientset.RbacV1().ClusterRoles().Update(role)
+		} else {
+			err = clientset.RbacV1().ClusterRoles().Delete(role.Name, del)
+		}
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	// the namespace is left alone, it may hold more than what was bootstrapped
+	secrets, err := clientset.CoreV1().Secrets(metav1.NamespaceAll).List(selector)
+	if err != nil {
+		return err
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if sharedBootstrap(secret.Labels, owner) {
+			delete(secret.Labels, owner)
+			_, err = clientset.CoreV1().Secrets(secret.Namespace).Update(secret)
+		} else {
+			err = clientset.CoreV1().Secrets(secret.Namespace).Delete(secret.Name, del)
+		}
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	accounts, err := clientset.CoreV1().ServiceAccounts(metav1.NamespaceAll).List(selector)
+	if err != nil {
+		return err
+	}
+	for i := range accounts.Items {
+		account := &accounts.Items[i]
+		if sharedBootstrap(account.Labels, owner) {
+			delete(account.Labels, owner)
+			_, err = clientset.CoreV1().ServiceAccounts(account.Namespace).Update(account)
+		} else {
+			err = clientset.CoreV1().ServiceAccounts(account.Namespace).Delete(account.Name, del)
+		}
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bootstrapped returns the managed credential of a registry Cluster if it
+// already holds the token of the template's ServiceAccount rather than the
+// admin kubeconfig
+func (b *ServiceAccountBootstrap) bootstrapped(ctx context.Context, clusterreg *clusterregistryv1beta1.Cluster, template *BootstrapTemplate) ([]byte, error) {
+	key, err := controllerCredentialKey(clusterreg)
+	if key == nil || err != nil {
+		return nil, err
+	}
+	secret := &corev1.Secret{}
+	if err := b.Client.Get(ctx, *key, secret); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	data := secret.Data[clusterregistryv1beta1.ControllerKubeconfigKey]
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, nil
+	}
+	if context, ok := config.Contexts[config.CurrentContext]; ok && context.AuthInfo == template.user() {
+		return data, nil
+	}
+	return nil, nil
+}
+
+// Credential bootstraps the ServiceAccount of a registry Cluster once, from
+// the admin kubeconfig in the given secret, and returns its kubeconfig. Its
+// ClusterRole is brought in line with the template on every call. The
+// registry Cluster is held on deletion until the ServiceAccount is revoked.
+func (b *ServiceAccountBootstrap) Credential(ctx context.Context, clusterreg *clusterregistryv1beta1.Cluster, adminSecret *corev1.Secret, admin []byte) ([]byte, error) {
+	template, err := b.template(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := b.bootstrapped(ctx, clusterreg, template)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		// an unreachable member cluster keeps its credential, the grant is
+		// retried on the next sync
+		clientset, _, err := b.connect(admin)
+		if err == nil {
+			err = grant(clientset, template, bootstrapLabels(clusterreg))
+		}
+		if err == nil {
+			err = claim(clientset, template, bootstrapLabels(clusterreg))
+		}
+		if err != nil {
+			b.Log.Error(err, "unable update bootstrapped ClusterRole", "ClusterRegistry", clusterreg.Name)
+		}
+		return existing, nil
+	}
+
+	// hold the registry Cluster before anything is created in the member cluster
+	if clusterreg.Annotations[bootstrapHook] != adminSecret.Name {
+		patch := client.MergeFrom(clusterreg.DeepCopy())
+		if clusterreg.Annotations == nil {
+			clusterreg.Annotations = map[string]string{}
+		}
+		clusterreg.Annotations[bootstrapHook] = adminSecret.Name
+		if err := b.Client.Patch(ctx, clusterreg, patch); err != nil {
+			return nil, err
+		}
+	}
+
+	b.Log.Info("Bootstrap ServiceAccount", "ClusterRegistry", clusterreg.Name)
+	return b.bootstrap(template, bootstrapLabels(clusterreg), admin)
+}
+
+// Reasons reported when a bootstrapped ServiceAccount is not revoked
+const (
+	ReasonRevocationSkipped = "RevocationSkipped"
+	ReasonRevocationFailed  = "RevocationFailed"
+)
+
+// defaultRevokeTimeout bounds how long a registry Cluster being deleted is
+// held while revoking its ServiceAccount fails
+const defaultRevokeTimeout = 10 * time.Minute
+
+// ServiceAccountRevoker revokes the ServiceAccount bootstrapped for a
+// registry Cluster being deleted, then releases its pre-delete hook. It gives
+// up, leaving the ServiceAccount behind, when the member cluster is going
+// away or cannot be reached, so it never blocks the deletion for good.
+type ServiceAccountRevoker struct {
+	Client    client.Client
+	Log       logr.Logger
+	Recorder  record.EventRecorder
+	Bootstrap *ServiceAccountBootstrap
+
+	// Timeout is how long revocation is retried, defaults to 10 minutes
+	Timeout time.Duration
+}
+
+func (r *ServiceAccountRevoker) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("cluster-registry", req.NamespacedName)
+
+	clusterreg := &clusterregistryv1beta1.Cluster{}
+	if err := r.Client.Get(ctx, req.NamespacedName, clusterreg); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	adminName, hooked := clusterreg.Annotations[bootstrapHook]
+	if clusterreg.DeletionTimestamp == nil || !hooked {
+		return ctrl.Result{}, nil
+	}
+
+	admin := &corev1.Secret{}
+	err := r.Client.Get(ctx, client.ObjectKey{Namespace: clusterreg.Namespace, Name: adminName}, admin)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	skip, err := r.skipReason(ctx, clusterreg, adminName, admin, err)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if skip != "" {
+		log.Info("ServiceAccount left behind", "reason", skip)
+		r.Recorder.Event(clusterreg, corev1.EventTypeWarning, ReasonRevocationSkipped, "ServiceAccount not revoked: "+skip)
+	} else {
+		log.Info("Revoke ServiceAccount")
+		if err := r.Bootstrap.Revoke(ctx, clusterreg, admin.Data["value"]); err != nil {
+			timeout := r.Timeout
+			if timeout <= 0 {
+				timeout = defaultRevokeTimeout
+			}
+			if time.Since(clusterreg.DeletionTimestamp.Time) < timeout {
+				log.Error(err, "unable revoke ServiceAccount")
+				return ctrl.Result{}, err
+			}
+			log.Error(err, "unable revoke ServiceAccount, giving up", "timeout", timeout)
+			r.Recorder.Eventf(clusterreg, corev1.EventTypeWarning, ReasonRevocationFailed,
+				"ServiceAccount not revoked after %s: %v", timeout, err)
+		}
+	}
+
+	patch := client.MergeFrom(clusterreg.DeepCopy())
+	delete(clusterreg.Annotations, bootstrapHook)
+	return ctrl.Result{}, client.IgnoreNotFound(r.Client.Patch(ctx, clusterreg, patch))
+}
+
+// skipReason tells why revoking the ServiceAccount of a registry Cluster is
+// pointless: its admin kubeconfig or its source is gone or being deleted, or
+// the member cluster is not reachable
+func (r *ServiceAccountRevoker) skipReason(ctx context.Context, clusterreg *clusterregistryv1beta1.Cluster, adminName string, admin *corev1.Secret, adminErr error) (string, error) {
+	switch {
+	case apierrors.IsNotFound(adminErr):
+		return fmt.Sprintf("admin kubeconfig %s is gone", adminName), nil
+	case admin.DeletionTimestamp != nil:
+		return fmt.Sprintf("admin kubeconfig %s is being deleted", adminName), nil
+	}
+
+	if ok := clusterreg.Status.GetCondition(clusterregistryv1beta1.ClusterOK); ok != nil && ok.Status != corev1.ConditionTrue {
+		return fmt.Sprintf("cluster is not ready: %s", ok.Reason), nil
+	}
+
+	owner := metav1.GetControllerOf(clusterreg)
+	if owner == nil {
+		return "", nil
+	}
+	source := &unstructured.Unstructured{}
+	source.SetAPIVersion(owner.APIVersion)
+	source.SetKind(owner.Kind)
+	err := r.Client.Get(ctx, client.ObjectKey{Namespace: clusterreg.Namespace, Name: owner.Name}, source)
+	switch {
+	case apierrors.IsNotFound(err):
+		return fmt.Sprintf("source %s %s is gone", owner.Kind, owner.Name), nil
+	case err != nil:
+		return "", err
+	case source.GetDeletionTimestamp() != nil:
+		return fmt.Sprintf("source %s %s is being deleted", owner.Kind, owner.Name), nil
+	}
+	return "", nil
+}
+
+// Setup method for controller
+func (r *ServiceAccountRevoker) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("service-account-revoker").
+		For(&clusterregistryv1beta1.Cluster{}).
+		Complete(r)
+}
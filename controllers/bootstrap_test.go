@@ -0,0 +1,334 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+const bootstrapTemplate = `
+namespace: registry
+rules:
+- apiGroups: [""]
+  resources: ["namespaces"]
+  verbs: ["get", "list"]
+`
+
+func TestParseBootstrapTemplate(t *testing.T) {
+	for name, data := range map[string]string{
+		"no rules":      "namespace: registry",
+		"unknown field": "namespace: registry\nrole: admin",
+	} {
+		if _, err := ParseBootstrapTemplate(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	template, err := ParseBootstrapTemplate(bootstrapTemplate)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if template.Namespace != "registry" || template.ServiceAccount != defaultBootstrapServiceAccount {
+		t.Errorf("unexpected template %+v", template)
+	}
+}
+
+func TestBootstrapAndRevoke(t *testing.T) {
+	ctx := context.Background()
+	hub := fake.NewFakeClientWithScheme(clientgoscheme.Scheme, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "bootstrap", Namespace: "default"},
+		Data:       map[string]string{BootstrapTemplateKey: bootstrapTemplate},
+	})
+	member := kubefake.NewSimpleClientset()
+	b := &ServiceAccountBootstrap{
+		Client:   hub,
+		Log:      ctrl.Log,
+		Template: types.NamespacedName{Namespace: "default", Name: "bootstrap"},
+		newClient: func(*rest.Config) (kubernetes.Interface, error) {
+			return member, nil
+		},
+	}
+
+	admin := clientcmdapi.NewConfig()
+	admin.Clusters["member"] = &clientcmdapi.Cluster{Server: "https://member:6443", CertificateAuthorityData: []byte("ca")}
+	admin.AuthInfos["admin"] = &clientcmdapi.AuthInfo{Token: "admin"}
+	admin.Contexts["admin@member"] = &clientcmdapi.Context{Cluster: "member", AuthInfo: "admin"}
+	admin.CurrentContext = "admin@member"
+	adminData, err := clientcmd.Write(*admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the member is registered twice, e.g. from two sources
+	first := &clusterregistryv1beta1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "member", Namespace: "clusters", UID: "1"}}
+	second := &clusterregistryv1beta1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "member", Namespace: "other", UID: "2"}}
+	if _, err := b.Bootstrap(ctx, first, adminData); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected to wait for the token, got %v", err)
+	}
+
+	// stand in for the token controller
+	token, err := member.CoreV1().Secrets("registry").Get(defaultBootstrapServiceAccount+"-token", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token.Data = map[string][]byte{corev1.ServiceAccountTokenKey: []byte("sa-token")}
+	if _, err := member.CoreV1().Secrets("registry").Update(token); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := b.Bootstrap(ctx, first, adminData)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := b.Bootstrap(ctx, second, adminData); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := config.Contexts[config.CurrentContext].AuthInfo
+	if user != "system:serviceaccount:registry:"+defaultBootstrapServiceAccount || config.AuthInfos[user].Token != "sa-token" {
+		t.Errorf("expected the ServiceAccount token, got %s", data)
+	}
+	role, err := member.RbacV1().ClusterRoles().Get(defaultBootstrapServiceAccount, metav1.GetOptions{})
+	if err != nil || len(role.Rules) != 1 {
+		t.Errorf("expected the templated ClusterRole, got %v, %v", role, err)
+	}
+
+	// revoking one registration keeps the credential of the other
+	if err := b.Revoke(ctx, first, adminData); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	account, err := member.CoreV1().ServiceAccounts("registry").Get(defaultBootstrapServiceAccount, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the ServiceAccount to be kept, got %v", err)
+	}
+	if _, owned := account.Labels[bootstrapOwnerLabel(first)]; owned || account.Labels[bootstrapOwnerLabel(second)] != "other.member" {
+		t.Errorf("expected the ServiceAccount to be owned by the other registration only, got %v", account.Labels)
+	}
+	for _, kind := range []string{"ClusterRole", "ClusterRoleBinding", "token Secret"} {
+		var err error
+		switch kind {
+		case "ClusterRole":
+			_, err = member.RbacV1().ClusterRoles().Get(defaultBootstrapServiceAccount, metav1.GetOptions{})
+		case "ClusterRoleBinding":
+			_, err = member.RbacV1().ClusterRoleBindings().Get(defaultBootstrapServiceAccount, metav1.GetOptions{})
+		default:
+			_, err = member.CoreV1().Secrets("registry").Get(defaultBootstrapServiceAccount+"-token", metav1.GetOptions{})
+		}
+		if err != nil {
+			t.Errorf("expected the %s to be kept, got %v", kind, err)
+		}
+	}
+
+	if err := b.Revoke(ctx, second, adminData); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := member.CoreV1().ServiceAccounts("registry").Get(defaultBootstrapServiceAccount, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the ServiceAccount to be revoked, got %v", err)
+	}
+	if _, err := member.RbacV1().ClusterRoleBindings().Get(defaultBootstrapServiceAccount, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the ClusterRoleBinding to be revoked, got %v", err)
+	}
+	if _, err := member.CoreV1().Namespaces().Get("registry", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the namespace to be kept, got %v", err)
+	}
+}
+
+func TestServiceAccountRevokerGivesUp(t *testing.T) {
+	ctx := context.Background()
+	admin := clientcmdapi.NewConfig()
+	admin.Clusters["member"] = &clientcmdapi.Cluster{Server: "https://member:6443"}
+	admin.AuthInfos["admin"] = &clientcmdapi.AuthInfo{Token: "admin"}
+	admin.Contexts["admin@member"] = &clientcmdapi.Context{Cluster: "member", AuthInfo: "admin"}
+	admin.CurrentContext = "admin@member"
+	adminData, err := clientcmd.Write(*admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := types.NamespacedName{Namespace: "clusters", Name: "member"}
+	newCluster := func(deleted time.Time, ok corev1.ConditionStatus) *clusterregistryv1beta1.Cluster {
+		clusterreg := &clusterregistryv1beta1.Cluster{ObjectMeta: metav1.ObjectMeta{
+			Name:              key.Name,
+			Namespace:         key.Namespace,
+			Annotations:       map[string]string{bootstrapHook: "member-kubeconfig"},
+			DeletionTimestamp: &metav1.Time{Time: deleted},
+		}}
+		if ok != "" {
+			clusterreg.Status.SetCondition(clusterregistryv1beta1.Condition{Type: clusterregistryv1beta1.ClusterOK, Status: ok, Reason: ReasonHealthCheckFailed})
+		}
+		return clusterreg
+	}
+	adminSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "member-kubeconfig", Namespace: key.Namespace},
+		Data:       map[string][]byte{"value": adminData},
+	}
+	unreachable := func(*rest.Config) (kubernetes.Interface, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	for name, tc := range map[string]struct {
+		objects  []runtime.Object
+		released bool
+		reason   string
+	}{
+		"admin kubeconfig gone": {
+			objects:  []runtime.Object{newCluster(time.Now(), "")},
+			released: true,
+			reason:   ReasonRevocationSkipped,
+		},
+		"cluster not ready": {
+			objects:  []runtime.Object{newCluster(time.Now(), corev1.ConditionFalse), adminSecret.DeepCopy()},
+			released: true,
+			reason:   ReasonRevocationSkipped,
+		},
+		"revocation failing": {
+			objects: []runtime.Object{newCluster(time.Now(), corev1.ConditionTrue), adminSecret.DeepCopy()},
+		},
+		"revocation failing for too long": {
+			objects:  []runtime.Object{newCluster(time.Now().Add(-time.Hour), corev1.ConditionTrue), adminSecret.DeepCopy()},
+			released: true,
+			reason:   ReasonRevocationFailed,
+		},
+	} {
+		hub := fake.NewFakeClientWithScheme(newExportScheme(t), tc.objects...)
+		recorder := record.NewFakeRecorder(10)
+		r := &ServiceAccountRevoker{
+			Client:    hub,
+			Log:       ctrl.Log,
+			Recorder:  recorder,
+			Bootstrap: &ServiceAccountBootstrap{Client: hub, Log: ctrl.Log, newClient: unreachable},
+		}
+
+		_, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+		if tc.released && err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+		if !tc.released && err == nil {
+			t.Errorf("%s: expected the revocation to be retried", name)
+		}
+		clusterreg := &clusterregistryv1beta1.Cluster{}
+		if err := hub.Get(ctx, key, clusterreg); err != nil {
+			t.Fatal(err)
+		}
+		if _, hooked := clusterreg.Annotations[bootstrapHook]; hooked == tc.released {
+			t.Errorf("%s: expected the hook released to be %v, got annotations %v", name, tc.released, clusterreg.Annotations)
+		}
+		if tc.reason != "" {
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, corev1.EventTypeWarning+" "+tc.reason) {
+					t.Errorf("%s: unexpected event %q", name, event)
+				}
+			default:
+				t.Errorf("%s: expected a %s event", name, tc.reason)
+			}
+		}
+	}
+}
+
+func TestCredentialUpdatesBootstrappedRole(t *testing.T) {
+	ctx := context.Background()
+	template, err := ParseBootstrapTemplate(bootstrapTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bootstrapped := clientcmdapi.NewConfig()
+	bootstrapped.Clusters["cluster"] = &clientcmdapi.Cluster{Server: "https://member:6443"}
+	bootstrapped.AuthInfos[template.user()] = &clientcmdapi.AuthInfo{Token: "sa-token"}
+	bootstrapped.Contexts[template.user()] = &clientcmdapi.Context{Cluster: "cluster", AuthInfo: template.user()}
+	bootstrapped.CurrentContext = template.user()
+	bootstrappedData, err := clientcmd.Write(*bootstrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := bootstrapped.DeepCopy()
+	admin.AuthInfos[template.user()].Token = "admin"
+	adminData, err := clientcmd.Write(*admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clusterreg := &clusterregistryv1beta1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "member", Namespace: "clusters"},
+		Spec: clusterregistryv1beta1.ClusterSpec{AuthInfo: clusterregistryv1beta1.AuthInfo{
+			Controller: &clusterregistryv1beta1.ObjectReference{Kind: "Secret", Name: "member-credentials"},
+		}},
+	}
+	hub := fake.NewFakeClientWithScheme(newExportScheme(t), clusterreg.DeepCopy(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "bootstrap", Namespace: "default"},
+		Data:       map[string]string{BootstrapTemplateKey: bootstrapTemplate},
+	}, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "member-credentials", Namespace: "clusters"},
+		Data:       map[string][]byte{clusterregistryv1beta1.ControllerKubeconfigKey: bootstrappedData},
+	})
+	// bootstrapped with an older template
+	member := kubefake.NewSimpleClientset(&rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: template.ServiceAccount, Labels: map[string]string{bootstrapLabel: "true"}},
+		Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}},
+	})
+	b := &ServiceAccountBootstrap{
+		Client:   hub,
+		Log:      ctrl.Log,
+		Template: types.NamespacedName{Namespace: "default", Name: "bootstrap"},
+		newClient: func(*rest.Config) (kubernetes.Interface, error) {
+			return member, nil
+		},
+	}
+
+	data, err := b.Credential(ctx, clusterreg, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "member-kubeconfig"}}, adminData)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != string(bootstrappedData) {
+		t.Errorf("expected the bootstrapped credential to be kept, got %s", data)
+	}
+	role, err := member.RbacV1().ClusterRoles().Get(template.ServiceAccount, metav1.GetOptions{})
+	if err != nil || !reflect.DeepEqual(role.Rules, template.Rules) {
+		t.Errorf("expected the ClusterRole to follow the template, got %v, %v", role, err)
+	} else if _, owned := role.Labels[bootstrapOwnerLabel(clusterreg)]; !owned {
+		t.Errorf("expected the ClusterRole to be labeled for its registry Cluster, got %v", role.Labels)
+	}
+	binding, err := member.RbacV1().ClusterRoleBindings().Get(template.ServiceAccount, metav1.GetOptions{})
+	if err != nil || len(binding.Subjects) != 1 || binding.Subjects[0].Namespace != template.Namespace {
+		t.Errorf("expected the ClusterRoleBinding to be created, got %v, %v", binding, err)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/clientcmd"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// defaultCertificateExpiryWindow is how long before a certificate expires the
+// CertificateExpiring condition turns True by default
+const defaultCertificateExpiryWindow = 30 * 24 * time.Hour
+
+// Reasons of the CertificateExpiring condition
+const (
+	ReasonCertificateExpiring = "CertificateExpiring"
+	ReasonCertificateExpired  = "CertificateExpired"
+	ReasonCertificatesValid   = "CertificatesValid"
+)
+
+// Record when the CA bundle and the client certificates of the controller
+// credential and of the source kubeconfig of a registry Cluster expire, and
+// set its CertificateExpiring condition. The condition is removed for
+// clusters without certificates.
+func (r *ClusterReconciler) checkCertificates(ctx context.Context, cluster *clusterregistryv1beta1.Cluster) error {
+	certificates := &clusterregistryv1beta1.CertificatesStatus{}
+	if expiry, ok := certificateExpiry(cluster.Spec.KubernetesAPIEndpoints.CABundle); ok {
+		certificates.CANotAfter = &metav1.Time{Time: expiry}
+	}
+	kubeconfig, err := ControllerKubeconfig(ctx, r.Client, cluster)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	source, err := sourceKubeconfig(ctx, r.Client, cluster)
+	if err != nil {
+		return err
+	}
+	for _, kubeconfig := range [][]byte{kubeconfig, source} {
+		expiry, ok := clientCertificateExpiry(kubeconfig)
+		if ok && (certificates.ClientNotAfter == nil || expiry.Before(certificates.ClientNotAfter.Time)) {
+			certificates.ClientNotAfter = &metav1.Time{Time: expiry}
+		}
+	}
+
+	condition := certificatesCondition(certificates, time.Now(), r.CertificateExpiryWindow)
+	if condition == nil {
+		cluster.Status.Certificates = nil
+		cluster.Status.RemoveCondition(clusterregistryv1beta1.CertificateExpiring)
+		return nil
+	}
+	condition.ObservedGeneration = cluster.Generation
+	previous := cluster.Status.GetCondition(clusterregistryv1beta1.CertificateExpiring)
+	switch {
+	case condition.Status == corev1.ConditionTrue && (previous == nil || previous.Status != corev1.ConditionTrue):
+		r.Recorder.Event(cluster, corev1.EventTypeWarning, condition.Reason, condition.Message)
+	case condition.Status != corev1.ConditionTrue && previous != nil && previous.Status == corev1.ConditionTrue:
+		r.Recorder.Event(cluster, corev1.EventTypeNormal, condition.Reason, condition.Message)
+	}
+	cluster.Status.Certificates = certificates
+	cluster.Status.SetCondition(*condition)
+	return nil
+}
+
+// The admin kubeconfig Cluster API wrote for the cluster a registry Cluster
+// is registered from, nil for other sources. It holds the client certificate
+// even when the controller credential is a bootstrapped ServiceAccount token.
+// Only sources of the cluster's own namespace are read, as for the
+// controller credential.
+func sourceKubeconfig(ctx context.Context, c client.Client, cluster *clusterregistryv1beta1.Cluster) ([]byte, error) {
+	source := cluster.Spec.Source
+	if source == nil || source.Kind != "Cluster" {
+		return nil, nil
+	}
+	if gv, err := schema.ParseGroupVersion(source.APIVersion); err != nil || gv.Group != clusterv1.GroupVersion.Group {
+		return nil, nil
+	}
+	if source.Namespace != "" && source.Namespace != cluster.Namespace {
+		return nil, fmt.Errorf("source %s/%s of %s/%s is not in the namespace of the cluster",
+			source.Namespace, source.Name, cluster.Namespace, cluster.Name)
+	}
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: cluster.Namespace, Name: source.Name + kubeconfigSuffix}, secret); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return secret.Data["value"], nil
+}
+
+// The CertificateExpiring condition for certificates at now: True when any
+// of them expires within window, nil without any certificate
+func certificatesCondition(certificates *clusterregistryv1beta1.CertificatesStatus, now time.Time, window time.Duration) *clusterregistryv1beta1.Condition {
+	if certificates.CANotAfter == nil && certificates.ClientNotAfter == nil {
+		return nil
+	}
+	condition := &clusterregistryv1beta1.Condition{
+		Type:    clusterregistryv1beta1.CertificateExpiring,
+		Status:  corev1.ConditionFalse,
+		Reason:  ReasonCertificatesValid,
+		Message: fmt.Sprintf("no certificate expires within %v", window),
+	}
+	var expiring []string
+	for _, certificate := range []struct {
+		name     string
+		notAfter *metav1.Time
+	}{
+		{"CA bundle", certificates.CANotAfter},
+		{"client certificate", certificates.ClientNotAfter},
+	} {
+		switch {
+		case certificate.notAfter == nil || certificate.notAfter.Time.After(now.Add(window)):
+		case !certificate.notAfter.Time.After(now):
+			condition.Reason = ReasonCertificateExpired
+			expiring = append(expiring, fmt.Sprintf("%s expired at %s", certificate.name, certificate.notAfter.UTC().Format(time.RFC3339)))
+		default:
+			if condition.Reason != ReasonCertificateExpired {
+				condition.Reason = ReasonCertificateExpiring
+			}
+			expiring = append(expiring, fmt.Sprintf("%s expires at %s", certificate.name, certificate.notAfter.UTC().Format(time.RFC3339)))
+		}
+	}
+	if len(expiring) > 0 {
+		condition.Status = corev1.ConditionTrue
+		condition.Message = strings.Join(expiring, ", ")
+	}
+	return condition
+}
+
+// The earliest NotAfter of the PEM encoded certificates, false without any
+func certificateExpiry(data []byte) (time.Time, bool) {
+	var earliest time.Time
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return earliest, !earliest.IsZero()
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		if earliest.IsZero() || certificate.NotAfter.Before(earliest) {
+			earliest = certificate.NotAfter
+		}
+	}
+}
+
+// The expiry of the client certificate of the current context of a
+// kubeconfig, false if it authenticates otherwise
+func clientCertificateExpiry(kubeconfig []byte) (time.Time, bool) {
+	if kubeconfig == nil {
+		return time.Time{}, false
+	}
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return time.Time{}, false
+	}
+	context, ok := config.Contexts[config.CurrentContext]
+	if !ok || config.AuthInfos[context.AuthInfo] == nil {
+		return time.Time{}, false
+	}
+	return certificateExpiry(config.AuthInfos[context.AuthInfo].ClientCertificateData)
+}
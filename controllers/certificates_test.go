@@ -0,0 +1,226 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// newCertificate returns a PEM encoded self-signed certificate expiring at notAfter
+func newCertificate(t *testing.T, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubernetes"},
+		NotBefore:             notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCertificateExpiry(t *testing.T) {
+	soon := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	later := soon.Add(30 * 24 * time.Hour)
+	key := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte("key")})
+
+	bundle := append(append(append([]byte{}, newCertificate(t, later)...), key...), newCertificate(t, soon)...)
+	if expiry, ok := certificateExpiry(bundle); !ok || !expiry.Equal(soon) {
+		t.Errorf("expected the earliest expiry %v, got %v, %v", soon, expiry, ok)
+	}
+	for _, data := range [][]byte{nil, []byte("ca"), key} {
+		if expiry, ok := certificateExpiry(data); ok {
+			t.Errorf("expected no expiry for %q, got %v", data, expiry)
+		}
+	}
+
+	kubeconfig := controllerKubeconfigData(t, &clientcmdapi.AuthInfo{ClientCertificateData: newCertificate(t, later)})
+	if expiry, ok := clientCertificateExpiry(kubeconfig); !ok || !expiry.Equal(later) {
+		t.Errorf("expected the client certificate expiry %v, got %v, %v", later, expiry, ok)
+	}
+	token := controllerKubeconfigData(t, &clientcmdapi.AuthInfo{Token: "token"})
+	if _, ok := clientCertificateExpiry(token); ok {
+		t.Error("expected no client certificate expiry for a token")
+	}
+}
+
+func TestCertificatesCondition(t *testing.T) {
+	now := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *metav1.Time {
+		return &metav1.Time{Time: now.Add(d)}
+	}
+	window := 30 * 24 * time.Hour
+
+	for name, tc := range map[string]struct {
+		certificates clusterregistryv1beta1.CertificatesStatus
+		status       corev1.ConditionStatus
+		reason       string
+		message      string
+	}{
+		"none": {},
+		"valid": {
+			certificates: clusterregistryv1beta1.CertificatesStatus{CANotAfter: at(10 * window), ClientNotAfter: at(2 * window)},
+			status:       corev1.ConditionFalse,
+			reason:       ReasonCertificatesValid,
+		},
+		"client expiring": {
+			certificates: clusterregistryv1beta1.CertificatesStatus{CANotAfter: at(10 * window), ClientNotAfter: at(window / 2)},
+			status:       corev1.ConditionTrue,
+			reason:       ReasonCertificateExpiring,
+			message:      "client certificate expires at 2020-04-16T00:00:00Z",
+		},
+		"ca expired": {
+			certificates: clusterregistryv1beta1.CertificatesStatus{CANotAfter: at(-time.Hour), ClientNotAfter: at(window / 2)},
+			status:       corev1.ConditionTrue,
+			reason:       ReasonCertificateExpired,
+			message:      "CA bundle expired at 2020-03-31T23:00:00Z, client certificate expires at 2020-04-16T00:00:00Z",
+		},
+	} {
+		condition := certificatesCondition(&tc.certificates, now, window)
+		if tc.status == "" {
+			if condition != nil {
+				t.Errorf("%s: expected no condition, got %+v", name, condition)
+			}
+			continue
+		}
+		if condition == nil || condition.Status != tc.status || condition.Reason != tc.reason ||
+			tc.message != "" && condition.Message != tc.message {
+			t.Errorf("%s: expected %s %s %q, got %+v", name, tc.status, tc.reason, tc.message, condition)
+		}
+	}
+}
+
+func TestCheckCertificates(t *testing.T) {
+	ctx := context.Background()
+	expiring := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	cluster := exportedCluster()
+	cluster.Spec.KubernetesAPIEndpoints.CABundle = newCertificate(t, expiring.Add(365*24*time.Hour))
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "member-credentials", Namespace: "clusters"},
+		Data: map[string][]byte{
+			clusterregistryv1beta1.ControllerKubeconfigKey: controllerKubeconfigData(t, &clientcmdapi.AuthInfo{ClientCertificateData: newCertificate(t, expiring)}),
+		},
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &ClusterReconciler{
+		Client:                  fake.NewFakeClientWithScheme(newExportScheme(t), credentials),
+		Log:                     ctrl.Log,
+		Recorder:                recorder,
+		CertificateExpiryWindow: defaultCertificateExpiryWindow,
+	}
+
+	if err := r.checkCertificates(ctx, cluster); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	certificates := cluster.Status.Certificates
+	if certificates == nil || !certificates.ClientNotAfter.Time.Equal(expiring) || !certificates.CANotAfter.Time.Equal(expiring.Add(365*24*time.Hour)) {
+		t.Errorf("expected the expiry of both certificates, got %+v", certificates)
+	}
+	condition := cluster.Status.GetCondition(clusterregistryv1beta1.CertificateExpiring)
+	if condition == nil || condition.Status != corev1.ConditionTrue || condition.Reason != ReasonCertificateExpiring {
+		t.Errorf("expected the client certificate to be expiring, got %+v", condition)
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Warning "+ReasonCertificateExpiring) {
+		t.Errorf("expected a Warning Event, got %q", event)
+	}
+
+	if err := r.checkCertificates(ctx, cluster); err != nil || len(recorder.Events) != 0 {
+		t.Errorf("expected no further Event, got %v and %d events", err, len(recorder.Events))
+	}
+
+	cluster.Spec.AuthInfo.Controller = nil
+	cluster.Spec.KubernetesAPIEndpoints.CABundle = []byte("ca")
+	if err := r.checkCertificates(ctx, cluster); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cluster.Status.Certificates != nil || cluster.Status.GetCondition(clusterregistryv1beta1.CertificateExpiring) != nil {
+		t.Errorf("expected no certificates to be recorded, got %+v", cluster.Status)
+	}
+}
+
+func TestCheckCertificatesOfSourceKubeconfig(t *testing.T) {
+	expiring := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	cluster := exportedCluster()
+	cluster.Spec.Source = &clusterregistryv1beta1.SourceReference{
+		APIVersion: "cluster.x-k8s.io/v1alpha3",
+		Kind:       "Cluster",
+		Namespace:  "clusters",
+		Name:       "workload",
+	}
+	// a bootstrapped ServiceAccount token holds no client certificate
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "member-credentials", Namespace: "clusters"},
+		Data: map[string][]byte{
+			clusterregistryv1beta1.ControllerKubeconfigKey: controllerKubeconfigData(t, &clientcmdapi.AuthInfo{Token: "sa-token"}),
+		},
+	}
+	admin := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload-kubeconfig", Namespace: "clusters"},
+		Data: map[string][]byte{
+			"value": controllerKubeconfigData(t, &clientcmdapi.AuthInfo{ClientCertificateData: newCertificate(t, expiring)}),
+		},
+	}
+	r := &ClusterReconciler{
+		Client:                  fake.NewFakeClientWithScheme(newExportScheme(t), credentials, admin),
+		Log:                     ctrl.Log,
+		Recorder:                record.NewFakeRecorder(10),
+		CertificateExpiryWindow: defaultCertificateExpiryWindow,
+	}
+
+	if err := r.checkCertificates(context.Background(), cluster); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	certificates := cluster.Status.Certificates
+	if certificates == nil || certificates.ClientNotAfter == nil || !certificates.ClientNotAfter.Time.Equal(expiring) {
+		t.Errorf("expected the expiry of the admin client certificate, got %+v", certificates)
+	}
+
+	// the admin kubeconfig of another namespace is not read
+	foreign := admin.DeepCopy()
+	foreign.Namespace = "tenant"
+	cluster.Spec.Source.Namespace = "tenant"
+	if kubeconfig, err := sourceKubeconfig(context.Background(), fake.NewFakeClientWithScheme(newExportScheme(t), foreign), cluster); err == nil || kubeconfig != nil {
+		t.Errorf("expected a source in another namespace to be refused, got %v", err)
+	}
+}
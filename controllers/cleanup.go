@@ -0,0 +1,155 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// StaleHoldRelease releases, once the manager has started, what controllers
+// that are no longer enabled hold and nothing else would release: the
+// pre-delete hooks of exporters and of the ServiceAccount bootstrap on
+// registry Clusters, and the source finalizer on the objects of sources.
+// Hooks set by consumers are left alone.
+type StaleHoldRelease struct {
+	Client client.Client
+	// Reader reads exported Secrets and source objects, which the manager
+	// may not cache
+	Reader client.Reader
+	Log    logr.Logger
+
+	// Sources and Exporters name the enabled sources and exporters
+	Sources   []string
+	Exporters []string
+	// Bootstrap tells whether bootstrapped ServiceAccounts are revoked
+	Bootstrap bool
+}
+
+// Start releases the stale holds, logging rather than failing the manager
+// when one cannot be released
+func (r *StaleHoldRelease) Start(<-chan struct{}) error {
+	r.Release(context.Background())
+	return nil
+}
+
+// Release the stale holds of all registry Clusters and of their sources
+func (r *StaleHoldRelease) Release(ctx context.Context) {
+	clusters := &clusterregistryv1beta1.ClusterList{}
+	if err := r.Client.List(ctx, clusters); err != nil {
+		r.Log.Error(err, "unable list Cluster registry")
+		return
+	}
+	released := map[types.UID]bool{}
+	for i := range clusters.Items {
+		clusterreg := &clusters.Items[i]
+		log := r.Log.WithValues("cluster-registry", types.NamespacedName{Namespace: clusterreg.Namespace, Name: clusterreg.Name})
+		if err := r.releaseHooks(ctx, clusterreg); err != nil {
+			log.Error(err, "unable release stale pre-delete hooks")
+		}
+
+		source, registered := clusterreg.Labels[clusterregistryv1beta1.SourceLabel]
+		owner := metav1.GetControllerOf(clusterreg)
+		if !registered || containsString(r.Sources, source) || owner == nil || released[owner.UID] {
+			continue
+		}
+		released[owner.UID] = true
+		if err := r.releaseSource(ctx, clusterreg.Namespace, owner); err != nil {
+			log.Error(err, "unable release source", "source", source, "kind", owner.Kind, "name", owner.Name)
+		}
+	}
+}
+
+// Remove the pre-delete hooks of disabled exporters and of the disabled
+// bootstrap, deleting the exported Secrets first if the cluster is going away
+func (r *StaleHoldRelease) releaseHooks(ctx context.Context, clusterreg *clusterregistryv1beta1.Cluster) error {
+	stale := []string{}
+	for key := range clusterreg.Annotations {
+		if key == bootstrapHook && !r.Bootstrap {
+			stale = append(stale, key)
+			continue
+		}
+		exporter := strings.TrimPrefix(key, exportHookPrefix)
+		if exporter == key || containsString(r.Exporters, exporter) {
+			continue
+		}
+		if clusterreg.DeletionTimestamp != nil {
+			if err := r.deleteExported(ctx, clusterreg, exporter); err != nil {
+				return err
+			}
+		}
+		stale = append(stale, key)
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	r.Log.Info("Release stale pre-delete hooks", "cluster-registry", types.NamespacedName{Namespace: clusterreg.Namespace, Name: clusterreg.Name}, "hooks", stale)
+	patch := client.MergeFrom(clusterreg.DeepCopy())
+	for _, key := range stale {
+		delete(clusterreg.Annotations, key)
+	}
+	return client.IgnoreNotFound(r.Client.Patch(ctx, clusterreg, patch))
+}
+
+// Delete the Secrets a disabled exporter exported for a registry Cluster
+func (r *StaleHoldRelease) deleteExported(ctx context.Context, clusterreg *clusterregistryv1beta1.Cluster, exporter string) error {
+	secrets := &corev1.SecretList{}
+	clusterKey := types.NamespacedName{Namespace: clusterreg.Namespace, Name: clusterreg.Name}
+	if err := r.Reader.List(ctx, secrets, client.MatchingLabels(exportLabels(exporter, clusterKey))); err != nil {
+		return err
+	}
+	for i := range secrets.Items {
+		if !exportedFor(&secrets.Items[i], exporter, clusterKey) {
+			continue
+		}
+		if err := r.Client.Delete(ctx, &secrets.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove the source finalizer from the source object of a disabled source
+func (r *StaleHoldRelease) releaseSource(ctx context.Context, namespace string, owner *metav1.OwnerReference) error {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(owner.APIVersion)
+	obj.SetKind(owner.Kind)
+	if err := r.Reader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: owner.Name}, obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !containsString(obj.GetFinalizers(), clusterregistryv1beta1.SourceFinalizer) {
+		return nil
+	}
+	r.Log.Info("Release source of a disabled cluster source", "kind", owner.Kind, "namespace", namespace, "name", owner.Name)
+	patch := client.MergeFrom(obj.DeepCopy())
+	obj.SetFinalizers(removeString(obj.GetFinalizers(), clusterregistryv1beta1.SourceFinalizer))
+	return client.IgnoreNotFound(r.Client.Patch(ctx, obj, patch))
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+func TestStaleHoldRelease(t *testing.T) {
+	ctx := context.Background()
+	controller := true
+	now := metav1.Now()
+	source := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:       "kubeconfigs",
+		Namespace:  "clusters",
+		UID:        "source-uid",
+		Finalizers: []string{clusterregistryv1beta1.SourceFinalizer},
+	}}
+	held := &clusterregistryv1beta1.Cluster{ObjectMeta: metav1.ObjectMeta{
+		Name:      "member",
+		Namespace: "clusters",
+		Labels:    map[string]string{clusterregistryv1beta1.SourceLabel: KubeconfigSecretSourceName},
+		Annotations: map[string]string{
+			exportHookPrefix + "argocd":     "argocd",
+			exportHookPrefix + "kubeconfig": "kubeconfig",
+			bootstrapHook:                   "member-kubeconfig",
+			clusterregistryv1beta1.PreDeleteHookAnnotationPrefix + "backup": "true",
+		},
+		OwnerReferences: []metav1.OwnerReference{{
+			APIVersion: "v1", Kind: "Secret", Name: source.Name, UID: source.UID, Controller: &controller,
+		}},
+		DeletionTimestamp: &now,
+	}}
+	exported := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "cluster-member",
+		Namespace: "argocd",
+		Labels: map[string]string{
+			clusterregistryv1beta1.ExporterLabel:         "argocd",
+			clusterregistryv1beta1.ClusterNamespaceLabel: held.Namespace,
+			clusterregistryv1beta1.ClusterNameLabel:      held.Name,
+		},
+	}}
+	hub := fake.NewFakeClientWithScheme(newExportScheme(t), source, held, exported)
+	r := &StaleHoldRelease{
+		Client:    hub,
+		Reader:    hub,
+		Log:       ctrl.Log,
+		Sources:   []string{ClusterApiSourceName},
+		Exporters: []string{"kubeconfig"},
+	}
+
+	r.Release(ctx)
+
+	clusterreg := &clusterregistryv1beta1.Cluster{}
+	if err := hub.Get(ctx, types.NamespacedName{Namespace: held.Namespace, Name: held.Name}, clusterreg); err != nil {
+		t.Fatal(err)
+	}
+	hooks := pendingHooks(clusterreg)
+	if len(hooks) != 2 || !containsString(hooks, "export-kubeconfig") || !containsString(hooks, "backup") {
+		t.Errorf("expected only the hooks of the enabled exporter and of consumers to be kept, got %v", hooks)
+	}
+	if err := hub.Get(ctx, types.NamespacedName{Namespace: exported.Namespace, Name: exported.Name}, &corev1.Secret{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the Secret of the disabled exporter to be deleted, got %v", err)
+	}
+	secret := &corev1.Secret{}
+	if err := hub.Get(ctx, types.NamespacedName{Namespace: source.Namespace, Name: source.Name}, secret); err != nil {
+		t.Fatal(err)
+	}
+	if containsString(secret.Finalizers, clusterregistryv1beta1.SourceFinalizer) {
+		t.Errorf("expected the source finalizer of the disabled source to be removed, got %v", secret.Finalizers)
+	}
+}
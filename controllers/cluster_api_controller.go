@@ -2,38 +2,85 @@ package controllers
 
 import (
 	"context"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/workqueue"
-	"time"
+	"fmt"
+	"strings"
 
-	clusterregistryv1alpha1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1alpha1"
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
 
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/controller"
-
-	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/discovery"
 )
 
-var Phase = "Providioned"
+// Phase is the phase Cluster API clusters without a Ready condition are
+// registered in, unless a readiness policy says otherwise
+var Phase = "Provisioned"
+
+// kubeconfigSuffix names the secret Cluster API writes for each cluster
+const kubeconfigSuffix = "-kubeconfig"
+
+// ClusterApiSourceName is the name of the Cluster API source
+const ClusterApiSourceName = "cluster-api"
+
+func init() {
+	RegisterSource(ClusterApiSourceName, func(mgr ctrl.Manager) (ClusterSource, error) {
+		client, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+		if err != nil {
+			return nil, err
+		}
+		version, err := DetectClusterAPIVersion(client)
+		if err != nil {
+			return nil, err
+		}
+		log := ctrl.Log.WithName("sources").WithName("Cluster-Api")
+		log.Info("using Cluster API", "version", version)
+		return &ClusterApiSource{
+			Client:  mgr.GetClient(),
+			Log:     log,
+			Version: version,
+		}, nil
+	})
+}
+
+// ClusterApiSource registers Cluster API clusters once they reach Phase,
+// using the kubeconfig secret Cluster API writes for them
+type ClusterApiSource struct {
+	Client client.Client
+	Log    logr.Logger
+
+	// Version is the Cluster API version clusters are read as, one of
+	// ClusterAPIVersions. Defaults to v1alpha3.
+	Version string
 
-type ClusterApiReconciler struct {
-	Client    client.Client
-	Log       logr.Logger
+	// ReadinessPolicies names a ConfigMap of readiness policies. Unset, or for
+	// clusters no policy applies to, the built-in readiness check is used.
+	ReadinessPolicies client.ObjectKey
 
-	Scheme    *runtime.Scheme
-	controller controller.Controller
-	// Work queue
-	Workqueue workqueue.RateLimitingInterface
+	// Bootstrap, if set, replaces the admin kubeconfig handed to controllers
+	// by a least privilege ServiceAccount bootstrapped in each cluster
+	Bootstrap *ServiceAccountBootstrap
 
-	// Log interval time
-	Interval   time.Duration
+	// Selector limits the clusters registered to those it matches the labels
+	// of. Registry Clusters of clusters no longer selected are removed. Nil
+	// selects all clusters.
+	Selector labels.Selector
+
+	// PropagateLabels and PropagateAnnotations select the labels and
+	// annotations of each cluster copied to its registry Cluster
+	PropagateLabels      KeyFilters
+	PropagateAnnotations KeyFilters
 }
 
 // +kubebuilder:rbac:groups=core,resources=events,verbs=get;list;watch;create;patch
@@ -43,117 +90,171 @@ type ClusterApiReconciler struct {
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters;clusters/status,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch
 
-func (r *ClusterApiReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error){
-
-	ctx := context.Background()
+func (s *ClusterApiSource) Name() string {
+	return ClusterApiSourceName
+}
 
-	// defer r.Workqueue.ShutDown()
+func (s *ClusterApiSource) NewObject() runtime.Object {
+	if s.Version == "" {
+		return newClusterAPICluster(clusterv1.GroupVersion.Version)
+	}
+	return newClusterAPICluster(s.Version)
+}
 
-	cluster := &clusterv1.Cluster{}
-	secret := &corev1.Secret{}
+// A rotated kubeconfig secret reconciles the cluster it belongs to
+func (s *ClusterApiSource) Watches() []SourceWatch {
+	return []SourceWatch{{
+		Source: &source.Kind{Type: &corev1.Secret{}},
+		Handler: &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(secretToCluster),
+		},
+	}}
+}
 
-	log := r.Log.WithValues("Cluster api", req.Namespace)
+// Ready checks the gates of the readiness policy of the cluster. Without
+// one it uses the Ready and ControlPlaneReady conditions where the cluster
+// reports them, and Phase otherwise.
+func (s *ClusterApiSource) Ready(ctx context.Context, obj runtime.Object) (bool, string, error) {
+	cluster := obj.(*unstructured.Unstructured)
+	policy, err := s.readinessPolicy(ctx, cluster)
+	if err != nil {
+		return false, "", err
+	}
+	if policy == nil {
+		ready, reason := clusterAPIReadiness(cluster, Phase)
+		return ready, reason, nil
+	}
+	if gate, reason := policy.Blocking(cluster); gate != "" {
+		return false, "gate " + gate + ": " + reason, nil
+	}
+	return true, "", nil
+}
 
-	if err := r.Client.Get(ctx, req.NamespacedName,cluster); err != nil{
-		log.Error(err, "unable fetch Cluster api")
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+// The readiness policies and the bootstrap template apply to all clusters
+func (s *ClusterApiSource) ConfigMaps() []client.ObjectKey {
+	keys := []client.ObjectKey{}
+	if s.ReadinessPolicies.Name != "" {
+		keys = append(keys, s.ReadinessPolicies)
+	}
+	if s.Bootstrap != nil {
+		keys = append(keys, s.Bootstrap.Template)
 	}
+	return keys
+}
 
-	log.Info("Add WorkQueue")
-	r.Workqueue.Add(req.NamespacedName)
-	value, ok := r.Workqueue.Get()
-	r.ProcessQueue(ctx,cluster,secret,value,ok)
+// The readiness policy of a cluster, nil for the built-in check
+func (s *ClusterApiSource) readinessPolicy(ctx context.Context, cluster *unstructured.Unstructured) (*ReadinessPolicy, error) {
+	if s.ReadinessPolicies.Name == "" {
+		return nil, nil
+	}
+	configMap := &corev1.ConfigMap{}
+	if err := s.Client.Get(ctx, s.ReadinessPolicies, configMap); err != nil {
+		if apierrors.IsNotFound(err) {
+			s.Log.Info("readiness policies ConfigMap not found, using the built-in check", "configMap", s.ReadinessPolicies)
+			return nil, nil
+		}
+		return nil, err
+	}
+	policies, err := ParseReadinessPolicies(configMap.Data[ReadinessPoliciesKey])
+	if err != nil {
+		return nil, fmt.Errorf("readiness policies ConfigMap %s: %v", s.ReadinessPolicies, err)
+	}
+	return policies.For(cluster), nil
+}
 
-	return ctrl.Result{}, nil
+// Selects the clusters matching Selector
+func (s *ClusterApiSource) Selects(obj runtime.Object) bool {
+	return s.Selector == nil || s.Selector.Matches(labels.Set(obj.(*unstructured.Unstructured).GetLabels()))
 }
 
-// Create cluster registry
-func (r *ClusterApiReconciler) CreateClusterRegistry(ctx context.Context,value client.ObjectKey,cluster *clusterv1.Cluster,config *clientcmdapi.Config) error {
+func (s *ClusterApiSource) Describe(ctx context.Context, obj runtime.Object) ([]*clusterregistryv1beta1.Cluster, error) {
+	cluster := obj.(*unstructured.Unstructured)
+	secret := &corev1.Secret{}
+	clusterreg, err := s.GetSecret(ctx, client.ObjectKey{Namespace: cluster.GetNamespace(), Name: cluster.GetName()}, secret, cluster)
+	if err != nil {
+		return nil, err
+	}
+	clusterLabels, clusterAnnotations, err := s.clusterMetadata(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+	clusterreg.Labels, _ = mergeStrings(clusterreg.Labels, clusterLabels, nil, false)
+	clusterreg.Annotations, _ = mergeStrings(clusterreg.Annotations, clusterAnnotations, nil, false)
+	return []*clusterregistryv1beta1.Cluster{clusterreg}, nil
+}
 
-	log := r.Log.WithValues("Cluster registry",value.Namespace)
-	var req ctrl.Request
-	req.Name = value.Name+"-cluster-registry"
-	req.Namespace = value.Namespace
-	clusterreg := &clusterregistryv1alpha1.Cluster{}
-	errs := r.Client.Get(ctx,req.NamespacedName,clusterreg)
-	if errs != nil{
-		log.Info("Create Cluster registry","ClusterRegistry",req.NamespacedName)
-		clusterreg := CreateClusterRegistry(req.Name,
-			req.Namespace,
-			cluster,
-			config.Clusters[cluster.Name].CertificateAuthorityData,
-			config.Clusters[cluster.Name].Server)
-		err := r.Client.Create(ctx,clusterreg)
-		if err != nil{
-			log.Error(err,"Create Cluster registry fail")
-			return err
-		}
-	}else{
-		log.Info("Cluster registry already exits")
-		return nil
+// Controllers reach the cluster with the admin kubeconfig Cluster API wrote
+// for it, or the ServiceAccount bootstrapped with it
+func (s *ClusterApiSource) Credential(ctx context.Context, obj runtime.Object, clusterreg *clusterregistryv1beta1.Cluster) ([]byte, error) {
+	cluster := obj.(*unstructured.Unstructured)
+	secret := &corev1.Secret{}
+	if err := s.Client.Get(ctx, client.ObjectKey{Namespace: cluster.GetNamespace(), Name: cluster.GetName() + kubeconfigSuffix}, secret); err != nil {
+		return nil, err
+	}
+	if s.Bootstrap != nil {
+		return s.Bootstrap.Credential(ctx, clusterreg, secret, secret.Data["value"])
 	}
-	return nil
+	return secret.Data["value"], nil
 }
 
-// Get secret according cluster name and namespace
-func (r *ClusterApiReconciler) GetSecret(ctx context.Context,value client.ObjectKey,secret *corev1.Secret,cluster *clusterv1.Cluster) error {
-	log := r.Log.WithValues("Secret namespace",value.Namespace)
+// The control plane endpoint Cluster API reports is often the external one
+func (s *ClusterApiSource) EndpointData(obj runtime.Object, data *EndpointTemplateData) {
+	data.ControlPlaneHost, data.ControlPlanePort = clusterAPIEndpoint(obj.(*unstructured.Unstructured))
+}
+
+// Get secret according cluster name and namespace, and build the cluster registry from it
+func (s *ClusterApiSource) GetSecret(ctx context.Context, value client.ObjectKey, secret *corev1.Secret, cluster metav1.Object) (*clusterregistryv1beta1.Cluster, error) {
+	log := s.Log.WithValues("Secret namespace", value.Namespace)
 	var req ctrl.Request
-	req.Name = value.Name+"-kubeconfig"
+	req.Name = value.Name + kubeconfigSuffix
 	req.Namespace = value.Namespace
-	if err := r.Client.Get(ctx,req.NamespacedName,secret); err != nil{
-		log.Info("secret not exit","secret",req.NamespacedName)
-		return err
-	} else {
-		fg := secret.Data["value"]
-		config,err := clientcmd.Load(fg)
-		if err != nil {
-			log.Error(err,"Can not load kube-config")
-		}
-		return r.CreateClusterRegistry(ctx,value,cluster,config)
+	if err := s.Client.Get(ctx, req.NamespacedName, secret); err != nil {
+		log.Info("secret not exit", "secret", req.NamespacedName)
+		return nil, err
+	}
+	clusterreg, err := ClusterAPIRegistry(secret, cluster)
+	if err != nil {
+		log.Error(err, "Can not use kube-config", "secret", req.NamespacedName)
+		return nil, err
 	}
+	return clusterreg, nil
 }
 
-// Process Work queue
-func (r *ClusterApiReconciler) ProcessQueue(ctx context.Context, cluster *clusterv1.Cluster,secret *corev1.Secret,key interface{},status bool) (ctrl.Result, error){
-	log := r.Log.WithValues("namespace",cluster.Namespace)
-	for{
-		if status{
-			log.Info("Cluster queue get element error")
-			return ctrl.Result{}, nil
-		}
-
-		if value, ok := key.(client.ObjectKey); ok {
-			err := r.Client.Get(ctx,value,cluster)
-			if err != nil{
-				log.Error(err, "unable fetch cluster api")
-				r.Workqueue.Done(value)
-				return ctrl.Result{}, client.IgnoreNotFound(err)
-			}
-
-			status := cluster.Status.Phase
-			if status != Phase {
-				log.Info("Cluster api status not ready","cluster name:",cluster.Name)
-			} else {
-				log.Info("Cluster api status ready","cluster name:",cluster.Name)
-				err := r.GetSecret(ctx, value, secret, cluster)
-				if err == nil{
-					r.Workqueue.Done(value)
-					return ctrl.Result{},nil
-				}
-			}
-		}
-		time.Sleep(r.Interval *time.Second)
-		}
+// ClusterAPIRegistry builds the registry Cluster of a Cluster API cluster
+// from its kubeconfig secret. A kubeconfig that cannot be used is an
+// InvalidSourceError.
+func ClusterAPIRegistry(secret *corev1.Secret, cluster metav1.Object) (*clusterregistryv1beta1.Cluster, error) {
+	config, err := loadKubeconfig(secret.Data["value"])
+	if err != nil {
+		return nil, invalidKubeconfig(secret.Name, err)
+	}
+	kubeconfigCluster, err := resolveCluster(config, cluster.GetName())
+	if err != nil {
+		return nil, invalidKubeconfig(secret.Name, err)
+	}
+	clusterreg, err := registryFromKubeconfig(cluster.GetName()+"-cluster-registry", cluster.GetNamespace(), kubeconfigCluster)
+	if err != nil {
+		return nil, invalidKubeconfig(secret.Name, err)
+	}
+	return clusterreg, nil
 }
 
-// Setup method for controller
-func (r *ClusterApiReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
-	// cluster-api band with cluster-registry
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&clusterv1.Cluster{}).
-		Owns(&clusterregistryv1alpha1.Cluster{}).
-		WithOptions(options).
-		Complete(r)
+// invalidKubeconfig reports a kubeconfig secret that cannot be used
+func invalidKubeconfig(secret string, err error) error {
+	return &InvalidSourceError{Reason: ReasonKubeconfigInvalid, Err: fmt.Errorf("secret %s: %v", secret, err)}
 }
 
+// secretToCluster maps a "<name>-kubeconfig" secret to the CAPI Cluster it belongs to
+func secretToCluster(o handler.MapObject) []ctrl.Request {
+	name, ok := o.Meta.GetLabels()[clusterv1.ClusterLabelName]
+	if !ok {
+		if !strings.HasSuffix(o.Meta.GetName(), kubeconfigSuffix) {
+			return nil
+		}
+		name = strings.TrimSuffix(o.Meta.GetName(), kubeconfigSuffix)
+	}
+	if o.Meta.GetName() != name+kubeconfigSuffix {
+		return nil
+	}
+	return []ctrl.Request{{NamespacedName: client.ObjectKey{Namespace: o.Meta.GetNamespace(), Name: name}}}
+}
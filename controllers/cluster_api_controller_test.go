@@ -0,0 +1,260 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// newKubeconfigSecret returns the "<name>-kubeconfig" secret Cluster API
+// writes for a provisioned cluster.
+func newKubeconfigSecret(namespace, name, server string) *corev1.Secret {
+	config := clientcmdapi.NewConfig()
+	config.Clusters[name] = &clientcmdapi.Cluster{
+		Server:                   server,
+		CertificateAuthorityData: []byte("ca"),
+	}
+	config.AuthInfos[name+"-admin"] = &clientcmdapi.AuthInfo{Token: "token"}
+	config.Contexts[name+"-admin@"+name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name + "-admin"}
+	config.CurrentContext = name + "-admin@" + name
+	data, err := clientcmd.Write(*config)
+	Expect(err).ToNot(HaveOccurred())
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name + "-kubeconfig", Namespace: namespace},
+		Data:       map[string][]byte{"value": data},
+	}
+}
+
+var _ = Describe("ClusterApiSource", func() {
+	const timeout = 10 * time.Second
+
+	ctx := context.Background()
+
+	It("registers a ready cluster while many others are still pending", func() {
+		By("creating clusters that never become ready")
+		for i := 0; i < 50; i++ {
+			pending := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pending-%d", i), Namespace: "default"},
+			}
+			Expect(k8sClient.Create(ctx, pending)).To(Succeed())
+		}
+
+		By("creating a ready cluster with its kubeconfig")
+		ready := &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "ready", Namespace: "default"},
+		}
+		Expect(k8sClient.Create(ctx, ready)).To(Succeed())
+		Expect(k8sClient.Create(ctx, newKubeconfigSecret("default", "ready", "https://10.0.0.1:6443"))).To(Succeed())
+		ready.Status.Phase = Phase
+		Expect(k8sClient.Status().Update(ctx, ready)).To(Succeed())
+
+		key := types.NamespacedName{Name: "ready-cluster-registry", Namespace: "default"}
+		reg := &clusterregistryv1beta1.Cluster{}
+		Eventually(func() error {
+			return k8sClient.Get(ctx, key, reg)
+		}, timeout).Should(Succeed())
+		Expect(reg.Spec.Source).NotTo(BeNil())
+		Expect(reg.Spec.Source.Kind).To(Equal("Cluster"))
+		Expect(reg.Spec.Source.Name).To(Equal("ready"))
+		Expect(reg.Spec.Source.UID).To(Equal(ready.UID))
+
+		By("recording the registration on both clusters")
+		Eventually(eventReasons("ready"), timeout).Should(ContainElement("Normal/" + ReasonRegistered))
+		Eventually(eventReasons("ready-cluster-registry"), timeout).Should(ContainElement("Normal/" + ReasonRegistered))
+		Eventually(eventReasons("pending-0"), timeout).Should(ContainElement("Normal/" + ReasonRegistrationBlocked))
+
+		By("leaving the pending clusters unregistered")
+		Consistently(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Name: "pending-0-cluster-registry", Namespace: "default"}, &clusterregistryv1beta1.Cluster{})
+		}, 2*time.Second).ShouldNot(Succeed())
+	})
+
+	It("propagates a rotated kubeconfig secret to the registry", func() {
+		cluster := &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "rotated", Namespace: "default"},
+		}
+		Expect(k8sClient.Create(ctx, cluster)).To(Succeed())
+		secret := newKubeconfigSecret("default", "rotated", "https://10.0.0.2:6443")
+		Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+		cluster.Status.Phase = Phase
+		Expect(k8sClient.Status().Update(ctx, cluster)).To(Succeed())
+
+		server := func() string {
+			reg := &clusterregistryv1beta1.Cluster{}
+			key := types.NamespacedName{Name: "rotated-cluster-registry", Namespace: "default"}
+			if err := k8sClient.Get(ctx, key, reg); err != nil || len(reg.Spec.KubernetesAPIEndpoints.ServerEndpoints) == 0 {
+				return ""
+			}
+			return reg.Spec.KubernetesAPIEndpoints.ServerEndpoints[0].ServerAddress
+		}
+		Eventually(server, timeout).Should(Equal("https://10.0.0.2:6443"))
+
+		By("moving the API server endpoint in the secret")
+		secret.Data = newKubeconfigSecret("default", "rotated", "https://10.0.0.3:6443").Data
+		Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+		Eventually(server, timeout).Should(Equal("https://10.0.0.3:6443"))
+	})
+
+	It("flags a registry whose kubeconfig secret became invalid", func() {
+		cluster := &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "broken", Namespace: "default"},
+		}
+		Expect(k8sClient.Create(ctx, cluster)).To(Succeed())
+		secret := newKubeconfigSecret("default", "broken", "https://10.0.0.4:6443")
+		Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+		cluster.Status.Phase = Phase
+		Expect(k8sClient.Status().Update(ctx, cluster)).To(Succeed())
+
+		valid := func() corev1.ConditionStatus {
+			reg := &clusterregistryv1beta1.Cluster{}
+			key := types.NamespacedName{Name: "broken-cluster-registry", Namespace: "default"}
+			if err := k8sClient.Get(ctx, key, reg); err != nil {
+				return ""
+			}
+			if c := reg.Status.GetCondition(clusterregistryv1beta1.KubeconfigValid); c != nil {
+				return c.Status
+			}
+			return ""
+		}
+		Eventually(valid, timeout).Should(Equal(corev1.ConditionTrue))
+
+		By("corrupting the kubeconfig")
+		secret.Data["value"] = []byte("apiVersion: v1\nkind: Config\nclusters: {")
+		Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+		Eventually(valid, timeout).Should(Equal(corev1.ConditionFalse))
+	})
+	It("derives server endpoints per client CIDR from the cluster annotation", func() {
+		cluster := &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "split",
+				Namespace: "default",
+				Annotations: map[string]string{
+					"lb.example.com/address": "https://lb.example.com:443",
+					clusterregistryv1beta1.ServerEndpointsAnnotation: `
+- clientCIDR: 10.0.0.0/8
+  serverAddress: "https://{{ .ControlPlaneHost }}:{{ .ControlPlanePort }}"
+- clientCIDR: 0.0.0.0/0
+  serverAddress: '{{ index .Annotations "lb.example.com/address" }}'
+`,
+				},
+			},
+			Spec: clusterv1.ClusterSpec{
+				ControlPlaneEndpoint: clusterv1.APIEndpoint{Host: "10.0.0.5", Port: 6443},
+			},
+		}
+		Expect(k8sClient.Create(ctx, cluster)).To(Succeed())
+		Expect(k8sClient.Create(ctx, newKubeconfigSecret("default", "split", "https://10.0.0.5:6443"))).To(Succeed())
+		cluster.Status.Phase = Phase
+		Expect(k8sClient.Status().Update(ctx, cluster)).To(Succeed())
+
+		Eventually(func() []clusterregistryv1beta1.ServerAddressByClientCIDR {
+			reg := &clusterregistryv1beta1.Cluster{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Name: "split-cluster-registry", Namespace: "default"}, reg); err != nil {
+				return nil
+			}
+			return reg.Spec.KubernetesAPIEndpoints.ServerEndpoints
+		}, timeout).Should(Equal([]clusterregistryv1beta1.ServerAddressByClientCIDR{
+			{ClientCIDR: "10.0.0.0/8", ServerAddress: "https://10.0.0.5:6443"},
+			{ClientCIDR: "0.0.0.0/0", ServerAddress: "https://lb.example.com:443"},
+		}))
+	})
+	It("references a controller credential copied from the kubeconfig secret", func() {
+		cluster := &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "credential", Namespace: "default"},
+		}
+		Expect(k8sClient.Create(ctx, cluster)).To(Succeed())
+		secret := newKubeconfigSecret("default", "credential", "https://10.0.0.6:6443")
+		Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+		cluster.Status.Phase = Phase
+		Expect(k8sClient.Status().Update(ctx, cluster)).To(Succeed())
+
+		reg := &clusterregistryv1beta1.Cluster{}
+		Eventually(func() *clusterregistryv1beta1.ObjectReference {
+			if err := k8sClient.Get(ctx, types.NamespacedName{Name: "credential-cluster-registry", Namespace: "default"}, reg); err != nil {
+				return nil
+			}
+			return reg.Spec.AuthInfo.Controller
+		}, timeout).Should(Equal(&clusterregistryv1beta1.ObjectReference{
+			Kind:      "Secret",
+			Name:      "credential-cluster-registry-credentials",
+			Namespace: "default",
+		}))
+
+		credential := func() []byte {
+			managed := &corev1.Secret{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Name: "credential-cluster-registry-credentials", Namespace: "default"}, managed); err != nil {
+				return nil
+			}
+			return managed.Data[clusterregistryv1beta1.ControllerKubeconfigKey]
+		}
+		Eventually(credential, timeout).Should(Equal(secret.Data["value"]))
+
+		By("rotating the kubeconfig secret")
+		secret.Data = newKubeconfigSecret("default", "credential", "https://10.0.0.7:6443").Data
+		Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+		Eventually(credential, timeout).Should(Equal(secret.Data["value"]))
+	})
+	It("reports the readiness gate blocking a registered cluster", func() {
+		cluster := &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "regressed", Namespace: "default"},
+		}
+		Expect(k8sClient.Create(ctx, cluster)).To(Succeed())
+		Expect(k8sClient.Create(ctx, newKubeconfigSecret("default", "regressed", "https://10.0.0.8:6443"))).To(Succeed())
+		cluster.Status.Phase = Phase
+		Expect(k8sClient.Status().Update(ctx, cluster)).To(Succeed())
+
+		sourceReady := func() *clusterregistryv1beta1.Condition {
+			reg := &clusterregistryv1beta1.Cluster{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Name: "regressed-cluster-registry", Namespace: "default"}, reg); err != nil {
+				return nil
+			}
+			return reg.Status.GetCondition(clusterregistryv1beta1.SourceReady)
+		}
+		Eventually(func() corev1.ConditionStatus {
+			if c := sourceReady(); c != nil {
+				return c.Status
+			}
+			return ""
+		}, timeout).Should(Equal(corev1.ConditionTrue))
+
+		By("moving the cluster back to provisioning")
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "regressed", Namespace: "default"}, cluster)).To(Succeed())
+		cluster.Status.Phase = "Provisioning"
+		Expect(k8sClient.Status().Update(ctx, cluster)).To(Succeed())
+		Eventually(func() string {
+			if c := sourceReady(); c != nil && c.Status == corev1.ConditionFalse {
+				return c.Message
+			}
+			return ""
+		}, timeout).Should(ContainSubstring("Provisioning"))
+	})
+})
@@ -0,0 +1,142 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// KeyFilters select label or annotation keys: an entry ending in "*" matches
+// the keys starting with the rest of it, any other entry that key only
+type KeyFilters []string
+
+// Matches tells whether any of the filters matches key
+func (f KeyFilters) Matches(key string) bool {
+	for _, filter := range f {
+		if strings.HasSuffix(filter, "*") && strings.HasPrefix(key, strings.TrimSuffix(filter, "*")) || filter == key {
+			return true
+		}
+	}
+	return false
+}
+
+// filterKeys returns the entries of from whose keys the filters match, nil if none do
+func filterKeys(from map[string]string, filters KeyFilters) map[string]string {
+	var filtered map[string]string
+	for k, v := range from {
+		if !filters.Matches(k) {
+			continue
+		}
+		if filtered == nil {
+			filtered = map[string]string{}
+		}
+		filtered[k] = v
+	}
+	return filtered
+}
+
+// The labels and annotations of a registry Cluster taken from the Cluster API
+// Cluster it is registered from: those the Propagate filters select, plus the
+// provider, Kubernetes version and region labels derived from the cluster,
+// its control plane and its infrastructure unless already propagated
+func (s *ClusterApiSource) clusterMetadata(ctx context.Context, cluster *unstructured.Unstructured) (map[string]string, map[string]string, error) {
+	labels := filterKeys(cluster.GetLabels(), s.PropagateLabels)
+	annotations := filterKeys(cluster.GetAnnotations(), s.PropagateAnnotations)
+
+	derived := map[string]string{
+		clusterregistryv1beta1.ProviderLabel: clusterAPIProvider(cluster),
+	}
+	version, _, _ := unstructured.NestedString(cluster.Object, "spec", "topology", "version")
+	if version == "" {
+		controlPlane, err := s.clusterAPIRef(ctx, cluster, "controlPlaneRef")
+		if err != nil {
+			return nil, nil, err
+		}
+		if controlPlane != nil {
+			version, _, _ = unstructured.NestedString(controlPlane.Object, "spec", "version")
+		}
+	}
+	derived[clusterregistryv1beta1.KubernetesVersionLabel] = version
+	infrastructure, err := s.clusterAPIRef(ctx, cluster, "infrastructureRef")
+	if err != nil {
+		return nil, nil, err
+	}
+	if infrastructure != nil {
+		derived[clusterregistryv1beta1.RegionLabel] = infrastructureRegion(infrastructure)
+	}
+
+	for k, v := range derived {
+		if _, ok := labels[k]; ok || v == "" || len(validation.IsValidLabelValue(v)) > 0 {
+			continue
+		}
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[k] = v
+	}
+	return labels, annotations, nil
+}
+
+// clusterAPIProvider names the infrastructure provider of a Cluster after the
+// kind of its infrastructureRef, e.g. aws for an AWSCluster
+func clusterAPIProvider(cluster *unstructured.Unstructured) string {
+	kind, _, _ := unstructured.NestedString(cluster.Object, "spec", "infrastructureRef", "kind")
+	return strings.ToLower(strings.TrimSuffix(kind, "Cluster"))
+}
+
+// infrastructureRegion returns the region of an infrastructure cluster, such
+// as spec.region of an AWSCluster or spec.location of an AzureCluster
+func infrastructureRegion(infrastructure *unstructured.Unstructured) string {
+	for _, field := range []string{"region", "location"} {
+		if region, _, _ := unstructured.NestedString(infrastructure.Object, "spec", field); region != "" {
+			return region
+		}
+	}
+	return ""
+}
+
+// Fetch the object a reference in the spec of a Cluster points to, nil when
+// the cluster has no such reference or the object or its kind does not exist (yet)
+func (s *ClusterApiSource) clusterAPIRef(ctx context.Context, cluster *unstructured.Unstructured, field string) (*unstructured.Unstructured, error) {
+	ref, found, _ := unstructured.NestedStringMap(cluster.Object, "spec", field)
+	if !found || ref["apiVersion"] == "" || ref["kind"] == "" || ref["name"] == "" {
+		return nil, nil
+	}
+	namespace := ref["namespace"]
+	if namespace == "" {
+		namespace = cluster.GetNamespace()
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(ref["apiVersion"])
+	obj.SetKind(ref["kind"])
+	if err := s.Client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: ref["name"]}, obj); err != nil {
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return obj, nil
+}
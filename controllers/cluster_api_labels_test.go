@@ -0,0 +1,195 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// newUnstructured returns an object of the given kind with a spec
+func newUnstructured(apiVersion, kind, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace("clusters")
+	obj.SetName(name)
+	return obj
+}
+
+func TestKeyFilters(t *testing.T) {
+	filters := KeyFilters{"env", "example.com/*"}
+	for key, matches := range map[string]bool{
+		"env":                 true,
+		"environment":         false,
+		"example.com/team":    true,
+		"example.com":         false,
+		"sub.example.com/env": false,
+	} {
+		if filters.Matches(key) != matches {
+			t.Errorf("%s: expected %v", key, matches)
+		}
+	}
+	if filtered := filterKeys(map[string]string{"tier": "edge"}, filters); filtered != nil {
+		t.Errorf("expected nothing to be selected, got %v", filtered)
+	}
+}
+
+func TestClusterMetadata(t *testing.T) {
+	ctx := context.Background()
+	cluster := newUnstructured("cluster.x-k8s.io/v1alpha3", "Cluster", "prod-eu", map[string]interface{}{
+		"infrastructureRef": map[string]interface{}{
+			"apiVersion": "infrastructure.cluster.x-k8s.io/v1alpha3", "kind": "AWSCluster", "name": "prod-eu",
+		},
+		"controlPlaneRef": map[string]interface{}{
+			"apiVersion": "controlplane.cluster.x-k8s.io/v1alpha3", "kind": "KubeadmControlPlane", "name": "prod-eu-control-plane",
+		},
+	})
+	cluster.SetLabels(map[string]string{"env": "prod", "example.com/team": "payments", "cluster.x-k8s.io/cluster-name": "prod-eu"})
+	cluster.SetAnnotations(map[string]string{"example.com/owner": "payments@example.com", "note": "internal"})
+	s := &ClusterApiSource{
+		Client: fake.NewFakeClientWithScheme(runtime.NewScheme(),
+			newUnstructured("infrastructure.cluster.x-k8s.io/v1alpha3", "AWSCluster", "prod-eu", map[string]interface{}{"region": "eu-west-1"}),
+			newUnstructured("controlplane.cluster.x-k8s.io/v1alpha3", "KubeadmControlPlane", "prod-eu-control-plane", map[string]interface{}{"version": "v1.17.3"}),
+		),
+		Log:                  ctrl.Log,
+		PropagateLabels:      KeyFilters{"env", "example.com/*"},
+		PropagateAnnotations: KeyFilters{"example.com/*"},
+	}
+
+	clusterLabels, annotations, err := s.clusterMetadata(ctx, cluster)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"env":                                         "prod",
+		"example.com/team":                            "payments",
+		clusterregistryv1beta1.ProviderLabel:          "aws",
+		clusterregistryv1beta1.RegionLabel:            "eu-west-1",
+		clusterregistryv1beta1.KubernetesVersionLabel: "v1.17.3",
+	}
+	if !reflect.DeepEqual(clusterLabels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, clusterLabels)
+	}
+	if !reflect.DeepEqual(annotations, map[string]string{"example.com/owner": "payments@example.com"}) {
+		t.Errorf("expected the example.com annotations, got %v", annotations)
+	}
+
+	// propagated labels win over derived ones, missing references are skipped
+	cluster.SetLabels(map[string]string{clusterregistryv1beta1.RegionLabel: "eu"})
+	s.PropagateLabels = KeyFilters{clusterregistryv1beta1.RegionLabel}
+	unstructured.RemoveNestedField(cluster.Object, "spec", "controlPlaneRef")
+	clusterLabels, _, err = s.clusterMetadata(ctx, cluster)
+	expected = map[string]string{
+		clusterregistryv1beta1.ProviderLabel: "aws",
+		clusterregistryv1beta1.RegionLabel:   "eu",
+	}
+	if err != nil || !reflect.DeepEqual(clusterLabels, expected) {
+		t.Errorf("expected labels %v, got %v, %v", expected, clusterLabels, err)
+	}
+}
+
+func TestClusterApiSourceSelects(t *testing.T) {
+	cluster := newUnstructured("cluster.x-k8s.io/v1alpha3", "Cluster", "prod-eu", nil)
+	cluster.SetLabels(map[string]string{"env": "prod"})
+
+	if !(&ClusterApiSource{}).Selects(cluster) {
+		t.Errorf("expected all clusters to be selected without a selector")
+	}
+	selector, _ := labels.Parse("env in (prod, staging)")
+	if !(&ClusterApiSource{Selector: selector}).Selects(cluster) {
+		t.Errorf("expected %s to select the cluster", selector)
+	}
+	selector, _ = labels.Parse("env=dev")
+	if (&ClusterApiSource{Selector: selector}).Selects(cluster) {
+		t.Errorf("expected %s not to select the cluster", selector)
+	}
+}
+
+func TestCreateOrUpdateRemovesStaleMetadata(t *testing.T) {
+	ctx := context.Background()
+	source := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "kubeconfigs", Namespace: "clusters", UID: "source-uid"}}
+	scheme := newExportScheme(t)
+	hub := fake.NewFakeClientWithScheme(scheme, source)
+	r := &SourceReconciler{
+		Client:   hub,
+		Log:      ctrl.Log,
+		Scheme:   scheme,
+		Recorder: record.NewFakeRecorder(10),
+		Source:   &KubeconfigSecretSource{},
+	}
+	desired := func(clusterLabels, clusterAnnotations map[string]string) *clusterregistryv1beta1.Cluster {
+		return &clusterregistryv1beta1.Cluster{ObjectMeta: metav1.ObjectMeta{
+			Name:        "member",
+			Namespace:   "clusters",
+			Labels:      clusterLabels,
+			Annotations: clusterAnnotations,
+		}}
+	}
+
+	if _, err := r.createOrUpdate(ctx, source, desired(
+		map[string]string{clusterregistryv1beta1.ProviderLabel: "aws", clusterregistryv1beta1.RegionLabel: "eu"},
+		map[string]string{clusterregistryv1beta1.InsecureSkipTLSVerifyAnnotation: "true"},
+	)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// set by users, not by the source
+	clusterreg := &clusterregistryv1beta1.Cluster{}
+	key := types.NamespacedName{Namespace: "clusters", Name: "member"}
+	if err := hub.Get(ctx, key, clusterreg); err != nil {
+		t.Fatal(err)
+	}
+	clusterreg.Labels["team"] = "payments"
+	clusterreg.Annotations["note"] = "x"
+	if err := hub.Update(ctx, clusterreg); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.createOrUpdate(ctx, source, desired(
+		map[string]string{clusterregistryv1beta1.ProviderLabel: "aws"}, nil,
+	)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clusterreg = &clusterregistryv1beta1.Cluster{}
+	if err := hub.Get(ctx, key, clusterreg); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		clusterregistryv1beta1.ProviderLabel: "aws",
+		clusterregistryv1beta1.SourceLabel:   KubeconfigSecretSourceName,
+		"team":                               "payments",
+	}
+	if !reflect.DeepEqual(clusterreg.Labels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, clusterreg.Labels)
+	}
+	if _, ok := clusterreg.Annotations[clusterregistryv1beta1.InsecureSkipTLSVerifyAnnotation]; ok || clusterreg.Annotations["note"] != "x" {
+		t.Errorf("expected only the annotation the source no longer sets to be removed, got %v", clusterreg.Annotations)
+	}
+}
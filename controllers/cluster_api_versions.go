@@ -0,0 +1,112 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// ClusterAPIGroup is the API group of Cluster API clusters
+const ClusterAPIGroup = "cluster.x-k8s.io"
+
+// ClusterAPIVersions lists the Cluster API versions the source works with,
+// newest first. Clusters are read as unstructured objects, so the fields the
+// source uses must keep their meaning across all of them.
+var ClusterAPIVersions = []string{"v1beta1", "v1alpha4", "v1alpha3"}
+
+// DetectClusterAPIVersion returns the newest supported Cluster API version
+// the API server serves
+func DetectClusterAPIVersion(client discovery.DiscoveryInterface) (string, error) {
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return "", err
+	}
+	served := map[string]bool{}
+	for _, group := range groups.Groups {
+		if group.Name != ClusterAPIGroup {
+			continue
+		}
+		for _, version := range group.Versions {
+			served[version.Version] = true
+		}
+	}
+	for _, version := range ClusterAPIVersions {
+		if served[version] {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("none of the Cluster API versions %v is served", ClusterAPIVersions)
+}
+
+// clusterAPIReadiness tells whether an unstructured Cluster API Cluster is
+// ready to be registered. Clusters reporting a Ready condition, as all of
+// them do from v1alpha4 on, must have it and any ControlPlaneReady condition
+// True; older ones must have reached phase.
+func clusterAPIReadiness(cluster *unstructured.Unstructured, phase string) (bool, string) {
+	conditions := clusterAPIConditions(cluster)
+	if ready, ok := conditions["Ready"]; ok {
+		if ready != "True" {
+			return false, "Cluster api condition Ready is " + ready
+		}
+		if controlPlane, ok := conditions["ControlPlaneReady"]; ok && controlPlane != "True" {
+			return false, "Cluster api condition ControlPlaneReady is " + controlPlane
+		}
+		return true, ""
+	}
+
+	current, _, _ := unstructured.NestedString(cluster.Object, "status", "phase")
+	if current != phase {
+		return false, "Cluster api phase is " + current
+	}
+	return true, ""
+}
+
+// clusterAPIConditions maps the condition types of a Cluster to their status
+func clusterAPIConditions(cluster *unstructured.Unstructured) map[string]string {
+	conditions := map[string]string{}
+	list, _, _ := unstructured.NestedSlice(cluster.Object, "status", "conditions")
+	for _, item := range list {
+		condition, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		conditionType, _ := condition["type"].(string)
+		status, _ := condition["status"].(string)
+		if conditionType != "" {
+			conditions[conditionType] = status
+		}
+	}
+	return conditions
+}
+
+// clusterAPIEndpoint returns spec.controlPlaneEndpoint of a Cluster
+func clusterAPIEndpoint(cluster *unstructured.Unstructured) (string, int32) {
+	host, _, _ := unstructured.NestedString(cluster.Object, "spec", "controlPlaneEndpoint", "host")
+	port, _, _ := unstructured.NestedInt64(cluster.Object, "spec", "controlPlaneEndpoint", "port")
+	return host, int32(port)
+}
+
+// newClusterAPICluster returns an empty Cluster of the given version
+func newClusterAPICluster(version string) *unstructured.Unstructured {
+	cluster := &unstructured.Unstructured{}
+	cluster.SetGroupVersionKind(schema.GroupVersionKind{Group: ClusterAPIGroup, Version: version, Kind: "Cluster"})
+	return cluster
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestDetectClusterAPIVersion(t *testing.T) {
+	served := func(groupVersions ...string) *fakediscovery.FakeDiscovery {
+		fake := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}}
+		for _, groupVersion := range groupVersions {
+			fake.Resources = append(fake.Resources, &metav1.APIResourceList{
+				GroupVersion: groupVersion,
+				APIResources: []metav1.APIResource{{Name: "clusters", Kind: "Cluster"}},
+			})
+		}
+		return fake
+	}
+
+	for expected, client := range map[string]*fakediscovery.FakeDiscovery{
+		"v1alpha3": served("cluster.x-k8s.io/v1alpha2", "cluster.x-k8s.io/v1alpha3"),
+		"v1alpha4": served("cluster.x-k8s.io/v1alpha3", "cluster.x-k8s.io/v1alpha4"),
+		"v1beta1":  served("cluster.x-k8s.io/v1alpha4", "cluster.x-k8s.io/v1beta1"),
+	} {
+		if version, err := DetectClusterAPIVersion(client); err != nil || version != expected {
+			t.Errorf("expected %s, got %s, %v", expected, version, err)
+		}
+	}
+
+	if _, err := DetectClusterAPIVersion(served("cluster.x-k8s.io/v1alpha2", "other.x-k8s.io/v1beta1")); err == nil {
+		t.Error("expected an error without a supported version")
+	}
+}
+
+func TestClusterAPIReadiness(t *testing.T) {
+	cluster := func(phase string, conditions ...map[string]interface{}) *unstructured.Unstructured {
+		status := map[string]interface{}{"phase": phase}
+		if len(conditions) > 0 {
+			list := []interface{}{}
+			for _, condition := range conditions {
+				list = append(list, condition)
+			}
+			status["conditions"] = list
+		}
+		return &unstructured.Unstructured{Object: map[string]interface{}{"status": status}}
+	}
+	condition := func(conditionType, status string) map[string]interface{} {
+		return map[string]interface{}{"type": conditionType, "status": status}
+	}
+
+	for name, tc := range map[string]struct {
+		cluster *unstructured.Unstructured
+		ready   bool
+	}{
+		"phase reached":            {cluster("Provisioned"), true},
+		"phase not reached":        {cluster("Provisioning"), false},
+		"ready":                    {cluster("Provisioned", condition("Ready", "True"), condition("ControlPlaneReady", "True")), true},
+		"ready in another phase":   {cluster("Provisioning", condition("Ready", "True")), true},
+		"not ready":                {cluster("Provisioned", condition("Ready", "False")), false},
+		"control plane not ready":  {cluster("Provisioned", condition("Ready", "True"), condition("ControlPlaneReady", "Unknown")), false},
+		"conditions without Ready": {cluster("Provisioning", condition("InfrastructureReady", "True")), false},
+	} {
+		if ready, reason := clusterAPIReadiness(tc.cluster, "Provisioned"); ready != tc.ready {
+			t.Errorf("%s: expected ready %v, got %v (%s)", name, tc.ready, ready, reason)
+		}
+	}
+}
@@ -18,62 +18,233 @@ package controllers
 
 import (
 	"context"
+	"net"
+	"reflect"
+	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
 	ctrl "sigs.k8s.io/controller-runtime"
 
-	clusterregistryv1alpha1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1alpha1"
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
 )
 
+// defaultHeartbeatPeriod is how often a registered cluster is probed by default
+const defaultHeartbeatPeriod = time.Minute
+
 // ClusterReconciler reconciles a Cluster object
 type ClusterReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// HeartbeatPeriod is how often each registered cluster is probed
+	HeartbeatPeriod time.Duration
+	// ProbeTimeout bounds a single probe
+	ProbeTimeout time.Duration
+	// ClientIP picks the server endpoint clusters are probed on, the first
+	// one when unset
+	ClientIP net.IP
+	// InventoryPeriod is how often the inventory of each reachable cluster
+	// is refreshed
+	InventoryPeriod time.Duration
+	// CertificateExpiryWindow is how long before a certificate expires the
+	// CertificateExpiring condition turns True
+	CertificateExpiryWindow time.Duration
+	// Recorder reports health and certificate transitions as Events on the
+	// registry Clusters
+	Recorder record.EventRecorder
+
+	// newClient connects to a member cluster, replaced in tests
+	newClient func(*rest.Config) (kubernetes.Interface, error)
 }
 
 // +kubebuilder:rbac:groups=clusterregistry.k8s.io,resources=clusters,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=clusterregistry.k8s.io,resources=clusters/status,verbs=get;update;patch
 
+// Reconcile probes the registered cluster and records the result in the
+// ClusterOK condition, records when its certificates expire, refreshes the
+// inventory of a reachable cluster when due, then requeues for the next
+// heartbeat. It also holds a deleted registry Cluster until its pre-delete
+// hooks are done.
 func (r *ClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
-	_ = r.Log.WithValues("cluster-registry", req.NamespacedName)
-	cluster := &clusterregistryv1alpha1.Cluster{}
-	_ = r.Client.Get(ctx, req.NamespacedName,cluster)//; err != nil{
-		//log.Error(err, "unable fetch Cluster-Registry")
-	//}
+	log := r.Log.WithValues("cluster-registry", req.NamespacedName)
+	cluster := &clusterregistryv1beta1.Cluster{}
+	if err := r.Client.Get(ctx, req.NamespacedName, cluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			forgetClusterMetrics(req.Namespace, req.Name)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if cluster.DeletionTimestamp != nil {
+		return ctrl.Result{}, r.reconcileDelete(ctx, cluster)
+	}
+	if !containsString(cluster.Finalizers, clusterregistryv1beta1.ClusterFinalizer) {
+		cluster.Finalizers = append(cluster.Finalizers, clusterregistryv1beta1.ClusterFinalizer)
+		if err := r.Update(ctx, cluster); err != nil {
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+	}
+
+	if c := cluster.Status.GetCondition(clusterregistryv1beta1.Decommissioned); c != nil && c.Status == corev1.ConditionTrue {
+		log.V(1).Info("cluster decommissioned, not probing")
+		return ctrl.Result{}, nil
+	}
+
+	// expired certificates fail the probe, so they are checked either way
+	if err := r.checkCertificates(ctx, cluster); err != nil {
+		log.Error(err, "unable check cluster certificates")
+	}
+
+	condition := clusterregistryv1beta1.Condition{
+		Type:               clusterregistryv1beta1.ClusterOK,
+		Status:             corev1.ConditionTrue,
+		ObservedGeneration: cluster.Generation,
+		Reason:             ReasonHealthCheckSucceeded,
+		Message:            "API server is ready",
+	}
+	config, err := restConfigForCluster(cluster, r.ClientIP, r.ProbeTimeout)
+	if err == nil {
+		start := time.Now()
+		err = probeCluster(r.probeConfig(ctx, log, cluster, config))
+		probeDuration.WithLabelValues(cluster.Namespace, cluster.Name).Observe(time.Since(start).Seconds())
+		if err != nil {
+			probeFailures.WithLabelValues(cluster.Namespace, cluster.Name).Inc()
+		}
+	}
+	switch {
+	case err == errNoServerEndpoint:
+		condition.Status = corev1.ConditionUnknown
+		condition.Reason = ReasonNoServerEndpoint
+		condition.Message = err.Error()
+	case err != nil:
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = ReasonHealthCheckFailed
+		condition.Message = err.Error()
+	}
+	log.V(1).Info("probed cluster", "status", condition.Status, "reason", condition.Reason)
+
+	// a failed collection keeps the previous inventory until the next heartbeat
+	if condition.Status == corev1.ConditionTrue && inventoryDue(cluster, r.InventoryPeriod) {
+		if err := r.refreshInventory(ctx, cluster, config); err != nil {
+			log.Error(err, "unable collect cluster inventory")
+		}
+	}
+
+	if previous := cluster.Status.GetCondition(clusterregistryv1beta1.ClusterOK); previous == nil || previous.Status != condition.Status {
+		eventType := corev1.EventTypeNormal
+		if condition.Status != corev1.ConditionTrue {
+			eventType = corev1.EventTypeWarning
+		}
+		r.Recorder.Event(cluster, eventType, condition.Reason, condition.Message)
+	}
+	probed := metav1.Now()
+	cluster.Status.LastProbeTime = &probed
+	cluster.Status.SetCondition(condition)
+	if err := r.Status().Update(ctx, cluster); err != nil {
+		log.Error(err, "unable update Cluster-Registry status")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: r.HeartbeatPeriod}, nil
 }
 
-func (r *ClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+// Remove the finalizer of a deleted registry Cluster once no pre-delete hook is left
+func (r *ClusterReconciler) reconcileDelete(ctx context.Context, cluster *clusterregistryv1beta1.Cluster) error {
+	if !containsString(cluster.Finalizers, clusterregistryv1beta1.ClusterFinalizer) {
+		return nil
+	}
+	if hooks := pendingHooks(cluster); len(hooks) > 0 {
+		// removing a hook annotation triggers the next reconcile
+		r.Log.Info("Waiting for pre-delete hooks", "cluster-registry", cluster.Name, "hooks", hooks)
+		return nil
+	}
+	cluster.Finalizers = removeString(cluster.Finalizers, clusterregistryv1beta1.ClusterFinalizer)
+	return client.IgnoreNotFound(r.Update(ctx, cluster))
+}
+
+// Authenticate the probe with the controller credential of the cluster if it
+// has one, else probe anonymously
+func (r *ClusterReconciler) probeConfig(ctx context.Context, log logr.Logger, cluster *clusterregistryv1beta1.Cluster, config *rest.Config) *rest.Config {
+	kubeconfig, err := ControllerKubeconfig(ctx, r.Client, cluster)
+	if err != nil {
+		log.Error(err, "unable load controller credential, probing anonymously")
+		return config
+	}
+	if kubeconfig == nil {
+		return config
+	}
+	authenticated := rest.CopyConfig(config)
+	if err := withCredential(authenticated, kubeconfig); err != nil {
+		log.Error(err, "unable use controller credential, probing anonymously")
+		return config
+	}
+	return authenticated
+}
+
+func (r *ClusterReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	if r.HeartbeatPeriod <= 0 {
+		r.HeartbeatPeriod = defaultHeartbeatPeriod
+	}
+	if r.ProbeTimeout <= 0 {
+		r.ProbeTimeout = defaultProbeTimeout
+	}
+	if r.InventoryPeriod <= 0 {
+		r.InventoryPeriod = defaultInventoryPeriod
+	}
+	if r.CertificateExpiryWindow <= 0 {
+		r.CertificateExpiryWindow = defaultCertificateExpiryWindow
+	}
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor("cluster-registry-controller")
+	}
+
+	// heartbeats only touch status, so they must not retrigger reconciliation
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&clusterregistryv1alpha1.Cluster{}).
+		For(&clusterregistryv1beta1.Cluster{}).
+		WithEventFilter(predicate.Funcs{UpdateFunc: specOrMetadataChanged}).
+		WithOptions(options).
 		Complete(r)
 }
 
-// Create cluster registry resource
-func CreateClusterRegistry(name string, namespace string,cluster *clusterv1.Cluster,ca []byte,server string) *clusterregistryv1alpha1.Cluster{
-	cr := &clusterregistryv1alpha1.Cluster{
+// specOrMetadataChanged filters out status only updates, but keeps the
+// annotation and finalizer changes that drive deletion
+func specOrMetadataChanged(e event.UpdateEvent) bool {
+	if e.MetaOld == nil || e.MetaNew == nil {
+		return true
+	}
+	return e.MetaOld.GetGeneration() != e.MetaNew.GetGeneration() ||
+		!reflect.DeepEqual(e.MetaOld.GetAnnotations(), e.MetaNew.GetAnnotations()) ||
+		!reflect.DeepEqual(e.MetaOld.GetFinalizers(), e.MetaNew.GetFinalizers())
+}
+
+// Create cluster registry resource, owned by whichever source object describes it
+func CreateClusterRegistry(name string, namespace string, ca []byte, server string) *clusterregistryv1beta1.Cluster {
+	cr := &clusterregistryv1beta1.Cluster{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:         name,
-			Namespace:    namespace,
-			OwnerReferences: []metav1.OwnerReference{
-				*metav1.NewControllerRef(cluster.GetObjectMeta(),cluster.GroupVersionKind()),
-			},
+			Name:      name,
+			Namespace: namespace,
 		},
-		Spec: clusterregistryv1alpha1.ClusterSpec{
-			KubernetesAPIEndpoints:  clusterregistryv1alpha1.KubernetesAPIEndpoints{
-				ServerEndpoints:    []clusterregistryv1alpha1.ServerAddressByClientCIDR{
+		Spec: clusterregistryv1beta1.ClusterSpec{
+			KubernetesAPIEndpoints: clusterregistryv1beta1.KubernetesAPIEndpoints{
+				ServerEndpoints: []clusterregistryv1beta1.ServerAddressByClientCIDR{
 					{
-						ClientCIDR:    "0.0.0.0/0",
+						// the kubeconfig server, unless endpoint rules say otherwise
+						ClientCIDR:    clusterregistryv1beta1.DefaultClientCIDR,
 						ServerAddress: server,
-
 					},
 				},
 				CABundle: ca,
@@ -0,0 +1,136 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// eventReasons returns the type and reason of the Events recorded on the
+// named object in the default namespace, such as "Warning/HealthCheckFailed"
+func eventReasons(name string) func() []string {
+	return func() []string {
+		events := &corev1.EventList{}
+		if err := k8sClient.List(context.Background(), events, client.InNamespace("default")); err != nil {
+			return nil
+		}
+		var reasons []string
+		for _, event := range events.Items {
+			if event.InvolvedObject.Name == name {
+				reasons = append(reasons, event.Type+"/"+event.Reason)
+			}
+		}
+		return reasons
+	}
+}
+
+var _ = Describe("ClusterReconciler", func() {
+	const timeout = 10 * time.Second
+
+	ctx := context.Background()
+
+	// okCondition returns the ClusterOK condition recorded for the named registry Cluster
+	okCondition := func(name string) func() *clusterregistryv1beta1.Condition {
+		return func() *clusterregistryv1beta1.Condition {
+			cluster := &clusterregistryv1beta1.Cluster{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, cluster); err != nil {
+				return nil
+			}
+			return cluster.Status.GetCondition(clusterregistryv1beta1.ClusterOK)
+		}
+	}
+	// lastProbe returns when the named registry Cluster was last probed
+	lastProbe := func(name string) *metav1.Time {
+		cluster := &clusterregistryv1beta1.Cluster{}
+		if err := k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, cluster); err != nil {
+			return nil
+		}
+		return cluster.Status.LastProbeTime
+	}
+	status := func(c *clusterregistryv1beta1.Condition) corev1.ConditionStatus {
+		if c == nil {
+			return ""
+		}
+		return c.Status
+	}
+
+	newCluster := func(name, server string) *clusterregistryv1beta1.Cluster {
+		cluster := &clusterregistryv1beta1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		}
+		if server != "" {
+			cluster.Spec.KubernetesAPIEndpoints.ServerEndpoints = []clusterregistryv1beta1.ServerAddressByClientCIDR{
+				{ClientCIDR: "0.0.0.0/0", ServerAddress: server},
+			}
+		}
+		return cluster
+	}
+
+	It("reports a reachable cluster as OK", func() {
+		// the test API server itself stands in for the member cluster
+		Expect(k8sClient.Create(ctx, newCluster("healthy", cfg.Host))).To(Succeed())
+		Eventually(func() corev1.ConditionStatus { return status(okCondition("healthy")()) }, timeout).
+			Should(Equal(corev1.ConditionTrue))
+
+		first := okCondition("healthy")()
+		Expect(first.Reason).To(Equal(ReasonHealthCheckSucceeded))
+		firstProbe := lastProbe("healthy")
+		Expect(firstProbe).NotTo(BeNil())
+
+		By("refreshing the probe time without moving the transition time")
+		Eventually(func() bool {
+			probed := lastProbe("healthy")
+			return probed != nil && probed.After(firstProbe.Time)
+		}, timeout).Should(BeTrue())
+		Expect(okCondition("healthy")().LastTransitionTime).To(Equal(first.LastTransitionTime))
+
+		By("collecting the server version without a controller credential")
+		cluster := &clusterregistryv1beta1.Cluster{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "healthy", Namespace: "default"}, cluster)).To(Succeed())
+		Expect(cluster.Status.Version).NotTo(BeEmpty())
+		Expect(cluster.Status.LastInventoryTime).NotTo(BeNil())
+		Expect(cluster.Status.Nodes).To(BeNil())
+	})
+
+	It("reports an unreachable cluster as not OK", func() {
+		Expect(k8sClient.Create(ctx, newCluster("unreachable", "https://127.0.0.1:1"))).To(Succeed())
+		Eventually(func() corev1.ConditionStatus { return status(okCondition("unreachable")()) }, timeout).
+			Should(Equal(corev1.ConditionFalse))
+		Expect(okCondition("unreachable")().Reason).To(Equal(ReasonHealthCheckFailed))
+
+		By("recording the transition as a Warning Event")
+		Eventually(eventReasons("unreachable"), timeout).Should(ContainElement("Warning/" + ReasonHealthCheckFailed))
+	})
+
+	It("reports a cluster without endpoints as unknown", func() {
+		Expect(k8sClient.Create(ctx, newCluster("no-endpoint", ""))).To(Succeed())
+		Eventually(func() corev1.ConditionStatus { return status(okCondition("no-endpoint")()) }, timeout).
+			Should(Equal(corev1.ConditionUnknown))
+		Expect(okCondition("no-endpoint")().Reason).To(Equal(ReasonNoServerEndpoint))
+	})
+})
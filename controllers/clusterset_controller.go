@@ -0,0 +1,158 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// Reasons of the SelectorValid condition of a ClusterSet
+const (
+	ReasonSelectorValid   = "SelectorValid"
+	ReasonSelectorInvalid = "SelectorInvalid"
+)
+
+// ClusterSetReconciler keeps the member clusters and aggregate health of
+// ClusterSets up to date
+type ClusterSetReconciler struct {
+	Client client.Client
+	Log    logr.Logger
+}
+
+// +kubebuilder:rbac:groups=clusterregistry.k8s.io,resources=clustersets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=clusterregistry.k8s.io,resources=clustersets/status,verbs=get;update;patch
+
+func (r *ClusterSetReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("clusterset", req.NamespacedName)
+
+	set := &clusterregistryv1beta1.ClusterSet{}
+	if err := r.Client.Get(ctx, req.NamespacedName, set); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	clusters := &clusterregistryv1beta1.ClusterList{}
+	if err := r.Client.List(ctx, clusters, client.InNamespace(set.Namespace)); err != nil {
+		log.Error(err, "unable list Cluster registries")
+		return ctrl.Result{}, err
+	}
+
+	valid := clusterregistryv1beta1.Condition{
+		Type:               clusterregistryv1beta1.ClusterSetSelectorValid,
+		Status:             corev1.ConditionTrue,
+		ObservedGeneration: set.Generation,
+		Reason:             ReasonSelectorValid,
+	}
+	status, err := clusterSetStatus(set, clusters.Items)
+	if err != nil {
+		// an invalid selector is only fixed by an update of the set, keep the
+		// members last observed until then
+		log.Info("ClusterSet selector invalid", "error", err.Error())
+		status = *set.Status.DeepCopy()
+		status.ObservedGeneration = set.Generation
+		valid.Status, valid.Reason, valid.Message = corev1.ConditionFalse, ReasonSelectorInvalid, err.Error()
+	} else {
+		status.Conditions = set.Status.DeepCopy().Conditions
+	}
+	status.SetCondition(valid)
+	if apiequality.Semantic.DeepEqual(set.Status, status) {
+		return ctrl.Result{}, nil
+	}
+	log.Info("Update ClusterSet members", "ready", status.Ready, "total", status.Total, "selectorValid", valid.Status)
+	set.Status = status
+	if err := r.Client.Status().Update(ctx, set); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	return ctrl.Result{}, nil
+}
+
+// The status of a ClusterSet: the clusters its selector matches or it lists
+// by name, and whether their OK condition is True
+func clusterSetStatus(set *clusterregistryv1beta1.ClusterSet, clusters []clusterregistryv1beta1.Cluster) (clusterregistryv1beta1.ClusterSetStatus, error) {
+	status := clusterregistryv1beta1.ClusterSetStatus{ObservedGeneration: set.Generation}
+	selector := labels.Nothing()
+	if set.Spec.Selector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(set.Spec.Selector); err != nil {
+			return status, err
+		}
+	}
+	listed := map[string]bool{}
+	for _, name := range set.Spec.Clusters {
+		listed[name] = true
+	}
+
+	for i := range clusters {
+		cluster := &clusters[i]
+		if !listed[cluster.Name] && !selector.Matches(labels.Set(cluster.Labels)) {
+			continue
+		}
+		delete(listed, cluster.Name)
+		ok := cluster.Status.GetCondition(clusterregistryv1beta1.ClusterOK)
+		member := clusterregistryv1beta1.ClusterSetMember{Name: cluster.Name, Ready: ok != nil && ok.Status == corev1.ConditionTrue}
+		status.Clusters = append(status.Clusters, member)
+		if member.Ready {
+			status.Ready++
+		}
+	}
+	sort.Slice(status.Clusters, func(i, j int) bool { return status.Clusters[i].Name < status.Clusters[j].Name })
+	status.Total = int32(len(status.Clusters))
+	for name := range listed {
+		status.Missing = append(status.Missing, name)
+	}
+	sort.Strings(status.Missing)
+	return status, nil
+}
+
+// Map a registry Cluster to the ClusterSets of its namespace, any of which it
+// may join or leave
+func (r *ClusterSetReconciler) clusterToSets(o handler.MapObject) []ctrl.Request {
+	sets := &clusterregistryv1beta1.ClusterSetList{}
+	if err := r.Client.List(context.Background(), sets, client.InNamespace(o.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "unable list ClusterSets", "namespace", o.Meta.GetNamespace())
+		return nil
+	}
+	requests := make([]ctrl.Request, 0, len(sets.Items))
+	for _, set := range sets.Items {
+		requests = append(requests, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: set.Namespace, Name: set.Name}})
+	}
+	return requests
+}
+
+func (r *ClusterSetReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&clusterregistryv1beta1.ClusterSet{}).
+		Watches(&source.Kind{Type: &clusterregistryv1beta1.Cluster{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.clusterToSets),
+		}).
+		WithOptions(options).
+		Complete(r)
+}
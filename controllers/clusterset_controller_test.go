@@ -0,0 +1,173 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// newSetMember returns a registry Cluster of the clusters namespace with the
+// given labels and OK condition, none when empty
+func newSetMember(name string, labels map[string]string, ok corev1.ConditionStatus) *clusterregistryv1beta1.Cluster {
+	cluster := &clusterregistryv1beta1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "clusters", Labels: labels},
+	}
+	if ok != "" {
+		cluster.Status.SetCondition(clusterregistryv1beta1.Condition{Type: clusterregistryv1beta1.ClusterOK, Status: ok, Reason: "Probed"})
+	}
+	return cluster
+}
+
+func TestClusterSetStatus(t *testing.T) {
+	prodEU := map[string]string{clusterregistryv1beta1.EnvironmentLabel: "prod", clusterregistryv1beta1.RegionLabel: "eu-west-1"}
+	clusters := []clusterregistryv1beta1.Cluster{
+		*newSetMember("prod-eu-2", prodEU, corev1.ConditionFalse),
+		*newSetMember("prod-eu-1", prodEU, corev1.ConditionTrue),
+		*newSetMember("prod-us-1", map[string]string{clusterregistryv1beta1.EnvironmentLabel: "prod"}, corev1.ConditionTrue),
+		*newSetMember("staging", nil, ""),
+	}
+
+	for name, tc := range map[string]struct {
+		spec     clusterregistryv1beta1.ClusterSetSpec
+		expected clusterregistryv1beta1.ClusterSetStatus
+	}{
+		"no selector": {},
+		"selector and list": {
+			spec: clusterregistryv1beta1.ClusterSetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: prodEU},
+				Clusters: []string{"staging", "prod-eu-1", "gone"},
+			},
+			expected: clusterregistryv1beta1.ClusterSetStatus{
+				Clusters: []clusterregistryv1beta1.ClusterSetMember{
+					{Name: "prod-eu-1", Ready: true}, {Name: "prod-eu-2"}, {Name: "staging"},
+				},
+				Missing: []string{"gone"},
+				Ready:   1,
+				Total:   3,
+			},
+		},
+		"empty selector": {
+			spec: clusterregistryv1beta1.ClusterSetSpec{Selector: &metav1.LabelSelector{}},
+			expected: clusterregistryv1beta1.ClusterSetStatus{
+				Clusters: []clusterregistryv1beta1.ClusterSetMember{
+					{Name: "prod-eu-1", Ready: true}, {Name: "prod-eu-2"}, {Name: "prod-us-1", Ready: true}, {Name: "staging"},
+				},
+				Ready: 2,
+				Total: 4,
+			},
+		},
+	} {
+		set := &clusterregistryv1beta1.ClusterSet{Spec: tc.spec}
+		status, err := clusterSetStatus(set, clusters)
+		if err != nil || !reflect.DeepEqual(status, tc.expected) {
+			t.Errorf("%s: expected %+v, got %+v, %v", name, tc.expected, status, err)
+		}
+	}
+
+	set := &clusterregistryv1beta1.ClusterSet{Spec: clusterregistryv1beta1.ClusterSetSpec{Selector: &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "env", Operator: "Equals"}},
+	}}}
+	if _, err := clusterSetStatus(set, clusters); err == nil {
+		t.Error("expected an error for an invalid selector")
+	}
+}
+
+func TestClusterSetReconciler(t *testing.T) {
+	ctx := context.Background()
+	setKey := types.NamespacedName{Namespace: "clusters", Name: "prod"}
+	prod := map[string]string{clusterregistryv1beta1.EnvironmentLabel: "prod"}
+	member := newSetMember("member", prod, corev1.ConditionTrue)
+	hub := fake.NewFakeClientWithScheme(newExportScheme(t), member, &clusterregistryv1beta1.ClusterSet{
+		ObjectMeta: metav1.ObjectMeta{Name: setKey.Name, Namespace: setKey.Namespace, Generation: 1},
+		Spec:       clusterregistryv1beta1.ClusterSetSpec{Selector: &metav1.LabelSelector{MatchLabels: prod}},
+	})
+	r := &ClusterSetReconciler{Client: hub, Log: ctrl.Log}
+
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: setKey}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	set := &clusterregistryv1beta1.ClusterSet{}
+	if err := hub.Get(ctx, setKey, set); err != nil {
+		t.Fatal(err)
+	}
+	if set.Status.ObservedGeneration != 1 || set.Status.Ready != 1 || set.Status.Total != 1 || set.Status.Clusters[0].Name != "member" {
+		t.Errorf("expected the member to be ready, got %+v", set.Status)
+	}
+	if valid := set.Status.GetCondition(clusterregistryv1beta1.ClusterSetSelectorValid); valid == nil || valid.Status != corev1.ConditionTrue {
+		t.Errorf("expected the selector to be valid, got %+v", valid)
+	}
+
+	// an invalid selector is reported and the members last observed are kept
+	set.Generation = 2
+	set.Spec.Selector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "env", Operator: "Equals"}}}
+	if err := hub.Update(ctx, set); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: setKey}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	set = &clusterregistryv1beta1.ClusterSet{}
+	if err := hub.Get(ctx, setKey, set); err != nil {
+		t.Fatal(err)
+	}
+	if set.Status.ObservedGeneration != 2 || set.Status.Total != 1 {
+		t.Errorf("expected generation 2 observed with the member kept, got %+v", set.Status)
+	}
+	if valid := set.Status.GetCondition(clusterregistryv1beta1.ClusterSetSelectorValid); valid == nil || valid.Status != corev1.ConditionFalse || valid.Reason != ReasonSelectorInvalid || valid.ObservedGeneration != 2 {
+		t.Errorf("expected the selector to be invalid, got %+v", valid)
+	}
+	set.Generation = 3
+	set.Spec.Selector = &metav1.LabelSelector{MatchLabels: prod}
+	if err := hub.Update(ctx, set); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := r.clusterToSets(handler.MapObject{Meta: member, Object: member})
+	if len(requests) != 1 || requests[0].NamespacedName != setKey {
+		t.Errorf("expected the registry Cluster to map to %s, got %v", setKey, requests)
+	}
+
+	// a cluster leaving the set is removed from its status
+	member.Labels = nil
+	if err := hub.Update(ctx, member); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: setKey}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	set = &clusterregistryv1beta1.ClusterSet{}
+	if err := hub.Get(ctx, setKey, set); err != nil {
+		t.Fatal(err)
+	}
+	if set.Status.Total != 0 || len(set.Status.Clusters) != 0 {
+		t.Errorf("expected no members, got %+v", set.Status)
+	}
+	if valid := set.Status.GetCondition(clusterregistryv1beta1.ClusterSetSelectorValid); valid == nil || valid.Status != corev1.ConditionTrue || valid.ObservedGeneration != 3 {
+		t.Errorf("expected the fixed selector to be valid, got %+v", valid)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// credentialSuffix names the controller credential Secret of a registry Cluster
+const credentialSuffix = "-credentials"
+
+// Copy the controller credential of a registry Cluster into the Secret its
+// Spec.AuthInfo.Controller references, owned by the registry Cluster so it
+// goes away with it
+func (r *SourceReconciler) syncCredential(ctx context.Context, obj runtime.Object, clusterreg *clusterregistryv1beta1.Cluster, source CredentialSource) error {
+	ref := clusterreg.Spec.AuthInfo.Controller
+	log := r.Log.WithValues("source", r.Source.Name(), "ClusterRegistry", clusterreg.Name, "secret", ref.Name)
+
+	kubeconfig, err := source.Credential(ctx, obj, clusterreg)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, secret); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ref.Name,
+				Namespace: ref.Namespace,
+				Labels:    map[string]string{clusterregistryv1beta1.SourceLabel: r.Source.Name()},
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{clusterregistryv1beta1.ControllerKubeconfigKey: kubeconfig},
+		}
+		if err := controllerutil.SetControllerReference(clusterreg, secret, r.Scheme); err != nil {
+			return err
+		}
+		log.Info("Create Cluster registry credential")
+		return r.Client.Create(ctx, secret)
+	}
+
+	if bytes.Equal(secret.Data[clusterregistryv1beta1.ControllerKubeconfigKey], kubeconfig) {
+		return nil
+	}
+	patch := client.MergeFrom(secret.DeepCopy())
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[clusterregistryv1beta1.ControllerKubeconfigKey] = kubeconfig
+	log.Info("Update Cluster registry credential")
+	return r.Client.Patch(ctx, secret, patch)
+}
+
+// ControllerKubeconfig reads the controller kubeconfig of a registry Cluster
+// from the Secret its Spec.AuthInfo.Controller references, nil without a reference
+func ControllerKubeconfig(ctx context.Context, c client.Client, clusterreg *clusterregistryv1beta1.Cluster) ([]byte, error) {
+	key, err := controllerCredentialKey(clusterreg)
+	if key == nil || err != nil {
+		return nil, err
+	}
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, *key, secret); err != nil {
+		return nil, err
+	}
+	kubeconfig := secret.Data[clusterregistryv1beta1.ControllerKubeconfigKey]
+	if len(kubeconfig) == 0 {
+		return nil, fmt.Errorf("controller credential %s has no %s key", key, clusterregistryv1beta1.ControllerKubeconfigKey)
+	}
+	return kubeconfig, nil
+}
+
+// The Secret Spec.AuthInfo.Controller of a registry Cluster references, nil
+// without a reference. Only Secrets of the cluster's own namespace are
+// accepted, lest a registry Cluster borrow the credential of another tenant.
+func controllerCredentialKey(clusterreg *clusterregistryv1beta1.Cluster) (*client.ObjectKey, error) {
+	ref := clusterreg.Spec.AuthInfo.Controller
+	if ref == nil {
+		return nil, nil
+	}
+	if ref.Namespace != "" && ref.Namespace != clusterreg.Namespace {
+		return nil, fmt.Errorf("controller credential %s/%s of %s/%s is not in the namespace of the cluster",
+			ref.Namespace, ref.Name, clusterreg.Namespace, clusterreg.Name)
+	}
+	return &client.ObjectKey{Namespace: clusterreg.Namespace, Name: ref.Name}, nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+func TestControllerKubeconfig(t *testing.T) {
+	ctx := context.Background()
+	kubeconfig := controllerKubeconfigData(t, &clientcmdapi.AuthInfo{Token: "token"})
+	hub := fake.NewFakeClientWithScheme(newExportScheme(t),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "member-credentials", Namespace: "clusters"},
+			Data:       map[string][]byte{clusterregistryv1beta1.ControllerKubeconfigKey: kubeconfig},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "admin-credentials", Namespace: "other-tenant"},
+			Data:       map[string][]byte{clusterregistryv1beta1.ControllerKubeconfigKey: kubeconfig},
+		},
+	)
+	cluster := exportedCluster()
+
+	for namespace, allowed := range map[string]bool{"": true, "clusters": true, "other-tenant": false} {
+		cluster.Spec.AuthInfo.Controller = &clusterregistryv1beta1.ObjectReference{Kind: "Secret", Namespace: namespace, Name: "member-credentials"}
+		if namespace == "other-tenant" {
+			cluster.Spec.AuthInfo.Controller.Name = "admin-credentials"
+		}
+		data, err := ControllerKubeconfig(ctx, hub, cluster)
+		if allowed && (err != nil || string(data) != string(kubeconfig)) {
+			t.Errorf("%q: expected the credential, got %v", namespace, err)
+		}
+		if !allowed && (err == nil || data != nil) {
+			t.Errorf("%q: expected a credential of another namespace to be refused", namespace)
+		}
+	}
+
+	cluster.Spec.AuthInfo.Controller = nil
+	if data, err := ControllerKubeconfig(ctx, hub, cluster); data != nil || err != nil {
+		t.Errorf("expected no credential without a reference, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// ParseDeletionPolicy checks a deletion policy given on the command line or in an annotation
+func ParseDeletionPolicy(s string) (clusterregistryv1beta1.DeletionPolicy, error) {
+	switch policy := clusterregistryv1beta1.DeletionPolicy(s); policy {
+	case clusterregistryv1beta1.DeletionPolicyCascade,
+		clusterregistryv1beta1.DeletionPolicyOrphan,
+		clusterregistryv1beta1.DeletionPolicyRetain:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown deletion policy %q, must be one of %s, %s or %s", s,
+		clusterregistryv1beta1.DeletionPolicyCascade,
+		clusterregistryv1beta1.DeletionPolicyOrphan,
+		clusterregistryv1beta1.DeletionPolicyRetain)
+}
+
+// The deletion policy of a registry Cluster: its annotation, else the reconciler default
+func (r *SourceReconciler) policyFor(clusterreg *clusterregistryv1beta1.Cluster) clusterregistryv1beta1.DeletionPolicy {
+	if value, ok := clusterreg.Annotations[clusterregistryv1beta1.DeletionPolicyAnnotation]; ok {
+		if policy, err := ParseDeletionPolicy(value); err == nil {
+			return policy
+		}
+		r.Log.Info("ignoring invalid deletion policy", "ClusterRegistry", clusterreg.Name, "policy", value)
+	}
+	if r.DeletionPolicy == "" {
+		return clusterregistryv1beta1.DeletionPolicyCascade
+	}
+	return r.DeletionPolicy
+}
+
+// Apply the deletion policy to a registry Cluster its source no longer describes
+func (r *SourceReconciler) release(ctx context.Context, owner metav1.Object, clusterreg *clusterregistryv1beta1.Cluster) error {
+	log := r.Log.WithValues("source", r.Source.Name(), "ClusterRegistry", clusterreg.Name)
+	policy := r.policyFor(clusterreg)
+
+	if policy == clusterregistryv1beta1.DeletionPolicyCascade {
+		if clusterreg.DeletionTimestamp != nil {
+			return nil
+		}
+		log.Info("Delete Cluster registry")
+		return client.IgnoreNotFound(r.Client.Delete(ctx, clusterreg))
+	}
+
+	log.Info("Release Cluster registry", "policy", policy)
+	patch := client.MergeFrom(clusterreg.DeepCopy())
+	refs := clusterreg.OwnerReferences[:0]
+	for _, ref := range clusterreg.OwnerReferences {
+		if ref.UID != owner.GetUID() {
+			refs = append(refs, ref)
+		}
+	}
+	clusterreg.OwnerReferences = refs
+	if err := r.Client.Patch(ctx, clusterreg, patch); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if policy == clusterregistryv1beta1.DeletionPolicyRetain {
+		message := fmt.Sprintf("%s source %s no longer describes this cluster", r.Source.Name(), owner.GetName())
+		return client.IgnoreNotFound(r.setCondition(ctx, clusterreg, clusterregistryv1beta1.Decommissioned, corev1.ConditionTrue, "SourceRemoved", message))
+	}
+	return nil
+}
+
+// Add the source finalizer, so the deletion policy can be applied before the source goes away
+func (r *SourceReconciler) addFinalizer(ctx context.Context, obj runtime.Object, owner metav1.Object) error {
+	if containsString(owner.GetFinalizers(), clusterregistryv1beta1.SourceFinalizer) {
+		return nil
+	}
+	patch := client.MergeFrom(obj.DeepCopyObject())
+	owner.SetFinalizers(append(owner.GetFinalizers(), clusterregistryv1beta1.SourceFinalizer))
+	return r.Client.Patch(ctx, obj, patch)
+}
+
+// Remove the source finalizer once the registry Clusters of the source are released
+func (r *SourceReconciler) removeFinalizer(ctx context.Context, obj runtime.Object, owner metav1.Object) error {
+	if !containsString(owner.GetFinalizers(), clusterregistryv1beta1.SourceFinalizer) {
+		return nil
+	}
+	patch := client.MergeFrom(obj.DeepCopyObject())
+	owner.SetFinalizers(removeString(owner.GetFinalizers(), clusterregistryv1beta1.SourceFinalizer))
+	return client.IgnoreNotFound(r.Client.Patch(ctx, obj, patch))
+}
+
+// Release all registry Clusters of a source object being deleted, and let
+// it go once the cascading ones are gone too
+func (r *SourceReconciler) reconcileDelete(ctx context.Context, obj runtime.Object, owner metav1.Object) error {
+	if !containsString(owner.GetFinalizers(), clusterregistryv1beta1.SourceFinalizer) {
+		return nil
+	}
+	if err := r.prune(ctx, owner, nil); err != nil {
+		return err
+	}
+	remaining, err := r.owned(ctx, owner)
+	if err != nil {
+		return err
+	}
+	if len(remaining) > 0 {
+		// their deletion events requeue the source
+		r.Log.Info("Waiting for Cluster registry deletion", "source", r.Source.Name(), "remaining", len(remaining))
+		return nil
+	}
+	return r.removeFinalizer(ctx, obj, owner)
+}
+
+// pendingHooks lists the pre-delete hooks still set on a registry Cluster
+func pendingHooks(clusterreg *clusterregistryv1beta1.Cluster) []string {
+	hooks := []string{}
+	for key := range clusterreg.Annotations {
+		if strings.HasPrefix(key, clusterregistryv1beta1.PreDeleteHookAnnotationPrefix) {
+			hooks = append(hooks, strings.TrimPrefix(key, clusterregistryv1beta1.PreDeleteHookAnnotationPrefix))
+		}
+	}
+	sort.Strings(hooks)
+	return hooks
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	result := []string{}
+	for _, item := range list {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+var _ = Describe("Deletion", func() {
+	const timeout = 10 * time.Second
+
+	ctx := context.Background()
+
+	newSource := func(name string) *corev1.Secret {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Labels:    map[string]string{clusterregistryv1beta1.KubeconfigSecretLabel: "true"},
+			},
+			Data: map[string][]byte{"value": newLegacyKubeconfig("prod")},
+		}
+		Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+		return secret
+	}
+	registry := func(name string) func() (*clusterregistryv1beta1.Cluster, error) {
+		return func() (*clusterregistryv1beta1.Cluster, error) {
+			clusterreg := &clusterregistryv1beta1.Cluster{}
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: name + "-admin-prod", Namespace: "default"}, clusterreg)
+			return clusterreg, err
+		}
+	}
+	gone := func(get func() (*clusterregistryv1beta1.Cluster, error)) func() bool {
+		return func() bool {
+			_, err := get()
+			return apierrors.IsNotFound(err)
+		}
+	}
+	sourceGone := func(name string) func() bool {
+		return func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, &corev1.Secret{}))
+		}
+	}
+
+	It("keeps a cascading registry Cluster until its pre-delete hooks are done", func() {
+		secret := newSource("hooked")
+		get := registry("hooked")
+		Eventually(func() []string {
+			clusterreg, err := get()
+			if err != nil {
+				return nil
+			}
+			return clusterreg.Finalizers
+		}, timeout).Should(ContainElement(clusterregistryv1beta1.ClusterFinalizer))
+
+		clusterreg, err := get()
+		Expect(err).ToNot(HaveOccurred())
+		clusterreg.Annotations = map[string]string{clusterregistryv1beta1.PreDeleteHookAnnotationPrefix + "dns": ""}
+		Expect(k8sClient.Update(ctx, clusterreg)).To(Succeed())
+
+		Expect(k8sClient.Delete(ctx, secret)).To(Succeed())
+		Eventually(func() bool {
+			clusterreg, err := get()
+			return err == nil && clusterreg.DeletionTimestamp != nil
+		}, timeout).Should(BeTrue())
+		Consistently(sourceGone("hooked"), 2*time.Second).Should(BeFalse())
+
+		By("completing the hook")
+		clusterreg, err = get()
+		Expect(err).ToNot(HaveOccurred())
+		clusterreg.Annotations = nil
+		Expect(k8sClient.Update(ctx, clusterreg)).To(Succeed())
+		Eventually(gone(get), timeout).Should(BeTrue())
+		Eventually(sourceGone("hooked"), timeout).Should(BeTrue())
+	})
+
+	It("retains a registry Cluster as decommissioned", func() {
+		secret := newSource("retained")
+		get := registry("retained")
+		Eventually(func() error { _, err := get(); return err }, timeout).Should(Succeed())
+
+		clusterreg, err := get()
+		Expect(err).ToNot(HaveOccurred())
+		clusterreg.Annotations = map[string]string{
+			clusterregistryv1beta1.DeletionPolicyAnnotation: string(clusterregistryv1beta1.DeletionPolicyRetain),
+		}
+		Expect(k8sClient.Update(ctx, clusterreg)).To(Succeed())
+
+		Expect(k8sClient.Delete(ctx, secret)).To(Succeed())
+		Eventually(sourceGone("retained"), timeout).Should(BeTrue())
+
+		clusterreg, err = get()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(clusterreg.DeletionTimestamp).To(BeNil())
+		Expect(metav1.GetControllerOf(clusterreg)).To(BeNil())
+		Eventually(func() corev1.ConditionStatus {
+			clusterreg, err := get()
+			if err != nil {
+				return ""
+			}
+			if c := clusterreg.Status.GetCondition(clusterregistryv1beta1.Decommissioned); c != nil {
+				return c.Status
+			}
+			return ""
+		}, timeout).Should(Equal(corev1.ConditionTrue))
+	})
+})
@@ -0,0 +1,191 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+const (
+	// EndpointRulesKey is the ConfigMap key holding the endpoint rules
+	EndpointRulesKey = "rules"
+	// ReasonEndpointRulesInvalid is reported for rules that cannot be parsed or rendered
+	ReasonEndpointRulesInvalid = "EndpointRulesInvalid"
+)
+
+// EndpointRule maps clients in a CIDR to a server address. ServerAddress is
+// a Go template over EndpointTemplateData; a rule rendering to an empty
+// address is skipped, so rules may depend on optional annotations.
+type EndpointRule struct {
+	ClientCIDR    string `json:"clientCIDR"`
+	ServerAddress string `json:"serverAddress"`
+}
+
+// EndpointTemplateData is what the address template of an EndpointRule can refer to
+type EndpointTemplateData struct {
+	// Name and Namespace of the registry Cluster
+	Name      string
+	Namespace string
+	// Labels and Annotations of the source object, e.g. set by a load balancer
+	Labels      map[string]string
+	Annotations map[string]string
+	// KubeconfigServer is the server of the kubeconfig the source resolved
+	KubeconfigServer string
+	// ControlPlaneHost and ControlPlanePort are the Cluster API control
+	// plane endpoint, empty for other sources
+	ControlPlaneHost string
+	ControlPlanePort int32
+}
+
+// EndpointSource is implemented by sources that know more about the API
+// server addresses of a cluster than its kubeconfig.
+type EndpointSource interface {
+	EndpointData(obj runtime.Object, data *EndpointTemplateData)
+}
+
+// ParseEndpointRules reads a YAML list of endpoint rules, checking their
+// CIDRs and templates
+func ParseEndpointRules(data string) ([]EndpointRule, error) {
+	rules := []EndpointRule{}
+	if err := yaml.UnmarshalStrict([]byte(data), &rules); err != nil {
+		return nil, err
+	}
+	for i, rule := range rules {
+		if _, _, err := net.ParseCIDR(rule.ClientCIDR); err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+		if _, err := template.New("").Option("missingkey=zero").Parse(rule.ServerAddress); err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+	}
+	return rules, nil
+}
+
+// Render the server endpoints of the rules, in rule order. The first rule
+// wins for a CIDR given more than once.
+func renderEndpoints(rules []EndpointRule, data *EndpointTemplateData) ([]clusterregistryv1beta1.ServerAddressByClientCIDR, error) {
+	endpoints := []clusterregistryv1beta1.ServerAddressByClientCIDR{}
+	seen := map[string]bool{}
+	for i, rule := range rules {
+		_, cidr, err := net.ParseCIDR(rule.ClientCIDR)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+		tmpl, err := template.New("").Option("missingkey=zero").Parse(rule.ServerAddress)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+		buf := &bytes.Buffer{}
+		if err := tmpl.Execute(buf, data); err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+		address := strings.TrimSpace(buf.String())
+		if address == "" || seen[cidr.String()] {
+			continue
+		}
+		seen[cidr.String()] = true
+		endpoints = append(endpoints, clusterregistryv1beta1.ServerAddressByClientCIDR{
+			ClientCIDR:    cidr.String(),
+			ServerAddress: address,
+		})
+	}
+	return endpoints, nil
+}
+
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+
+// The endpoint rules for a source object: its annotation, else the
+// configured ConfigMap, else none
+func (r *SourceReconciler) endpointRules(ctx context.Context, owner metav1.Object) ([]EndpointRule, error) {
+	if value, ok := owner.GetAnnotations()[clusterregistryv1beta1.ServerEndpointsAnnotation]; ok {
+		rules, err := ParseEndpointRules(value)
+		if err != nil {
+			return nil, &InvalidSourceError{
+				Reason: ReasonEndpointRulesInvalid,
+				Err:    fmt.Errorf("annotation %s: %v", clusterregistryv1beta1.ServerEndpointsAnnotation, err),
+			}
+		}
+		return rules, nil
+	}
+	if r.EndpointRules.Name == "" {
+		return nil, nil
+	}
+
+	configMap := &corev1.ConfigMap{}
+	if err := r.Client.Get(ctx, r.EndpointRules, configMap); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.Log.Info("endpoint rules ConfigMap not found, using kubeconfig servers", "configMap", r.EndpointRules)
+			return nil, nil
+		}
+		return nil, err
+	}
+	rules, err := ParseEndpointRules(configMap.Data[EndpointRulesKey])
+	if err != nil {
+		return nil, &InvalidSourceError{
+			Reason: ReasonEndpointRulesInvalid,
+			Err:    fmt.Errorf("endpoint rules ConfigMap %s: %v", r.EndpointRules, err),
+		}
+	}
+	return rules, nil
+}
+
+// Replace the kubeconfig server endpoint of the desired registry Clusters
+// by the endpoints the rules render to. Clusters keep the kubeconfig server
+// when no rule renders.
+func (r *SourceReconciler) applyEndpointRules(ctx context.Context, obj runtime.Object, owner metav1.Object, desired []*clusterregistryv1beta1.Cluster) error {
+	rules, err := r.endpointRules(ctx, owner)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+
+	for _, clusterreg := range desired {
+		data := &EndpointTemplateData{
+			Name:        clusterreg.Name,
+			Namespace:   clusterreg.Namespace,
+			Labels:      owner.GetLabels(),
+			Annotations: owner.GetAnnotations(),
+		}
+		if endpoints := clusterreg.Spec.KubernetesAPIEndpoints.ServerEndpoints; len(endpoints) > 0 {
+			data.KubeconfigServer = endpoints[0].ServerAddress
+		}
+		if source, ok := r.Source.(EndpointSource); ok {
+			source.EndpointData(obj, data)
+		}
+
+		endpoints, err := renderEndpoints(rules, data)
+		if err != nil {
+			return &InvalidSourceError{Reason: ReasonEndpointRulesInvalid, Err: err}
+		}
+		if len(endpoints) > 0 {
+			clusterreg.Spec.KubernetesAPIEndpoints.ServerEndpoints = endpoints
+		}
+	}
+	return nil
+}
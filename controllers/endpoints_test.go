@@ -0,0 +1,170 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+const endpointRules = `
+- clientCIDR: 10.0.0.0/8
+  serverAddress: "https://{{ .ControlPlaneHost }}:{{ .ControlPlanePort }}"
+- clientCIDR: 192.168.0.0/16
+  serverAddress: '{{ index .Annotations "lb.example.com/internal" }}'
+- clientCIDR: 0.0.0.0/0
+  serverAddress: "{{ .KubeconfigServer }}"
+`
+
+func TestParseEndpointRules(t *testing.T) {
+	for name, data := range map[string]string{
+		"bad cidr":      "- clientCIDR: 10.0.0.0\n  serverAddress: https://a",
+		"bad template":  "- clientCIDR: 10.0.0.0/8\n  serverAddress: '{{ .Name'",
+		"unknown field": "- clientCIDR: 10.0.0.0/8\n  server: https://a",
+		"not a list":    "clientCIDR: 10.0.0.0/8",
+	} {
+		if _, err := ParseEndpointRules(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	rules, err := ParseEndpointRules(endpointRules)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rules) != 3 {
+		t.Errorf("expected 3 rules, got %d", len(rules))
+	}
+}
+
+func TestRenderEndpoints(t *testing.T) {
+	rules, err := ParseEndpointRules(endpointRules)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the load balancer annotation is missing, so its rule is skipped
+	endpoints, err := renderEndpoints(rules, &EndpointTemplateData{
+		KubeconfigServer: "https://external:6443",
+		ControlPlaneHost: "10.0.0.1",
+		ControlPlanePort: 6443,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []clusterregistryv1beta1.ServerAddressByClientCIDR{
+		{ClientCIDR: "10.0.0.0/8", ServerAddress: "https://10.0.0.1:6443"},
+		{ClientCIDR: "0.0.0.0/0", ServerAddress: "https://external:6443"},
+	}
+	if !reflect.DeepEqual(endpoints, expected) {
+		t.Errorf("expected %v, got %v", expected, endpoints)
+	}
+
+	endpoints, err = renderEndpoints(rules, &EndpointTemplateData{
+		Annotations:      map[string]string{"lb.example.com/internal": "https://lb:443"},
+		KubeconfigServer: "https://external:6443",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	apiEndpoints := clusterregistryv1beta1.KubernetesAPIEndpoints{ServerEndpoints: endpoints}
+	if address, _ := apiEndpoints.ServerAddressFor(net.ParseIP("192.168.1.1")); address != "https://lb:443" {
+		t.Errorf("expected the load balancer for an internal client, got %q", address)
+	}
+}
+
+func TestRestConfigForClientIP(t *testing.T) {
+	cluster := &clusterregistryv1beta1.Cluster{}
+	cluster.Spec.KubernetesAPIEndpoints.ServerEndpoints = []clusterregistryv1beta1.ServerAddressByClientCIDR{
+		{ClientCIDR: "0.0.0.0/0", ServerAddress: "https://external:6443"},
+		{ClientCIDR: "10.0.0.0/8", ServerAddress: "https://internal:6443"},
+	}
+
+	config, err := restConfigForCluster(cluster, net.ParseIP("10.1.1.1"), 0)
+	if err != nil || config.Host != "https://internal:6443" {
+		t.Errorf("expected the internal endpoint, got %v, %v", config, err)
+	}
+	if config, err = restConfigForCluster(cluster, nil, 0); err != nil || config.Host != "https://external:6443" {
+		t.Errorf("expected the first endpoint without client IP, got %v, %v", config, err)
+	}
+	if _, err = restConfigForCluster(cluster, net.ParseIP("fd00::1"), 0); err != errNoServerEndpoint {
+		t.Errorf("expected no endpoint for an unmatched client, got %v", err)
+	}
+}
+
+func TestEndpointRulesConfigMapEnqueuesSources(t *testing.T) {
+	kubeconfigs := map[string]string{clusterregistryv1beta1.KubeconfigSecretLabel: "true"}
+	scheme := newExportScheme(t)
+	hub := fake.NewFakeClientWithScheme(scheme,
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "prod", Namespace: "clusters", Labels: kubeconfigs}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "clusters", Labels: kubeconfigs}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "clusters"}},
+	)
+	r := &SourceReconciler{
+		Client:        hub,
+		Log:           ctrl.Log,
+		Scheme:        scheme,
+		Source:        &KubeconfigSecretSource{},
+		EndpointRules: types.NamespacedName{Namespace: "registry", Name: "endpoint-rules"},
+	}
+	mapObject := func(namespace, name string) handler.MapObject {
+		configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+		return handler.MapObject{Meta: configMap, Object: configMap}
+	}
+
+	requests := r.configMapToSources(mapObject("registry", "endpoint-rules"))
+	names := []string{}
+	for _, request := range requests {
+		names = append(names, request.Name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"dev", "prod"}) {
+		t.Errorf("expected the kubeconfig Secrets to be enqueued, got %v", requests)
+	}
+	if requests := r.configMapToSources(mapObject("registry", "other")); len(requests) != 0 {
+		t.Errorf("expected another ConfigMap to enqueue nothing, got %v", requests)
+	}
+}
+
+func TestEndpointRulesConfigMapInvalid(t *testing.T) {
+	hub := fake.NewFakeClientWithScheme(newExportScheme(t), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "endpoint-rules", Namespace: "registry"},
+		Data:       map[string]string{EndpointRulesKey: "- clientCIDR: not-a-cidr"},
+	})
+	r := &SourceReconciler{
+		Client:        hub,
+		Log:           ctrl.Log,
+		EndpointRules: types.NamespacedName{Namespace: "registry", Name: "endpoint-rules"},
+	}
+
+	_, err := r.endpointRules(context.Background(), &metav1.ObjectMeta{Name: "prod", Namespace: "clusters"})
+	if invalid, ok := err.(*InvalidSourceError); !ok || invalid.Reason != ReasonEndpointRulesInvalid {
+		t.Errorf("expected a malformed ConfigMap to make the source invalid, got %v", err)
+	}
+}
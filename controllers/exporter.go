@@ -0,0 +1,318 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// exportHookPrefix names the pre-delete hook holding a registry Cluster until
+// an exporter has deleted its Secrets, e.g. export-argocd
+const exportHookPrefix = clusterregistryv1beta1.PreDeleteHookAnnotationPrefix + "export-"
+
+// ClusterExporter publishes registry Clusters to a consumer, such as a deploy
+// tool, as Secrets in the format the consumer reads.
+type ClusterExporter interface {
+	// Name identifies the exporter in logs, labels and on the --exporters flag.
+	Name() string
+
+	// Export renders the Secret of a registry Cluster from its controller
+	// kubeconfig, which is nil when the cluster has none. A nil Secret
+	// means the cluster is not exported. The reconciler labels the Secret,
+	// keeps it up to date and deletes it with the registry Cluster.
+	Export(ctx context.Context, clusterreg *clusterregistryv1beta1.Cluster, kubeconfig []byte) (*corev1.Secret, error)
+}
+
+// ExporterFactory builds a ClusterExporter running in the given manager.
+type ExporterFactory func(mgr ctrl.Manager) (ClusterExporter, error)
+
+var exporterFactories = map[string]ExporterFactory{}
+
+// RegisterExporter makes an exporter available by name, usually from an init function.
+func RegisterExporter(name string, factory ExporterFactory) {
+	exporterFactories[name] = factory
+}
+
+// ExporterNames returns the names of all registered exporters.
+func ExporterNames() []string {
+	names := make([]string, 0, len(exporterFactories))
+	for name := range exporterFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewExporter builds the registered exporter with the given name.
+func NewExporter(name string, mgr ctrl.Manager) (ClusterExporter, error) {
+	factory, ok := exporterFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cluster exporter %q, known exporters are %v", name, ExporterNames())
+	}
+	return factory(mgr)
+}
+
+// ExportReconciler keeps the Secrets of a ClusterExporter in line with the
+// registry Clusters
+type ExportReconciler struct {
+	Client client.Client
+	Log    logr.Logger
+
+	Exporter ClusterExporter
+}
+
+// +kubebuilder:rbac:groups=clusterregistry.k8s.io,resources=clusters,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile exports a registry Cluster, and holds it on deletion until its
+// exported Secrets are deleted.
+func (r *ExportReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("exporter", r.Exporter.Name(), "cluster-registry", req.NamespacedName)
+	hook := exportHookPrefix + r.Exporter.Name()
+
+	clusterreg := &clusterregistryv1beta1.Cluster{}
+	if err := r.Client.Get(ctx, req.NamespacedName, clusterreg); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, r.prune(ctx, req.NamespacedName, nil)
+		}
+		return ctrl.Result{}, err
+	}
+
+	if clusterreg.DeletionTimestamp != nil {
+		if _, held := clusterreg.Annotations[hook]; !held {
+			return ctrl.Result{}, nil
+		}
+		if err := r.prune(ctx, req.NamespacedName, nil); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("Release Cluster registry")
+		patch := client.MergeFrom(clusterreg.DeepCopy())
+		delete(clusterreg.Annotations, hook)
+		return ctrl.Result{}, client.IgnoreNotFound(r.Client.Patch(ctx, clusterreg, patch))
+	}
+
+	if _, held := clusterreg.Annotations[hook]; !held {
+		patch := client.MergeFrom(clusterreg.DeepCopy())
+		if clusterreg.Annotations == nil {
+			clusterreg.Annotations = map[string]string{}
+		}
+		clusterreg.Annotations[hook] = r.Exporter.Name()
+		if err := r.Client.Patch(ctx, clusterreg, patch); err != nil {
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+	}
+
+	kubeconfig, err := ControllerKubeconfig(ctx, r.Client, clusterreg)
+	if apierrors.IsNotFound(err) {
+		// the credential Secret is watched, so its creation triggers the export
+		log.Info("Cluster registry credential not available yet", "reason", err.Error())
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	secret, err := r.Exporter.Export(ctx, clusterreg, kubeconfig)
+	if err != nil {
+		log.Error(err, "unable export Cluster registry")
+		return ctrl.Result{}, err
+	}
+	var keep *types.NamespacedName
+	if secret != nil {
+		if err := r.apply(ctx, req.NamespacedName, secret); err != nil {
+			return ctrl.Result{}, err
+		}
+		keep = &types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+	}
+	return ctrl.Result{}, r.prune(ctx, req.NamespacedName, keep)
+}
+
+// Create the exported Secret of a registry Cluster, or update it when it no
+// longer matches. A Secret of the same name not exported for the registry
+// Cluster is left alone.
+func (r *ExportReconciler) apply(ctx context.Context, clusterKey types.NamespacedName, desired *corev1.Secret) error {
+	log := r.Log.WithValues("exporter", r.Exporter.Name(), "cluster-registry", clusterKey,
+		"secret", types.NamespacedName{Namespace: desired.Namespace, Name: desired.Name})
+	if desired.Labels == nil {
+		desired.Labels = map[string]string{}
+	}
+	for key, value := range exportLabels(r.Exporter.Name(), clusterKey) {
+		desired.Labels[key] = value
+	}
+	if desired.Annotations == nil {
+		desired.Annotations = map[string]string{}
+	}
+	desired.Annotations[clusterregistryv1beta1.ClusterAnnotation] = clusterKey.String()
+
+	existing := &corev1.Secret{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: desired.Namespace, Name: desired.Name}, existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		log.Info("Export Cluster registry")
+		return r.Client.Create(ctx, desired)
+	}
+	if !exportedFor(existing, r.Exporter.Name(), clusterKey) {
+		return fmt.Errorf("secret %s/%s exists and is not exported for %s", existing.Namespace, existing.Name, clusterKey)
+	}
+
+	if apiequality.Semantic.DeepEqual(existing.Labels, desired.Labels) &&
+		apiequality.Semantic.DeepEqual(existing.Annotations, desired.Annotations) &&
+		apiequality.Semantic.DeepEqual(existing.Data, secretData(desired)) {
+		return nil
+	}
+	existing.Labels = desired.Labels
+	existing.Annotations = desired.Annotations
+	existing.Data = secretData(desired)
+	existing.StringData = nil
+	log.Info("Update exported Cluster registry")
+	return r.Client.Update(ctx, existing)
+}
+
+// Delete the Secrets exported for a registry Cluster, but the one to keep
+func (r *ExportReconciler) prune(ctx context.Context, clusterKey types.NamespacedName, keep *types.NamespacedName) error {
+	secrets := &corev1.SecretList{}
+	if err := r.Client.List(ctx, secrets, client.MatchingLabels(exportLabels(r.Exporter.Name(), clusterKey))); err != nil {
+		return err
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if keep != nil && secret.Namespace == keep.Namespace && secret.Name == keep.Name {
+			continue
+		}
+		if !exportedFor(secret, r.Exporter.Name(), clusterKey) {
+			continue
+		}
+		r.Log.Info("Delete exported Cluster registry", "exporter", r.Exporter.Name(), "cluster-registry", clusterKey,
+			"secret", types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name})
+		if err := r.Client.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The labels marking the Secrets an exporter exported for a registry
+// Cluster. Names longer than a label value are cut and suffixed with a hash,
+// so the labels select a superset told apart by exportedFor.
+func exportLabels(exporter string, clusterKey types.NamespacedName) map[string]string {
+	return map[string]string{
+		clusterregistryv1beta1.ExporterLabel:         exporter,
+		clusterregistryv1beta1.ClusterNamespaceLabel: clusterKey.Namespace,
+		clusterregistryv1beta1.ClusterNameLabel:      hashedName(clusterKey.Name, validation.LabelValueMaxLength),
+	}
+}
+
+// Whether an exporter exported the Secret for a registry Cluster. Secrets
+// exported before ClusterAnnotation was set are told by their labels only.
+func exportedFor(secret *corev1.Secret, exporter string, clusterKey types.NamespacedName) bool {
+	for key, value := range exportLabels(exporter, clusterKey) {
+		if secret.Labels[key] != value {
+			return false
+		}
+	}
+	exported, ok := secret.Annotations[clusterregistryv1beta1.ClusterAnnotation]
+	return !ok || exported == clusterKey.String()
+}
+
+// The registry Cluster an exported Secret was exported for
+func exportedClusterKey(secret metav1.Object) types.NamespacedName {
+	if exported, ok := secret.GetAnnotations()[clusterregistryv1beta1.ClusterAnnotation]; ok {
+		if i := strings.Index(exported, "/"); i >= 0 {
+			return types.NamespacedName{Namespace: exported[:i], Name: exported[i+1:]}
+		}
+	}
+	labels := secret.GetLabels()
+	return types.NamespacedName{
+		Namespace: labels[clusterregistryv1beta1.ClusterNamespaceLabel],
+		Name:      labels[clusterregistryv1beta1.ClusterNameLabel],
+	}
+}
+
+// hashedName cuts name to max characters, replacing its end with a hash of
+// the whole name so that cut names stay distinct
+func hashedName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	return name[:max-9] + "-" + nameHash(name)
+}
+
+// nameHash is a short hash of name to suffix derived names with
+func nameHash(name string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:8]
+}
+
+// The data of a Secret as the API server stores it, with StringData merged in
+func secretData(secret *corev1.Secret) map[string][]byte {
+	data := map[string][]byte{}
+	for key, value := range secret.Data {
+		data[key] = value
+	}
+	for key, value := range secret.StringData {
+		data[key] = []byte(value)
+	}
+	return data
+}
+
+// Map an exported Secret, or a controller credential owned by a registry
+// Cluster, to the registry Cluster
+func (r *ExportReconciler) secretToCluster(o handler.MapObject) []ctrl.Request {
+	if o.Meta.GetLabels()[clusterregistryv1beta1.ExporterLabel] == r.Exporter.Name() {
+		return []ctrl.Request{{NamespacedName: exportedClusterKey(o.Meta)}}
+	}
+	for _, ref := range o.Meta.GetOwnerReferences() {
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err == nil && gv.Group == clusterregistryv1beta1.GroupVersion.Group && ref.Kind == "Cluster" &&
+			ref.Controller != nil && *ref.Controller {
+			return []ctrl.Request{{NamespacedName: types.NamespacedName{Namespace: o.Meta.GetNamespace(), Name: ref.Name}}}
+		}
+	}
+	return nil
+}
+
+// Setup method for controller
+func (r *ExportReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("export-"+r.Exporter.Name()).
+		For(&clusterregistryv1beta1.Cluster{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.secretToCluster),
+		}).
+		WithOptions(options).
+		Complete(r)
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+func newExportScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := clusterregistryv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func controllerKubeconfigData(t *testing.T, authInfo *clientcmdapi.AuthInfo) []byte {
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters["member"] = &clientcmdapi.Cluster{Server: "https://elsewhere:6443"}
+	kubeconfig.AuthInfos["controller"] = authInfo
+	kubeconfig.Contexts["member"] = &clientcmdapi.Context{Cluster: "member", AuthInfo: "controller"}
+	kubeconfig.CurrentContext = "member"
+	data, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func exportedCluster() *clusterregistryv1beta1.Cluster {
+	return &clusterregistryv1beta1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "member",
+			Namespace:   "clusters",
+			Labels:      map[string]string{clusterregistryv1beta1.EnvironmentLabel: "prod"},
+			Annotations: map[string]string{ArgoCDProjectAnnotation: "payments", "argocd.argoproj.io/sync-wave": "1", "other": "x"},
+		},
+		Spec: clusterregistryv1beta1.ClusterSpec{
+			KubernetesAPIEndpoints: clusterregistryv1beta1.KubernetesAPIEndpoints{
+				ServerEndpoints: []clusterregistryv1beta1.ServerAddressByClientCIDR{
+					{ClientCIDR: "0.0.0.0/0", ServerAddress: "https://member.example.com:6443"},
+				},
+				CABundle: []byte("ca"),
+			},
+			AuthInfo: clusterregistryv1beta1.AuthInfo{
+				Controller: &clusterregistryv1beta1.ObjectReference{Kind: "Secret", Namespace: "clusters", Name: "member-credentials"},
+			},
+		},
+	}
+}
+
+func TestArgoCDExport(t *testing.T) {
+	exporter := &ArgoCDExporter{Namespace: "argocd"}
+	clusterreg := exportedCluster()
+
+	if secret, err := exporter.Export(context.Background(), clusterreg, nil); err != nil || secret != nil {
+		t.Errorf("expected no export without a credential, got %v, %v", secret, err)
+	}
+
+	kubeconfig := controllerKubeconfigData(t, &clientcmdapi.AuthInfo{Token: "token"})
+	secret, err := exporter.Export(context.Background(), clusterreg, kubeconfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if secret.Namespace != "argocd" || secret.Name != "clusters-member" {
+		t.Errorf("unexpected Secret %s/%s", secret.Namespace, secret.Name)
+	}
+	if secret.Labels[ArgoCDSecretTypeLabel] != "cluster" || secret.Labels[clusterregistryv1beta1.EnvironmentLabel] != "prod" {
+		t.Errorf("expected the Argo CD and passed through labels, got %v", secret.Labels)
+	}
+	if len(secret.Annotations) != 1 || secret.Annotations["argocd.argoproj.io/sync-wave"] != "1" {
+		t.Errorf("expected only the Argo CD annotations, got %v", secret.Annotations)
+	}
+	if string(secret.Data["name"]) != "member" || string(secret.Data["server"]) != "https://member.example.com:6443" ||
+		string(secret.Data["project"]) != "payments" {
+		t.Errorf("unexpected data %v", secret.Data)
+	}
+	config := &argoCDClusterConfig{}
+	if err := json.Unmarshal(secret.Data["config"], config); err != nil {
+		t.Fatal(err)
+	}
+	if config.BearerToken != "token" || string(config.TLSClientConfig.CAData) != "ca" || config.TLSClientConfig.Insecure {
+		t.Errorf("expected the token and registry CA bundle, got %+v", config)
+	}
+
+	tokenFile := controllerKubeconfigData(t, &clientcmdapi.AuthInfo{TokenFile: "/var/run/token"})
+	if _, err := exporter.Export(context.Background(), clusterreg, tokenFile); err == nil {
+		t.Error("expected a credential with local files to be rejected")
+	}
+	exec := controllerKubeconfigData(t, &clientcmdapi.AuthInfo{Exec: &clientcmdapi.ExecConfig{Command: "sh"}})
+	if _, err := exporter.Export(context.Background(), clusterreg, exec); err == nil {
+		t.Error("expected a credential with an exec plugin to be rejected")
+	}
+}
+
+func TestExportReconciler(t *testing.T) {
+	ctx := context.Background()
+	clusterKey := types.NamespacedName{Namespace: "clusters", Name: "member"}
+	hub := fake.NewFakeClientWithScheme(newExportScheme(t), exportedCluster(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "member-credentials", Namespace: "clusters"},
+		Data: map[string][]byte{
+			clusterregistryv1beta1.ControllerKubeconfigKey: controllerKubeconfigData(t, &clientcmdapi.AuthInfo{Token: "token"}),
+		},
+	})
+	r := &ExportReconciler{Client: hub, Log: ctrl.Log, Exporter: &ArgoCDExporter{Namespace: "argocd"}}
+
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: clusterKey}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clusterreg := &clusterregistryv1beta1.Cluster{}
+	if err := hub.Get(ctx, clusterKey, clusterreg); err != nil {
+		t.Fatal(err)
+	}
+	if clusterreg.Annotations[exportHookPrefix+ArgoCDExporterName] == "" {
+		t.Errorf("expected the export hook, got %v", clusterreg.Annotations)
+	}
+	exported := &corev1.Secret{}
+	if err := hub.Get(ctx, client.ObjectKey{Namespace: "argocd", Name: "clusters-member"}, exported); err != nil {
+		t.Fatalf("expected the exported Secret: %v", err)
+	}
+	if exported.Labels[clusterregistryv1beta1.ExporterLabel] != ArgoCDExporterName ||
+		exported.Labels[clusterregistryv1beta1.ClusterNameLabel] != "member" {
+		t.Errorf("expected the export labels, got %v", exported.Labels)
+	}
+	requests := r.secretToCluster(handler.MapObject{Meta: exported, Object: exported})
+	if len(requests) != 1 || requests[0].NamespacedName != clusterKey {
+		t.Errorf("expected the exported Secret to map to %s, got %v", clusterKey, requests)
+	}
+
+	// the export follows the registry Cluster
+	clusterreg.Spec.KubernetesAPIEndpoints.ServerEndpoints[0].ServerAddress = "https://moved.example.com:6443"
+	if err := hub.Update(ctx, clusterreg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: clusterKey}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := hub.Get(ctx, client.ObjectKey{Namespace: "argocd", Name: "clusters-member"}, exported); err != nil {
+		t.Fatal(err)
+	}
+	if string(exported.Data["server"]) != "https://moved.example.com:6443" {
+		t.Errorf("expected the moved server, got %s", exported.Data["server"])
+	}
+
+	// a Secret it did not export is not taken over
+	other := exportedCluster()
+	other.Name, other.ResourceVersion = "taken", ""
+	taken := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "clusters-taken", Namespace: "argocd"}}
+	if err := hub.Create(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.Create(ctx, taken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "clusters", Name: "taken"}}); err == nil {
+		t.Error("expected an error for a Secret exported by someone else")
+	}
+
+	// deleting the registry Cluster deletes the export, then releases it
+	now := metav1.Now()
+	clusterreg.DeletionTimestamp = &now
+	if err := hub.Update(ctx, clusterreg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: clusterKey}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := hub.Get(ctx, client.ObjectKey{Namespace: "argocd", Name: "clusters-member"}, exported); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the export to be deleted, got %v", err)
+	}
+	clusterreg = &clusterregistryv1beta1.Cluster{}
+	if err := hub.Get(ctx, clusterKey, clusterreg); err != nil {
+		t.Fatal(err)
+	}
+	if _, held := clusterreg.Annotations[exportHookPrefix+ArgoCDExporterName]; held {
+		t.Error("expected the export hook to be removed")
+	}
+}
+
+func TestExportReconcilerLongClusterName(t *testing.T) {
+	ctx := context.Background()
+	name := strings.Repeat("a", 60) + "-cluster-registry"
+	long, longer := exportedCluster(), exportedCluster()
+	long.Name, longer.Name = name, name+"-2"
+	hub := fake.NewFakeClientWithScheme(newExportScheme(t), long, longer, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "member-credentials", Namespace: "clusters"},
+		Data: map[string][]byte{
+			clusterregistryv1beta1.ControllerKubeconfigKey: controllerKubeconfigData(t, &clientcmdapi.AuthInfo{Token: "token"}),
+		},
+	})
+	r := &ExportReconciler{Client: hub, Log: ctrl.Log, Exporter: &ArgoCDExporter{Namespace: "argocd"}}
+
+	for _, clusterreg := range []*clusterregistryv1beta1.Cluster{long, longer} {
+		clusterKey := types.NamespacedName{Namespace: clusterreg.Namespace, Name: clusterreg.Name}
+		if _, err := r.Reconcile(ctrl.Request{NamespacedName: clusterKey}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		exported := &corev1.Secret{}
+		if err := hub.Get(ctx, client.ObjectKey{Namespace: "argocd", Name: "clusters-" + clusterreg.Name}, exported); err != nil {
+			t.Fatalf("expected the exported Secret: %v", err)
+		}
+		if errs := validation.ValidateLabels(exported.Labels, field.NewPath("labels")); len(errs) > 0 {
+			t.Errorf("expected valid labels, got %v", errs)
+		}
+		if exported.Annotations[clusterregistryv1beta1.ClusterAnnotation] != clusterKey.String() {
+			t.Errorf("expected the full cluster key, got %v", exported.Annotations)
+		}
+		requests := r.secretToCluster(handler.MapObject{Meta: exported, Object: exported})
+		if len(requests) != 1 || requests[0].NamespacedName != clusterKey {
+			t.Errorf("expected the exported Secret to map to %s, got %v", clusterKey, requests)
+		}
+	}
+
+	// deleting one registry Cluster leaves the export of the other
+	if err := hub.Delete(ctx, long); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "clusters", Name: long.Name}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := hub.Get(ctx, client.ObjectKey{Namespace: "argocd", Name: "clusters-" + long.Name}, &corev1.Secret{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the export to be deleted, got %v", err)
+	}
+	if err := hub.Get(ctx, client.ObjectKey{Namespace: "argocd", Name: "clusters-" + longer.Name}, &corev1.Secret{}); err != nil {
+		t.Errorf("expected the other export to be kept: %v", err)
+	}
+}
+
+func TestNewExporter(t *testing.T) {
+	if _, err := NewExporter("does-not-exist", nil); err == nil {
+		t.Error("expected an unknown exporter to be rejected")
+	}
+	exporter, err := NewExporter(ArgoCDExporterName, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if argocd, ok := exporter.(*ArgoCDExporter); !ok || argocd.Namespace != defaultArgoCDNamespace {
+		t.Errorf("expected the Argo CD exporter, got %#v", exporter)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// Reasons reported on the ClusterOK condition
+const (
+	ReasonHealthCheckSucceeded = "HealthCheckSucceeded"
+	ReasonHealthCheckFailed    = "HealthCheckFailed"
+	ReasonNoServerEndpoint     = "NoServerEndpoint"
+)
+
+// defaultProbeTimeout bounds a single health probe against a member cluster
+const defaultProbeTimeout = 10 * time.Second
+
+var errNoServerEndpoint = errors.New("cluster has no server endpoint")
+
+// Build the client config for a registered cluster from its API endpoints and
+// CA bundle, using the endpoint for clientIP if given, else the first one
+func restConfigForCluster(cluster *clusterregistryv1beta1.Cluster, clientIP net.IP, timeout time.Duration) (*rest.Config, error) {
+	endpoints := cluster.Spec.KubernetesAPIEndpoints
+	if len(endpoints.ServerEndpoints) == 0 {
+		return nil, errNoServerEndpoint
+	}
+	host := endpoints.ServerEndpoints[0].ServerAddress
+	if clientIP != nil {
+		host, _ = endpoints.ServerAddressFor(clientIP)
+	}
+	if host == "" {
+		return nil, errNoServerEndpoint
+	}
+	config := &rest.Config{
+		Host:    host,
+		Timeout: timeout,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAData: endpoints.CABundle,
+		},
+	}
+	// client-go refuses a CA together with skipping verification
+	if cluster.Annotations[clusterregistryv1beta1.InsecureSkipTLSVerifyAnnotation] == "true" {
+		config.TLSClientConfig = rest.TLSClientConfig{Insecure: true}
+	}
+	return config, nil
+}
+
+// Check the API server is ready, falling back to /healthz on servers
+// older than 1.16 that do not serve /readyz. A server that refuses an
+// anonymous probe is serving, so only an authenticated probe fails on
+// 401 or 403.
+func probeCluster(config *rest.Config) error {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	client := clientset.Discovery().RESTClient()
+	_, err = client.Get().AbsPath("/readyz").Do().Raw()
+	if apierrors.IsNotFound(err) {
+		_, err = client.Get().AbsPath("/healthz").Do().Raw()
+	}
+	if anonymous(config) && (apierrors.IsUnauthorized(err) || apierrors.IsForbidden(err)) {
+		return nil
+	}
+	return err
+}
+
+func anonymous(config *rest.Config) bool {
+	return config.BearerToken == "" && config.Username == "" && len(config.CertData) == 0
+}
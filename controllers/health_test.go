@@ -0,0 +1,95 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+func TestProbeCluster(t *testing.T) {
+	// the member serves /readyz to the controller token only
+	member := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "Bearer token":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer member.Close()
+
+	for _, tc := range []struct {
+		name   string
+		config *rest.Config
+		failed bool
+	}{
+		{"anonymous", &rest.Config{Host: member.URL}, false},
+		{"controller", &rest.Config{Host: member.URL, BearerToken: "token"}, false},
+		{"revoked controller", &rest.Config{Host: member.URL, BearerToken: "revoked"}, true},
+	} {
+		if err := probeCluster(tc.config); (err != nil) != tc.failed {
+			t.Errorf("%s: expected failure %v, got %v", tc.name, tc.failed, err)
+		}
+	}
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+	if err := probeCluster(&rest.Config{Host: down.URL}); err == nil {
+		t.Error("expected an unready server to fail an anonymous probe")
+	}
+}
+
+func TestProbeConfigUsesControllerCredential(t *testing.T) {
+	cluster := exportedCluster()
+	hub := fake.NewFakeClientWithScheme(newExportScheme(t), cluster, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "member-credentials", Namespace: "clusters"},
+		Data: map[string][]byte{
+			clusterregistryv1beta1.ControllerKubeconfigKey: controllerKubeconfigData(t, &clientcmdapi.AuthInfo{Token: "token"}),
+		},
+	})
+	r := &ClusterReconciler{Client: hub, Log: ctrl.Log}
+	config := &rest.Config{Host: "https://member.example.com:6443"}
+
+	probe := r.probeConfig(context.Background(), r.Log, cluster, config)
+	if probe.BearerToken != "token" || probe.Host != config.Host {
+		t.Errorf("expected the controller token against %s, got %+v", config.Host, probe)
+	}
+	if config.BearerToken != "" {
+		t.Error("expected the anonymous config to be left as is")
+	}
+
+	cluster.Spec.AuthInfo.Controller = nil
+	if probe := r.probeConfig(context.Background(), r.Log, cluster, config); probe != config {
+		t.Errorf("expected an anonymous probe without a controller credential, got %+v", probe)
+	}
+}
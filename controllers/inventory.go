@@ -0,0 +1,259 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// defaultInventoryPeriod is how often the inventory of a cluster is refreshed by default
+const defaultInventoryPeriod = 10 * time.Minute
+
+const (
+	// nodeRoleLabelPrefix marks the roles of a node, e.g. node-role.kubernetes.io/master
+	nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+	// legacyNodeRoleLabel holds the role of a node on older installers such as kops
+	legacyNodeRoleLabel = "kubernetes.io/role"
+	// defaultNodeRole counts the nodes without a role label
+	defaultNodeRole = "worker"
+
+	regionLabel     = "topology.kubernetes.io/region"
+	betaRegionLabel = "failure-domain.beta.kubernetes.io/region"
+)
+
+// cniDaemonSets detects the network plugin by the prefix of a DaemonSet name
+// in kube-system. Canal runs calico and flannel, so it goes first.
+var cniDaemonSets = []struct {
+	prefix string
+	cni    string
+}{
+	{"canal", "canal"},
+	{"calico-node", "calico"},
+	{"cilium", "cilium"},
+	{"kube-flannel", "flannel"},
+	{"weave-net", "weave"},
+	{"antrea-agent", "antrea"},
+	{"kube-router", "kube-router"},
+	{"kube-ovn-cni", "kube-ovn"},
+	{"aws-node", "aws-vpc-cni"},
+	{"azure-cni", "azure"},
+}
+
+// inventory is what the registry records about the nodes and platform of a
+// member cluster
+type inventory struct {
+	version  string
+	nodes    *clusterregistryv1beta1.NodeSummary
+	capacity corev1.ResourceList
+	provider string
+	region   string
+	cni      string
+}
+
+// Collect the inventory of a member cluster. Only the server version is
+// collected unless full, as reading nodes and DaemonSets needs credentials.
+func collectInventory(clientset kubernetes.Interface, full bool) (*inventory, error) {
+	version, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	inv := &inventory{version: version.GitVersion}
+	if !full {
+		return inv, nil
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	inv.nodes = &clusterregistryv1beta1.NodeSummary{Roles: map[string]int32{}}
+	cpu, memory := resource.Quantity{}, resource.Quantity{}
+	providers, regions := map[string]int{}, map[string]int{}
+	for _, node := range nodes.Items {
+		inv.nodes.Total++
+		for _, role := range nodeRoles(&node) {
+			inv.nodes.Roles[role]++
+		}
+		cpu.Add(*node.Status.Allocatable.Cpu())
+		memory.Add(*node.Status.Allocatable.Memory())
+		if i := strings.Index(node.Spec.ProviderID, "://"); i > 0 {
+			providers[node.Spec.ProviderID[:i]]++
+		}
+		if region := node.Labels[regionLabel]; region != "" {
+			regions[region]++
+		} else if region := node.Labels[betaRegionLabel]; region != "" {
+			regions[region]++
+		}
+	}
+	inv.capacity = corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceMemory: memory}
+	inv.provider = mostCommon(providers)
+	inv.region = mostCommon(regions)
+
+	daemonSets, err := clientset.AppsV1().DaemonSets(metav1.NamespaceSystem).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, known := range cniDaemonSets {
+		for _, ds := range daemonSets.Items {
+			if strings.HasPrefix(ds.Name, known.prefix) {
+				inv.cni = known.cni
+				return inv, nil
+			}
+		}
+	}
+	return inv, nil
+}
+
+// Record the inventory in the status of a registry Cluster, keeping what the
+// inventory has not collected
+func (inv *inventory) apply(status *clusterregistryv1beta1.ClusterStatus) {
+	status.Version = inv.version
+	if inv.nodes == nil {
+		return
+	}
+	status.Nodes = inv.nodes
+	status.Capacity = inv.capacity
+	status.Provider = inv.provider
+	status.Region = inv.region
+	status.CNI = inv.cni
+}
+
+// The roles of a node from its role labels, worker when it has none
+func nodeRoles(node *corev1.Node) []string {
+	var roles []string
+	for label := range node.Labels {
+		if strings.HasPrefix(label, nodeRoleLabelPrefix) && len(label) > len(nodeRoleLabelPrefix) {
+			roles = append(roles, strings.TrimPrefix(label, nodeRoleLabelPrefix))
+		}
+	}
+	if role := node.Labels[legacyNodeRoleLabel]; role != "" && !containsString(roles, role) {
+		roles = append(roles, role)
+	}
+	if len(roles) == 0 {
+		roles = append(roles, defaultNodeRole)
+	}
+	return roles
+}
+
+// The most common value counted, the first in order on ties
+func mostCommon(counts map[string]int) string {
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	best := ""
+	for _, value := range values {
+		if counts[value] > counts[best] {
+			best = value
+		}
+	}
+	return best
+}
+
+// Whether the inventory of a registry Cluster is missing or older than period
+func inventoryDue(cluster *clusterregistryv1beta1.Cluster, period time.Duration) bool {
+	last := cluster.Status.LastInventoryTime
+	return last == nil || time.Since(last.Time) >= period
+}
+
+// Refresh the inventory of a reachable registry Cluster in its status. The
+// server version is read with the probe config, everything else with the
+// controller credential of Spec.AuthInfo.Controller on the same endpoint.
+func (r *ClusterReconciler) refreshInventory(ctx context.Context, cluster *clusterregistryv1beta1.Cluster, config *rest.Config) error {
+	config = rest.CopyConfig(config)
+	kubeconfig, err := ControllerKubeconfig(ctx, r.Client, cluster)
+	if err != nil {
+		return err
+	}
+	full := kubeconfig != nil
+	if full {
+		if err := withCredential(config, kubeconfig); err != nil {
+			return fmt.Errorf("controller credential of %s: %v", cluster.Name, err)
+		}
+	}
+
+	newClient := r.newClient
+	if newClient == nil {
+		newClient = func(config *rest.Config) (kubernetes.Interface, error) {
+			return kubernetes.NewForConfig(config)
+		}
+	}
+	clientset, err := newClient(config)
+	if err != nil {
+		return err
+	}
+	inv, err := collectInventory(clientset, full)
+	if err != nil {
+		return err
+	}
+	inv.apply(&cluster.Status)
+	now := metav1.Now()
+	cluster.Status.LastInventoryTime = &now
+	return nil
+}
+
+// Authenticate config as the user of the current context of a controller
+// credential, keeping the registry's view of the endpoint and CA. Only an
+// inline token, client certificate or username and password is accepted:
+// exec and auth provider plugins would run in the manager and file
+// references would be read from its filesystem.
+func withCredential(config *rest.Config, kubeconfig []byte) error {
+	loaded, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return err
+	}
+	current, ok := loaded.Contexts[loaded.CurrentContext]
+	if !ok || loaded.AuthInfos[current.AuthInfo] == nil {
+		return fmt.Errorf("no user for the current context")
+	}
+	user := loaded.AuthInfos[current.AuthInfo]
+	switch {
+	case user.TokenFile != "" || user.ClientCertificate != "" || user.ClientKey != "":
+		return fmt.Errorf("user %s refers to local files", current.AuthInfo)
+	case user.Exec != nil || user.AuthProvider != nil:
+		return fmt.Errorf("user %s uses an exec or auth provider plugin", current.AuthInfo)
+	case user.Impersonate != "" || len(user.ImpersonateGroups) > 0 || len(user.ImpersonateUserExtra) > 0:
+		return fmt.Errorf("user %s impersonates", current.AuthInfo)
+	}
+
+	config.BearerToken = user.Token
+	config.BearerTokenFile = ""
+	config.Username = user.Username
+	config.Password = user.Password
+	config.Impersonate = rest.ImpersonationConfig{}
+	config.AuthProvider = nil
+	config.ExecProvider = nil
+	config.CertData = user.ClientCertificateData
+	config.CertFile = ""
+	config.KeyData = user.ClientKeyData
+	config.KeyFile = ""
+	return nil
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+func inventoryNode(name, providerID string, labels map[string]string, cpu, memory string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Spec:       corev1.NodeSpec{ProviderID: providerID},
+		Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpu),
+			corev1.ResourceMemory: resource.MustParse(memory),
+		}},
+	}
+}
+
+func newMemberClientset(objects ...runtime.Object) *kubefake.Clientset {
+	objects = append(objects,
+		inventoryNode("master", "aws:///us-east-1a/i-1", map[string]string{
+			"node-role.kubernetes.io/master": "",
+			regionLabel:                      "us-east-1",
+		}, "2", "4Gi"),
+		inventoryNode("worker-1", "aws:///us-east-1a/i-2", map[string]string{betaRegionLabel: "us-east-1"}, "4", "8Gi"),
+		inventoryNode("worker-2", "aws:///us-east-1b/i-3", map[string]string{legacyNodeRoleLabel: "node"}, "4", "8Gi"),
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "kube-proxy", Namespace: metav1.NamespaceSystem}},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "kube-flannel-ds-amd64", Namespace: metav1.NamespaceSystem}},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "canal", Namespace: metav1.NamespaceSystem}},
+	)
+	member := kubefake.NewSimpleClientset(objects...)
+	member.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: "v1.17.3"}
+	return member
+}
+
+func TestCollectInventory(t *testing.T) {
+	member := newMemberClientset()
+
+	inv, err := collectInventory(member, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if inv.version != "v1.17.3" || inv.nodes != nil {
+		t.Errorf("expected the version only, got %+v", inv)
+	}
+
+	inv, err = collectInventory(member, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	roles := map[string]int32{"master": 1, "worker": 1, "node": 1}
+	if inv.nodes.Total != 3 || !reflect.DeepEqual(inv.nodes.Roles, roles) {
+		t.Errorf("expected 3 nodes by role %v, got %+v", roles, inv.nodes)
+	}
+	if cpu, memory := inv.capacity[corev1.ResourceCPU], inv.capacity[corev1.ResourceMemory]; cpu.Cmp(resource.MustParse("10")) != 0 ||
+		memory.Cmp(resource.MustParse("20Gi")) != 0 {
+		t.Errorf("expected 10 CPUs and 20Gi of memory, got %v", inv.capacity)
+	}
+	if inv.provider != "aws" || inv.region != "us-east-1" {
+		t.Errorf("expected aws in us-east-1, got %q in %q", inv.provider, inv.region)
+	}
+	if inv.cni != "canal" {
+		t.Errorf("expected canal over flannel, got %q", inv.cni)
+	}
+}
+
+func TestNodeRoles(t *testing.T) {
+	for _, tc := range []struct {
+		labels map[string]string
+		roles  []string
+	}{
+		{nil, []string{"worker"}},
+		{map[string]string{"node-role.kubernetes.io/": ""}, []string{"worker"}},
+		{map[string]string{"node-role.kubernetes.io/master": "", legacyNodeRoleLabel: "master"}, []string{"master"}},
+		{map[string]string{legacyNodeRoleLabel: "infra"}, []string{"infra"}},
+	} {
+		if roles := nodeRoles(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: tc.labels}}); !reflect.DeepEqual(roles, tc.roles) {
+			t.Errorf("%v: expected roles %v, got %v", tc.labels, tc.roles, roles)
+		}
+	}
+}
+
+func TestInventoryDue(t *testing.T) {
+	cluster := &clusterregistryv1beta1.Cluster{}
+	if !inventoryDue(cluster, time.Hour) {
+		t.Error("expected a missing inventory to be due")
+	}
+	recent := metav1.NewTime(time.Now().Add(-time.Minute))
+	cluster.Status.LastInventoryTime = &recent
+	if inventoryDue(cluster, time.Hour) {
+		t.Error("expected a recent inventory not to be due")
+	}
+	if !inventoryDue(cluster, time.Second) {
+		t.Error("expected an old inventory to be due")
+	}
+}
+
+func TestRefreshInventory(t *testing.T) {
+	ctx := context.Background()
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters["member"] = &clientcmdapi.Cluster{Server: "https://elsewhere:6443"}
+	kubeconfig.AuthInfos["controller"] = &clientcmdapi.AuthInfo{Token: "controller-token"}
+	kubeconfig.Contexts["member"] = &clientcmdapi.Context{Cluster: "member", AuthInfo: "controller"}
+	kubeconfig.CurrentContext = "member"
+	data, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := fake.NewFakeClientWithScheme(clientgoscheme.Scheme, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "member-credentials", Namespace: "default"},
+		Data:       map[string][]byte{clusterregistryv1beta1.ControllerKubeconfigKey: data},
+	})
+
+	var connected *rest.Config
+	member := newMemberClientset()
+	r := &ClusterReconciler{
+		Client: hub,
+		Log:    ctrl.Log,
+		newClient: func(config *rest.Config) (kubernetes.Interface, error) {
+			connected = config
+			return member, nil
+		},
+	}
+	cluster := &clusterregistryv1beta1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "member", Namespace: "default"}}
+	probe := &rest.Config{Host: "https://member:6443", TLSClientConfig: rest.TLSClientConfig{CAData: []byte("ca")}}
+
+	if err := r.refreshInventory(ctx, cluster, probe); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cluster.Status.Version != "v1.17.3" || cluster.Status.Nodes != nil || cluster.Status.LastInventoryTime == nil {
+		t.Errorf("expected the version only without credentials, got %+v", cluster.Status)
+	}
+
+	cluster.Spec.AuthInfo.Controller = &clusterregistryv1beta1.ObjectReference{Kind: "Secret", Namespace: "default", Name: "member-credentials"}
+	if err := r.refreshInventory(ctx, cluster, probe); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if connected.Host != probe.Host || string(connected.CAData) != "ca" || connected.BearerToken != "controller-token" {
+		t.Errorf("expected the probed endpoint with the controller credential, got %+v", connected)
+	}
+	if probe.BearerToken != "" {
+		t.Error("expected the probe config to be left alone")
+	}
+	if cluster.Status.Nodes == nil || cluster.Status.Nodes.Total != 3 || cluster.Status.CNI != "canal" || cluster.Status.Provider != "aws" {
+		t.Errorf("expected the full inventory, got %+v", cluster.Status)
+	}
+
+	cluster.Spec.AuthInfo.Controller.Name = "missing"
+	if err := r.refreshInventory(ctx, cluster, probe); err == nil {
+		t.Error("expected an error for a missing credential")
+	}
+	if cluster.Status.Nodes == nil {
+		t.Error("expected a failed refresh to keep the inventory")
+	}
+}
+
+func TestWithCredential(t *testing.T) {
+	config := &rest.Config{Host: "https://member:6443", BearerTokenFile: "/var/run/token"}
+	if err := withCredential(config, controllerKubeconfigData(t, &clientcmdapi.AuthInfo{ClientCertificateData: []byte("cert"), ClientKeyData: []byte("key")})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(config.CertData) != "cert" || string(config.KeyData) != "key" || config.BearerTokenFile != "" || config.Host != "https://member:6443" {
+		t.Errorf("expected the inline client certificate only, got %+v", config)
+	}
+
+	for name, user := range map[string]*clientcmdapi.AuthInfo{
+		"token file":    {TokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token"},
+		"client key":    {ClientCertificateData: []byte("cert"), ClientKey: "/etc/key.pem"},
+		"exec":          {Exec: &clientcmdapi.ExecConfig{Command: "sh", Args: []string{"-c", "id"}}},
+		"auth provider": {AuthProvider: &clientcmdapi.AuthProviderConfig{Name: "gcp"}},
+		"impersonation": {Token: "token", Impersonate: "system:admin"},
+	} {
+		if err := withCredential(&rest.Config{}, controllerKubeconfigData(t, user)); err == nil {
+			t.Errorf("%s: expected the credential to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// ReasonKubeconfigInvalid is reported when a kubeconfig cannot be used to register a cluster
+const ReasonKubeconfigInvalid = "KubeconfigInvalid"
+
+var errEmptyKubeconfig = errors.New("kubeconfig is empty")
+
+// Parse a kubeconfig, treating one without any cluster as invalid
+func loadKubeconfig(data []byte) (*clientcmdapi.Config, error) {
+	if len(data) == 0 {
+		return nil, errEmptyKubeconfig
+	}
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse kubeconfig: %v", err)
+	}
+	if len(config.Clusters) == 0 {
+		return nil, errEmptyKubeconfig
+	}
+	return config, nil
+}
+
+// Find the cluster entry the kubeconfig uses for the named cluster: the one
+// of the current context, else the one named after the cluster, else the
+// only one there is. Entry names rarely match the cluster name in kubeadm
+// generated or imported kubeconfigs, so the name is only a fallback.
+func resolveCluster(config *clientcmdapi.Config, name string) (*clientcmdapi.Cluster, error) {
+	if context, ok := config.Contexts[config.CurrentContext]; ok {
+		if cluster, ok := config.Clusters[context.Cluster]; ok {
+			return cluster, nil
+		}
+	}
+	if cluster, ok := config.Clusters[name]; ok {
+		return cluster, nil
+	}
+	if len(config.Clusters) == 1 {
+		for _, cluster := range config.Clusters {
+			return cluster, nil
+		}
+	}
+	if config.CurrentContext != "" {
+		return nil, fmt.Errorf("current context %q does not resolve to a cluster and none is named %q", config.CurrentContext, name)
+	}
+	return nil, fmt.Errorf("kubeconfig has %d clusters, no current context and none named %q", len(config.Clusters), name)
+}
+
+// Build the registry Cluster for a kubeconfig cluster entry. Only an inline
+// CA is accepted: a CA given as a file path would be read from the
+// controller's own filesystem, so it is rejected as an InvalidSourceError.
+// insecure-skip-tls-verify is kept as an annotation since the registry API
+// has no field for it.
+func registryFromKubeconfig(name, namespace string, cluster *clientcmdapi.Cluster) (*clusterregistryv1beta1.Cluster, error) {
+	if cluster.Server == "" {
+		return nil, errors.New("kubeconfig cluster has no server")
+	}
+	if len(cluster.CertificateAuthorityData) == 0 && cluster.CertificateAuthority != "" {
+		return nil, &InvalidSourceError{
+			Reason: ReasonKubeconfigInvalid,
+			Err:    fmt.Errorf("certificate-authority refers to the file %s, only certificate-authority-data is accepted", cluster.CertificateAuthority),
+		}
+	}
+
+	clusterreg := CreateClusterRegistry(name, namespace, cluster.CertificateAuthorityData, cluster.Server)
+	if cluster.InsecureSkipTLSVerify {
+		clusterreg.Annotations = map[string]string{clusterregistryv1beta1.InsecureSkipTLSVerifyAnnotation: "true"}
+	}
+	return clusterreg, nil
+}
+
+// KubeconfigFor builds a self-contained kubeconfig reaching a registry
+// Cluster at server, inlining the user of the current context of credential.
+// Without a credential the kubeconfig has no user. Credentials referring to
+// local files are rejected as they would not resolve where the kubeconfig is used.
+func KubeconfigFor(clusterreg *clusterregistryv1beta1.Cluster, server string, credential []byte) (*clientcmdapi.Config, error) {
+	config := clientcmdapi.NewConfig()
+	cluster := &clientcmdapi.Cluster{Server: server, CertificateAuthorityData: clusterreg.Spec.KubernetesAPIEndpoints.CABundle}
+	if clusterreg.Annotations[clusterregistryv1beta1.InsecureSkipTLSVerifyAnnotation] == "true" {
+		cluster = &clientcmdapi.Cluster{Server: server, InsecureSkipTLSVerify: true}
+	}
+	config.Clusters[clusterreg.Name] = cluster
+	context := &clientcmdapi.Context{Cluster: clusterreg.Name}
+	config.Contexts[clusterreg.Name] = context
+	config.CurrentContext = clusterreg.Name
+	if credential == nil {
+		return config, nil
+	}
+
+	loaded, err := clientcmd.Load(credential)
+	if err != nil {
+		return nil, fmt.Errorf("credential of %s: %v", clusterreg.Name, err)
+	}
+	current, ok := loaded.Contexts[loaded.CurrentContext]
+	if !ok || loaded.AuthInfos[current.AuthInfo] == nil {
+		return nil, fmt.Errorf("credential of %s has no user for its current context", clusterreg.Name)
+	}
+	authInfo := loaded.AuthInfos[current.AuthInfo]
+	if authInfo.TokenFile != "" || authInfo.ClientCertificate != "" || authInfo.ClientKey != "" {
+		return nil, fmt.Errorf("credential of %s refers to local files", clusterreg.Name)
+	}
+	config.AuthInfos[current.AuthInfo] = authInfo
+	context.AuthInfo = current.AuthInfo
+	return config, nil
+}
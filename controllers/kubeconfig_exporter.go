@@ -0,0 +1,110 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// KubeconfigExporterName is the name of the kubeconfig exporter
+const KubeconfigExporterName = "kubeconfig"
+
+// defaultKubeconfigExportKey is the key Flux reads a kubeConfig.secretRef
+// from, as Cluster API writes it
+const defaultKubeconfigExportKey = "value"
+
+func init() {
+	RegisterExporter(KubeconfigExporterName, func(mgr ctrl.Manager) (ClusterExporter, error) {
+		return &KubeconfigExporter{Key: defaultKubeconfigExportKey}, nil
+	})
+}
+
+// KubeconfigExporter exports each registry Cluster as a self-contained
+// kubeconfig Secret <namespace>-<name>-kubeconfig, for consumers such as a
+// Flux Kustomization spec.kubeConfig.secretRef
+type KubeconfigExporter struct {
+	// Namespace the Secrets are exported to, the namespace of each registry
+	// Cluster when empty
+	Namespace string
+	// Key of the kubeconfig in the Secrets
+	Key string
+	// ClientCIDR picks the server endpoint of the kubeconfig: the one for
+	// exactly this CIDR, else the one for its address. The first one when unset.
+	ClientCIDR *net.IPNet
+}
+
+func (e *KubeconfigExporter) Name() string {
+	return KubeconfigExporterName
+}
+
+// Export a registry Cluster with a server endpoint and a controller
+// credential as a kubeconfig, with the credential inlined
+func (e *KubeconfigExporter) Export(ctx context.Context, clusterreg *clusterregistryv1beta1.Cluster, kubeconfig []byte) (*corev1.Secret, error) {
+	if kubeconfig == nil {
+		return nil, nil
+	}
+	server := e.server(&clusterreg.Spec.KubernetesAPIEndpoints)
+	if server == "" {
+		return nil, nil
+	}
+	exported, err := KubeconfigFor(clusterreg, server, kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	data, err := clientcmd.Write(*exported)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := e.Namespace
+	if namespace == "" {
+		namespace = clusterreg.Namespace
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      clusterreg.Namespace + "-" + clusterreg.Name + "-kubeconfig",
+			Namespace: namespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{e.Key: data},
+	}, nil
+}
+
+// The server endpoint for the client CIDR
+func (e *KubeconfigExporter) server(endpoints *clusterregistryv1beta1.KubernetesAPIEndpoints) string {
+	if len(endpoints.ServerEndpoints) == 0 {
+		return ""
+	}
+	if e.ClientCIDR == nil {
+		return endpoints.ServerEndpoints[0].ServerAddress
+	}
+	for _, endpoint := range endpoints.ServerEndpoints {
+		if _, cidr, err := net.ParseCIDR(endpoint.ClientCIDR); err == nil && cidr.String() == e.ClientCIDR.String() {
+			return endpoint.ServerAddress
+		}
+	}
+	server, _ := endpoints.ServerAddressFor(e.ClientCIDR.IP)
+	return server
+}
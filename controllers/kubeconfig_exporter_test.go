@@ -0,0 +1,87 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+func TestKubeconfigExport(t *testing.T) {
+	exporter := &KubeconfigExporter{Key: defaultKubeconfigExportKey}
+	clusterreg := exportedCluster()
+	clusterreg.Spec.KubernetesAPIEndpoints.ServerEndpoints = append(clusterreg.Spec.KubernetesAPIEndpoints.ServerEndpoints,
+		clusterregistryv1beta1.ServerAddressByClientCIDR{ClientCIDR: "10.0.0.0/8", ServerAddress: "https://10.0.0.10:6443"},
+		clusterregistryv1beta1.ServerAddressByClientCIDR{ClientCIDR: "10.1.0.0/16", ServerAddress: "https://10.1.0.10:6443"},
+	)
+	kubeconfig := controllerKubeconfigData(t, &clientcmdapi.AuthInfo{Token: "token"})
+
+	if secret, err := exporter.Export(context.Background(), clusterreg, nil); err != nil || secret != nil {
+		t.Errorf("expected no export without a credential, got %v, %v", secret, err)
+	}
+
+	for _, tc := range []struct {
+		cidr   string
+		server string
+	}{
+		{"", "https://member.example.com:6443"},
+		{"10.0.0.0/8", "https://10.0.0.10:6443"},
+		{"10.1.2.0/24", "https://10.1.0.10:6443"},
+		{"192.168.0.0/16", "https://member.example.com:6443"},
+	} {
+		exporter.ClientCIDR = nil
+		if tc.cidr != "" {
+			_, exporter.ClientCIDR, _ = net.ParseCIDR(tc.cidr)
+		}
+		secret, err := exporter.Export(context.Background(), clusterreg, kubeconfig)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.cidr, err)
+		}
+		if secret.Namespace != "clusters" || secret.Name != "clusters-member-kubeconfig" {
+			t.Errorf("%s: unexpected Secret %s/%s", tc.cidr, secret.Namespace, secret.Name)
+		}
+		config, err := clientcmd.Load(secret.Data[defaultKubeconfigExportKey])
+		if err != nil {
+			t.Fatalf("%s: %v", tc.cidr, err)
+		}
+		cluster := config.Clusters[config.Contexts[config.CurrentContext].Cluster]
+		user := config.AuthInfos[config.Contexts[config.CurrentContext].AuthInfo]
+		if cluster.Server != tc.server || string(cluster.CertificateAuthorityData) != "ca" || user.Token != "token" {
+			t.Errorf("%s: expected %s with the CA bundle and token, got %+v, %+v", tc.cidr, tc.server, cluster, user)
+		}
+	}
+
+	exporter.Namespace, exporter.Key = "flux-system", "value.yaml"
+	secret, err := exporter.Export(context.Background(), clusterreg, kubeconfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if secret.Namespace != "flux-system" || secret.Data["value.yaml"] == nil {
+		t.Errorf("expected value.yaml in flux-system, got %s/%s %v", secret.Namespace, secret.Name, secret.Data)
+	}
+
+	certFile := controllerKubeconfigData(t, &clientcmdapi.AuthInfo{ClientCertificate: "/etc/client.crt", ClientKey: "/etc/client.key"})
+	if _, err := exporter.Export(context.Background(), clusterreg, certFile); err == nil {
+		t.Error("expected a credential with local files to be rejected")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// KubeconfigSecretSourceName is the name of the kubeconfig secret source
+const KubeconfigSecretSourceName = "kubeconfig-secret"
+
+// kubeconfigSecretKeys are the secret keys a kubeconfig is read from, in order
+var kubeconfigSecretKeys = []string{"value", "kubeconfig"}
+
+func init() {
+	RegisterSource(KubeconfigSecretSourceName, func(mgr ctrl.Manager) (ClusterSource, error) {
+		return &KubeconfigSecretSource{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName("sources").WithName("Kubeconfig-Secret"),
+		}, nil
+	})
+}
+
+// KubeconfigSecretSource registers one cluster per context of a kubeconfig
+// stored in a Secret labeled clusterregistry.k8s.io/kubeconfig=true, for
+// clusters that are not managed by Cluster API
+type KubeconfigSecretSource struct {
+	Client client.Client
+	Log    logr.Logger
+}
+
+func (s *KubeconfigSecretSource) Name() string {
+	return KubeconfigSecretSourceName
+}
+
+func (s *KubeconfigSecretSource) NewObject() runtime.Object {
+	return &corev1.Secret{}
+}
+
+func (s *KubeconfigSecretSource) Watches() []SourceWatch {
+	return nil
+}
+
+// Selects only the secrets labeled as kubeconfigs
+func (s *KubeconfigSecretSource) Selects(obj runtime.Object) bool {
+	secret := obj.(*corev1.Secret)
+	return secret.Labels[clusterregistryv1beta1.KubeconfigSecretLabel] == "true"
+}
+
+func (s *KubeconfigSecretSource) Ready(ctx context.Context, obj runtime.Object) (bool, string, error) {
+	if kubeconfigData(obj.(*corev1.Secret)) == nil {
+		return false, "secret has no kubeconfig under any of the keys " + strings.Join(kubeconfigSecretKeys, ", "), nil
+	}
+	return true, "", nil
+}
+
+// Describe parses the kubeconfig the same way the Cluster API source does
+// and builds a registry Cluster named "<secret>-<context>" per context whose
+// cluster entry is usable
+func (s *KubeconfigSecretSource) Describe(ctx context.Context, obj runtime.Object) ([]*clusterregistryv1beta1.Cluster, error) {
+	secret := obj.(*corev1.Secret)
+	log := s.Log.WithValues("secret", client.ObjectKey{Namespace: secret.Namespace, Name: secret.Name})
+
+	config, err := loadKubeconfig(kubeconfigData(secret))
+	if err != nil {
+		log.Error(err, "Can not load kube-config")
+		return nil, &InvalidSourceError{Reason: ReasonKubeconfigInvalid, Err: err}
+	}
+
+	contexts := make([]string, 0, len(config.Contexts))
+	for name := range config.Contexts {
+		contexts = append(contexts, name)
+	}
+	sort.Strings(contexts)
+
+	clusters := make([]*clusterregistryv1beta1.Cluster, 0, len(contexts))
+	registered := map[string]string{}
+	for _, name := range contexts {
+		cluster, ok := config.Clusters[config.Contexts[name].Cluster]
+		if !ok {
+			log.Info("context refers to a missing cluster", "context", name, "cluster", config.Contexts[name].Cluster)
+			continue
+		}
+		clusterreg, err := registryFromKubeconfig(registryName(secret.Name, name), secret.Namespace, cluster)
+		if invalid, ok := err.(*InvalidSourceError); ok {
+			return nil, invalid
+		}
+		if err != nil {
+			log.Info("context cannot be registered", "context", name, "error", err.Error())
+			continue
+		}
+		if other, ok := registered[clusterreg.Name]; ok {
+			return nil, &InvalidSourceError{Reason: ReasonKubeconfigInvalid,
+				Err: fmt.Errorf("contexts %q and %q are both registered as %s", other, name, clusterreg.Name)}
+		}
+		registered[clusterreg.Name] = name
+		clusters = append(clusters, clusterreg)
+	}
+	if len(clusters) == 0 {
+		return nil, &InvalidSourceError{Reason: ReasonKubeconfigInvalid, Err: fmt.Errorf("kubeconfig has no usable context")}
+	}
+	return clusters, nil
+}
+
+// The kubeconfig stored in the secret, nil if there is none
+func kubeconfigData(secret *corev1.Secret) []byte {
+	for _, key := range kubeconfigSecretKeys {
+		if data, ok := secret.Data[key]; ok && len(data) > 0 {
+			return data
+		}
+	}
+	return nil
+}
+
+// registryName turns a secret and context name into a valid object name.
+// A name that had to be changed is suffixed with a hash of the original, so
+// that e.g. "admin@prod" and "admin-prod" stay distinct: "legacy" and
+// "admin@prod" become "legacy-admin-prod-<hash>".
+func registryName(secret, context string) string {
+	joined := secret + "-" + context
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, joined)
+	if name == joined && len(name) <= validation.DNS1123SubdomainMaxLength && strings.Trim(name, "-.") == name {
+		return name
+	}
+	if len(name) > validation.DNS1123SubdomainMaxLength-9 {
+		name = name[:validation.DNS1123SubdomainMaxLength-9]
+	}
+	return strings.Trim(name, "-.") + "-" + nameHash(secret+"/"+context)
+}
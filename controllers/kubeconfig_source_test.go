@@ -0,0 +1,97 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// newLegacyKubeconfig returns a kubeconfig with one context per named cluster
+func newLegacyKubeconfig(clusters ...string) []byte {
+	config := clientcmdapi.NewConfig()
+	for _, name := range clusters {
+		config.Clusters[name] = &clientcmdapi.Cluster{Server: "https://" + name + ".example.com:6443"}
+		config.AuthInfos[name] = &clientcmdapi.AuthInfo{Token: "token"}
+		config.Contexts["admin@"+name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name}
+	}
+	data, err := clientcmd.Write(*config)
+	Expect(err).ToNot(HaveOccurred())
+	return data
+}
+
+var _ = Describe("KubeconfigSecretSource", func() {
+	const timeout = 10 * time.Second
+
+	ctx := context.Background()
+
+	registered := func() []string {
+		list := &clusterregistryv1beta1.ClusterList{}
+		Expect(k8sClient.List(ctx, list, client.InNamespace("default"),
+			client.MatchingLabels{clusterregistryv1beta1.SourceLabel: KubeconfigSecretSourceName})).To(Succeed())
+		names := []string{}
+		for _, item := range list.Items {
+			if item.DeletionTimestamp == nil {
+				names = append(names, item.Name)
+			}
+		}
+		return names
+	}
+
+	It("registers each context of a labeled kubeconfig secret", func() {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "legacy",
+				Namespace: "default",
+				Labels:    map[string]string{clusterregistryv1beta1.KubeconfigSecretLabel: "true"},
+			},
+			Data: map[string][]byte{"value": newLegacyKubeconfig("prod", "staging")},
+		}
+		Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+		Eventually(registered, timeout).Should(ConsistOf(registryName("legacy", "admin@prod"), registryName("legacy", "admin@staging")))
+
+		By("dropping a context from the kubeconfig")
+		secret.Data["value"] = newLegacyKubeconfig("prod")
+		Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+		Eventually(registered, timeout).Should(ConsistOf(registryName("legacy", "admin@prod")))
+
+		By("removing the label")
+		secret.Labels = nil
+		Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+		Eventually(registered, timeout).Should(BeEmpty())
+	})
+
+	It("ignores secrets without the label", func() {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "unlabeled", Namespace: "default"},
+			Data:       map[string][]byte{"value": newLegacyKubeconfig("dev")},
+		}
+		Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+		Consistently(registered, 2*time.Second).ShouldNot(ContainElement("unlabeled-admin-dev"))
+	})
+})
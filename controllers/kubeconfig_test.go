@@ -0,0 +1,225 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+const multiContextKubeconfig = `
+apiVersion: v1
+kind: Config
+clusters:
+- name: kubernetes
+  cluster:
+    server: https://10.0.0.1:6443
+    certificate-authority-data: Y2E=
+- name: other
+  cluster:
+    server: https://10.0.0.2:6443
+contexts:
+- name: kubernetes-admin@kubernetes
+  context:
+    cluster: kubernetes
+    user: kubernetes-admin
+- name: other-admin@other
+  context:
+    cluster: other
+    user: other-admin
+current-context: kubernetes-admin@kubernetes
+users:
+- name: kubernetes-admin
+  user:
+    token: token
+- name: other-admin
+  user:
+    token: token
+`
+
+func TestLoadKubeconfig(t *testing.T) {
+	for name, data := range map[string]string{
+		"empty":      "",
+		"no cluster": "apiVersion: v1\nkind: Config\n",
+		"malformed":  "apiVersion: v1\nkind: Config\nclusters: {",
+	} {
+		if _, err := loadKubeconfig([]byte(data)); err == nil {
+			t.Errorf("%s kubeconfig: expected an error", name)
+		}
+	}
+
+	config, err := loadKubeconfig([]byte(multiContextKubeconfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(config.Contexts) != 2 {
+		t.Errorf("expected 2 contexts, got %d", len(config.Contexts))
+	}
+}
+
+func TestResolveCluster(t *testing.T) {
+	config, err := loadKubeconfig([]byte(multiContextKubeconfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the kubeadm entry name "kubernetes" differs from the CAPI cluster name
+	cluster, err := resolveCluster(config, "workload")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cluster.Server != "https://10.0.0.1:6443" {
+		t.Errorf("expected the current context cluster, got %s", cluster.Server)
+	}
+
+	config.CurrentContext = "missing"
+	if cluster, err = resolveCluster(config, "other"); err != nil || cluster.Server != "https://10.0.0.2:6443" {
+		t.Errorf("expected a fallback to the cluster named after the CAPI cluster, got %v, %v", cluster, err)
+	}
+	if _, err = resolveCluster(config, "workload"); err == nil {
+		t.Error("expected an error for an ambiguous kubeconfig")
+	}
+
+	delete(config.Clusters, "other")
+	if cluster, err = resolveCluster(config, "workload"); err != nil || cluster.Server != "https://10.0.0.1:6443" {
+		t.Errorf("expected a fallback to the only cluster, got %v, %v", cluster, err)
+	}
+}
+
+func TestRegistryFromKubeconfig(t *testing.T) {
+	if _, err := registryFromKubeconfig("c", "default", &clientcmdapi.Cluster{}); err == nil {
+		t.Error("expected an error for a cluster without server")
+	}
+
+	_, err := registryFromKubeconfig("c", "default", &clientcmdapi.Cluster{
+		Server:               "https://10.0.0.1:6443",
+		CertificateAuthority: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+	})
+	if invalid, ok := err.(*InvalidSourceError); !ok || invalid.Reason != ReasonKubeconfigInvalid {
+		t.Errorf("expected a CA file to be rejected as invalid, got %v", err)
+	}
+
+	clusterreg, err := registryFromKubeconfig("c", "default", &clientcmdapi.Cluster{
+		Server:                   "https://10.0.0.1:6443",
+		CertificateAuthority:     "/etc/ca.crt",
+		CertificateAuthorityData: []byte("ca"),
+	})
+	if err != nil || string(clusterreg.Spec.KubernetesAPIEndpoints.CABundle) != "ca" {
+		t.Errorf("expected the inline CA to win over the file, got %v", err)
+	}
+
+	clusterreg, err = registryFromKubeconfig("c", "default", &clientcmdapi.Cluster{
+		Server:                "https://10.0.0.1:6443",
+		InsecureSkipTLSVerify: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if clusterreg.Annotations[clusterregistryv1beta1.InsecureSkipTLSVerifyAnnotation] != "true" {
+		t.Error("expected the insecure-skip-tls-verify annotation")
+	}
+}
+
+func TestRegistryName(t *testing.T) {
+	if name := registryName("legacy", "prod"); name != "legacy-prod" {
+		t.Errorf("expected a valid name to be kept, got %s", name)
+	}
+	for _, contexts := range [][2]string{{"admin@prod", "admin-prod"}, {"a_b", "a.b"}, {"Prod", "prod"}} {
+		first, second := registryName("legacy", contexts[0]), registryName("legacy", contexts[1])
+		if first == second {
+			t.Errorf("expected %q and %q to be registered apart, both got %s", contexts[0], contexts[1], first)
+		}
+	}
+	long := registryName("legacy", strings.Repeat("a", 300))
+	if errs := validation.IsDNS1123Subdomain(long); len(errs) > 0 {
+		t.Errorf("expected a valid name for a long context, got %v", errs)
+	}
+	if long == registryName("legacy", strings.Repeat("a", 301)) {
+		t.Error("expected long contexts to be registered apart")
+	}
+}
+
+func TestKubeconfigSourceRejectsDuplicateNames(t *testing.T) {
+	config := clientcmdapi.NewConfig()
+	config.Clusters["prod"] = &clientcmdapi.Cluster{Server: "https://prod.example.com:6443"}
+	// the second context is named like the first one is registered
+	for _, context := range []string{"admin@prod", strings.TrimPrefix(registryName("legacy", "admin@prod"), "legacy-")} {
+		config.Contexts[context] = &clientcmdapi.Context{Cluster: "prod"}
+	}
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "legacy", Namespace: "default"},
+		Data:       map[string][]byte{"value": data},
+	}
+
+	_, err = (&KubeconfigSecretSource{Log: ctrl.Log}).Describe(context.Background(), secret)
+	if invalid, ok := err.(*InvalidSourceError); !ok || invalid.Reason != ReasonKubeconfigInvalid {
+		t.Errorf("expected contexts registered under one name to be rejected, got %v", err)
+	}
+}
+
+func TestKubeconfigSecretEventFilter(t *testing.T) {
+	r := &SourceReconciler{Scheme: newExportScheme(t), Source: &KubeconfigSecretSource{}}
+	filter, err := r.selectedObjects(&KubeconfigSecretSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := func(labeled bool, finalizers ...string) *corev1.Secret {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "kubeconfigs", Namespace: "clusters", Finalizers: finalizers}}
+		if labeled {
+			secret.Labels = map[string]string{clusterregistryv1beta1.KubeconfigSecretLabel: "true"}
+		}
+		return secret
+	}
+	create := func(obj *corev1.Secret) bool {
+		return filter.Create(event.CreateEvent{Meta: obj, Object: obj})
+	}
+	update := func(before, after *corev1.Secret) bool {
+		return filter.Update(event.UpdateEvent{MetaOld: before, ObjectOld: before, MetaNew: after, ObjectNew: after})
+	}
+
+	if !create(secret(true)) {
+		t.Error("expected a labeled Secret to pass")
+	}
+	if create(secret(false)) || update(secret(false), secret(false)) {
+		t.Error("expected an unlabeled Secret to be filtered")
+	}
+	if !update(secret(true), secret(false)) {
+		t.Error("expected the label removal to pass, so the source prunes")
+	}
+	if !create(secret(false, clusterregistryv1beta1.SourceFinalizer)) {
+		t.Error("expected an unlabeled Secret still registered to pass")
+	}
+	clusterreg := &clusterregistryv1beta1.Cluster{}
+	if !filter.Create(event.CreateEvent{Meta: clusterreg, Object: clusterreg}) {
+		t.Error("expected owned registry Clusters to pass")
+	}
+}
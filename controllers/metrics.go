@@ -0,0 +1,124 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// Certificates reported by the certificate expiry metric
+const (
+	certificateCA     = "ca"
+	certificateClient = "client"
+)
+
+var (
+	registrationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "clusterregistry_registration_duration_seconds",
+		Help:    "Time from the creation of a source object, such as a Cluster API Cluster, to the creation of its registry Cluster.",
+		Buckets: []float64{30, 60, 120, 300, 600, 900, 1800, 3600, 7200},
+	}, []string{"source"})
+
+	probeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "clusterregistry_probe_duration_seconds",
+		Help: "Latency of the health probes of registry Clusters.",
+	}, []string{"namespace", "name"})
+
+	probeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "clusterregistry_probe_failures_total",
+		Help: "Number of failed health probes of registry Clusters.",
+	}, []string{"namespace", "name"})
+
+	sourceInvalid = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "clusterregistry_source_invalid_total",
+		Help: "Number of times a source object could not be registered as it is, such as for a kubeconfig that does not parse, by reason.",
+	}, []string{"source", "reason"})
+
+	clustersDesc = prometheus.NewDesc("clusterregistry_clusters",
+		"Number of registry Clusters by condition type and status. Clusters not probed yet count as OK Unknown.",
+		[]string{"condition", "status"}, nil)
+
+	certificateExpiryDesc = prometheus.NewDesc("clusterregistry_certificate_expiry_timestamp_seconds",
+		"When the earliest expiring certificate of a registry Cluster expires: ca for the CA bundle, client for the client certificate of the controller credential.",
+		[]string{"namespace", "name", "certificate"}, nil)
+)
+
+func init() {
+	metrics.Registry.MustRegister(registrationDuration, probeDuration, probeFailures, sourceInvalid)
+}
+
+// Forget the per cluster metrics of a registry Cluster that is gone
+func forgetClusterMetrics(namespace, name string) {
+	probeDuration.DeleteLabelValues(namespace, name)
+	probeFailures.DeleteLabelValues(namespace, name)
+}
+
+// ClusterCollector reports the registry Clusters by condition status and the
+// expiry of their certificates as recorded in their status, read from the
+// manager cache on every scrape
+type ClusterCollector struct {
+	Client client.Client
+	Log    logr.Logger
+}
+
+func (c *ClusterCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- clustersDesc
+	ch <- certificateExpiryDesc
+}
+
+func (c *ClusterCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	list := &clusterregistryv1beta1.ClusterList{}
+	if err := c.Client.List(ctx, list); err != nil {
+		c.Log.Error(err, "unable list Cluster registry for metrics")
+		ch <- prometheus.NewInvalidMetric(clustersDesc, err)
+		return
+	}
+
+	counts := map[[2]string]int{}
+	for i := range list.Items {
+		clusterreg := &list.Items[i]
+		if clusterreg.Status.GetCondition(clusterregistryv1beta1.ClusterOK) == nil {
+			counts[[2]string{string(clusterregistryv1beta1.ClusterOK), string(corev1.ConditionUnknown)}]++
+		}
+		for _, condition := range clusterreg.Status.Conditions {
+			counts[[2]string{string(condition.Type), string(condition.Status)}]++
+		}
+
+		if certificates := clusterreg.Status.Certificates; certificates != nil {
+			if certificates.CANotAfter != nil {
+				ch <- prometheus.MustNewConstMetric(certificateExpiryDesc, prometheus.GaugeValue,
+					float64(certificates.CANotAfter.Unix()), clusterreg.Namespace, clusterreg.Name, certificateCA)
+			}
+			if certificates.ClientNotAfter != nil {
+				ch <- prometheus.MustNewConstMetric(certificateExpiryDesc, prometheus.GaugeValue,
+					float64(certificates.ClientNotAfter.Unix()), clusterreg.Namespace, clusterreg.Name, certificateClient)
+			}
+		}
+	}
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(clustersDesc, prometheus.GaugeValue, float64(count), key[0], key[1])
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+func TestClusterCollector(t *testing.T) {
+	expiry := metav1.NewTime(time.Now().Add(24 * time.Hour).Truncate(time.Second))
+	healthy := exportedCluster()
+	healthy.Status.Conditions = []clusterregistryv1beta1.Condition{
+		{Type: clusterregistryv1beta1.ClusterOK, Status: corev1.ConditionTrue},
+		{Type: clusterregistryv1beta1.SourceReady, Status: corev1.ConditionTrue},
+	}
+	healthy.Status.Certificates = &clusterregistryv1beta1.CertificatesStatus{CANotAfter: &expiry, ClientNotAfter: &expiry}
+	unprobed := &clusterregistryv1beta1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "new", Namespace: "clusters"}}
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(&ClusterCollector{
+		Client: fake.NewFakeClientWithScheme(newExportScheme(t), healthy, unprobed),
+		Log:    ctrl.Log,
+	})
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			name := family.GetName()
+			for _, label := range metric.Label {
+				name += "," + label.GetValue()
+			}
+			values[name] = metric.GetGauge().GetValue()
+		}
+	}
+	for name, want := range map[string]float64{
+		"clusterregistry_clusters,OK,True":                                            1,
+		"clusterregistry_clusters,OK,Unknown":                                         1,
+		"clusterregistry_clusters,SourceReady,True":                                   1,
+		"clusterregistry_certificate_expiry_timestamp_seconds,ca,member,clusters":     float64(expiry.Unix()),
+		"clusterregistry_certificate_expiry_timestamp_seconds,client,member,clusters": float64(expiry.Unix()),
+	} {
+		if got, ok := values[name]; !ok || got != want {
+			t.Errorf("expected %s to be %v, got %v in %v", name, want, got, values)
+		}
+	}
+	if len(values) != 5 {
+		t.Errorf("expected 5 series, got %v", values)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// ReadinessPoliciesKey is the ConfigMap key holding the readiness policies
+const ReadinessPoliciesKey = "policies"
+
+// Reasons reported on the SourceReady condition
+const (
+	ReasonReadinessGatesPassed = "ReadinessGatesPassed"
+	ReasonReadinessGateBlocked = "ReadinessGateBlocked"
+)
+
+// ReadinessPolicy lists the gates a Cluster API cluster must pass to be
+// registered. Gates are checked in order: phase, conditions, annotations.
+type ReadinessPolicy struct {
+	// Phases the cluster may be in, any phase if empty
+	Phases []string `json:"phases,omitempty"`
+	// Conditions that must be True, e.g. Ready or ControlPlaneReady. A
+	// condition the cluster does not report is read from the boolean status
+	// field of the same name, e.g. status.infrastructureReady or
+	// status.controlPlaneInitialized on v1alpha3 clusters.
+	Conditions []string `json:"conditions,omitempty"`
+	// Annotations the cluster must have, with the given value or any value if empty
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Blocking returns the first gate the cluster does not pass and why, or an
+// empty gate if it passes them all
+func (p *ReadinessPolicy) Blocking(cluster *unstructured.Unstructured) (string, string) {
+	if len(p.Phases) > 0 {
+		phase, _, _ := unstructured.NestedString(cluster.Object, "status", "phase")
+		if !containsString(p.Phases, phase) {
+			return "Phase", fmt.Sprintf("phase is %q, not one of %v", phase, p.Phases)
+		}
+	}
+
+	conditions := clusterAPIConditions(cluster)
+	for _, gate := range p.Conditions {
+		if status, ok := conditions[gate]; ok {
+			if status != "True" {
+				return gate, "condition is " + status
+			}
+			continue
+		}
+		value, found, err := unstructured.NestedBool(cluster.Object, "status", lowerFirst(gate))
+		switch {
+		case err != nil:
+			return gate, err.Error()
+		case !found:
+			return gate, "condition is not reported"
+		case !value:
+			return gate, "status is false"
+		}
+	}
+
+	keys := make([]string, 0, len(p.Annotations))
+	for key := range p.Annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, ok := cluster.GetAnnotations()[key]
+		if !ok {
+			return "annotation " + key, "annotation is missing"
+		}
+		if want := p.Annotations[key]; want != "" && value != want {
+			return "annotation " + key, fmt.Sprintf("annotation is %q, not %q", value, want)
+		}
+	}
+	return "", ""
+}
+
+// ReadinessRule applies a policy to the clusters in any of Namespaces and
+// matching Selector; an empty field matches every cluster
+type ReadinessRule struct {
+	Namespaces []string              `json:"namespaces,omitempty"`
+	Selector   *metav1.LabelSelector `json:"selector,omitempty"`
+	Policy     ReadinessPolicy       `json:"policy"`
+
+	selector labels.Selector
+}
+
+// ReadinessPolicies picks the readiness policy of a cluster: the policy of
+// the first matching rule, else Default. Without either, clusters are ready
+// once their Ready conditions are True, or they reach --cluster-phase.
+type ReadinessPolicies struct {
+	Rules   []ReadinessRule  `json:"rules,omitempty"`
+	Default *ReadinessPolicy `json:"default,omitempty"`
+}
+
+// ParseReadinessPolicies reads YAML readiness policies, checking their selectors
+func ParseReadinessPolicies(data string) (*ReadinessPolicies, error) {
+	policies := &ReadinessPolicies{}
+	if err := yaml.UnmarshalStrict([]byte(data), policies); err != nil {
+		return nil, err
+	}
+	for i := range policies.Rules {
+		rule := &policies.Rules[i]
+		rule.selector = labels.Everything()
+		if rule.Selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(rule.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+		rule.selector = selector
+	}
+	return policies, nil
+}
+
+// For returns the readiness policy of a cluster, nil for the built-in one
+func (p *ReadinessPolicies) For(cluster *unstructured.Unstructured) *ReadinessPolicy {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if len(rule.Namespaces) > 0 && !containsString(rule.Namespaces, cluster.GetNamespace()) {
+			continue
+		}
+		if rule.selector != nil && !rule.selector.Matches(labels.Set(cluster.GetLabels())) {
+			continue
+		}
+		return &rule.Policy
+	}
+	return p.Default
+}
+
+// lowerFirst turns a condition type into its status field, e.g.
+// "InfrastructureReady" into "infrastructureReady"
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const readinessPolicies = `
+rules:
+- namespaces: [prod]
+  selector:
+    matchLabels:
+      tier: edge
+  policy:
+    phases: [Provisioned]
+    conditions: [InfrastructureReady, ControlPlaneInitialized]
+    annotations:
+      example.com/approved: "true"
+- namespaces: [prod]
+  policy:
+    conditions: [Ready]
+default:
+  phases: [Provisioned]
+`
+
+func newUnstructuredCluster(namespace string, labels map[string]string, status map[string]interface{}) *unstructured.Unstructured {
+	cluster := &unstructured.Unstructured{Object: map[string]interface{}{"status": status}}
+	cluster.SetNamespace(namespace)
+	cluster.SetLabels(labels)
+	return cluster
+}
+
+func TestParseReadinessPolicies(t *testing.T) {
+	for name, data := range map[string]string{
+		"unknown field": "rules:\n- policy:\n    phase: Provisioned",
+		"bad selector":  "rules:\n- selector:\n    matchExpressions:\n    - key: tier\n      operator: Near\n  policy: {}",
+	} {
+		if _, err := ParseReadinessPolicies(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	policies, err := ParseReadinessPolicies(readinessPolicies)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, tc := range map[string]struct {
+		cluster  *unstructured.Unstructured
+		expected *ReadinessPolicy
+	}{
+		"edge":      {newUnstructuredCluster("prod", map[string]string{"tier": "edge"}, nil), &policies.Rules[0].Policy},
+		"prod":      {newUnstructuredCluster("prod", map[string]string{"tier": "core"}, nil), &policies.Rules[1].Policy},
+		"default":   {newUnstructuredCluster("dev", map[string]string{"tier": "edge"}, nil), policies.Default},
+		"unlabeled": {newUnstructuredCluster("dev", nil, nil), policies.Default},
+	} {
+		if policy := policies.For(tc.cluster); policy != tc.expected {
+			t.Errorf("%s: expected policy %+v, got %+v", name, tc.expected, policy)
+		}
+	}
+
+	if policy := (&ReadinessPolicies{}).For(newUnstructuredCluster("dev", nil, nil)); policy != nil {
+		t.Errorf("expected the built-in policy, got %+v", policy)
+	}
+}
+
+func TestReadinessPolicyBlocking(t *testing.T) {
+	policies, err := ParseReadinessPolicies(readinessPolicies)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	policy := &policies.Rules[0].Policy
+
+	for expected, status := range map[string]map[string]interface{}{
+		"Phase":               {"phase": "Provisioning"},
+		"InfrastructureReady": {"phase": "Provisioned", "infrastructureReady": false},
+		// v1alpha4 and later report conditions instead of status fields
+		"ControlPlaneInitialized": {
+			"phase":               "Provisioned",
+			"infrastructureReady": true,
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "ControlPlaneInitialized", "status": "False"},
+			},
+		},
+		"annotation example.com/approved": {
+			"phase":                   "Provisioned",
+			"infrastructureReady":     true,
+			"controlPlaneInitialized": true,
+		},
+	} {
+		cluster := newUnstructuredCluster("prod", nil, status)
+		if gate, reason := policy.Blocking(cluster); gate != expected {
+			t.Errorf("expected gate %q to block, got %q (%s)", expected, gate, reason)
+		}
+	}
+
+	cluster := newUnstructuredCluster("prod", nil, map[string]interface{}{
+		"phase":                   "Provisioned",
+		"infrastructureReady":     true,
+		"controlPlaneInitialized": true,
+	})
+	cluster.SetAnnotations(map[string]string{"example.com/approved": "true"})
+	if gate, reason := policy.Blocking(cluster); gate != "" {
+		t.Errorf("expected no gate to block, got %q (%s)", gate, reason)
+	}
+}
+
+func TestMissingReadinessPoliciesUseBuiltInCheck(t *testing.T) {
+	s := &ClusterApiSource{
+		Client:            fake.NewFakeClientWithScheme(newExportScheme(t)),
+		Log:               ctrl.Log,
+		ReadinessPolicies: types.NamespacedName{Namespace: "registry", Name: "readiness-policies"},
+	}
+	if keys := s.ConfigMaps(); len(keys) != 1 || keys[0] != s.ReadinessPolicies {
+		t.Errorf("expected the readiness policies ConfigMap to be watched, got %v", keys)
+	}
+
+	cluster := newUnstructuredCluster("prod", nil, map[string]interface{}{"phase": "Provisioning"})
+	ready, reason, err := s.Ready(context.Background(), cluster)
+	if err != nil || ready || reason == "" {
+		t.Errorf("expected the built-in check to block a provisioning cluster, got %v, %q, %v", ready, reason, err)
+	}
+	cluster = newUnstructuredCluster("prod", nil, map[string]interface{}{"phase": Phase})
+	if ready, reason, err := s.Ready(context.Background(), cluster); err != nil || !ready {
+		t.Errorf("expected the built-in check to pass a provisioned cluster, got %v, %q, %v", ready, reason, err)
+	}
+}
@@ -0,0 +1,684 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+const (
+	// defaultInterval is the first requeue delay for a cluster that is not ready yet
+	defaultInterval = 5 * time.Second
+	// defaultMaxInterval caps the requeue backoff for a cluster that is not ready yet
+	defaultMaxInterval = 5 * time.Minute
+)
+
+// ClusterSource discovers clusters from a system of record, such as Cluster
+// API, and describes how each of them is registered in the cluster registry.
+type ClusterSource interface {
+	// Name identifies the source in logs, labels and on the --sources flag.
+	Name() string
+
+	// NewObject returns an empty object of the kind the source discovers
+	// clusters from. Objects of this kind are watched and own the registry
+	// Clusters created for them.
+	NewObject() runtime.Object
+
+	// Watches lists further watches feeding the source, mapped back to the
+	// objects returned by NewObject.
+	Watches() []SourceWatch
+
+	// Ready reports whether the cluster can be registered, and if not, why.
+	Ready(ctx context.Context, obj runtime.Object) (bool, string, error)
+
+	// Describe returns the registry Clusters, with their endpoints and CA
+	// bundle, for a ready object. A NotFound error means some input is not
+	// there yet and the object is retried with backoff.
+	Describe(ctx context.Context, obj runtime.Object) ([]*clusterregistryv1beta1.Cluster, error)
+}
+
+// SelectiveSource is implemented by sources that only discover clusters from
+// some of the objects of their kind, such as labeled secrets. Registry
+// Clusters of an object that is no longer selected are removed.
+type SelectiveSource interface {
+	Selects(obj runtime.Object) bool
+}
+
+// ConfiguredSource is implemented by sources that read ConfigMaps applying to
+// all of their objects, such as policies. The objects are reconciled again
+// when one of them changes.
+type ConfiguredSource interface {
+	ConfigMaps() []client.ObjectKey
+}
+
+// CredentialSource is implemented by sources that can hand out a kubeconfig
+// controllers authenticate to a cluster with. The reconciler keeps it in a
+// Secret owned by the registry Cluster and referenced by its
+// Spec.AuthInfo.Controller, so consumers need not know where it comes from.
+type CredentialSource interface {
+	Credential(ctx context.Context, obj runtime.Object, clusterreg *clusterregistryv1beta1.Cluster) ([]byte, error)
+}
+
+// ReasonRegistrationInvalid is reported for registry Clusters the API server rejects
+const ReasonRegistrationInvalid = "RegistrationInvalid"
+
+// Reasons of the Events recorded on source objects and registry Clusters,
+// besides the reasons of InvalidSourceError
+const (
+	// ReasonRegistrationBlocked: the source object does not pass its readiness
+	// gates yet, e.g. a Cluster API Cluster waiting for its phase
+	ReasonRegistrationBlocked = "RegistrationBlocked"
+	// ReasonWaitingForKubeconfig: the kubeconfig the source object is
+	// registered from does not exist yet, e.g. a missing <name>-kubeconfig Secret
+	ReasonWaitingForKubeconfig = "WaitingForKubeconfig"
+	// ReasonWaitingForCredential: the controller credential cannot be derived yet
+	ReasonWaitingForCredential = "WaitingForCredential"
+	// ReasonRegistered: a registry Cluster was created for the source object
+	ReasonRegistered = "Registered"
+	// ReasonRegistryUpdated: a registry Cluster was updated from the source object
+	ReasonRegistryUpdated = "RegistryUpdated"
+)
+
+// InvalidSourceError is returned by Describe for an object that cannot be
+// registered until it is fixed, such as a malformed kubeconfig. Reason is a
+// CamelCase reason for Events and conditions.
+type InvalidSourceError struct {
+	Reason string
+	Err    error
+}
+
+func (e *InvalidSourceError) Error() string {
+	return e.Err.Error()
+}
+
+// SourceWatch is an additional watch of a ClusterSource.
+type SourceWatch struct {
+	Source  source.Source
+	Handler handler.EventHandler
+}
+
+// SourceFactory builds a ClusterSource running in the given manager, or
+// fails if the source cannot work against the API server.
+type SourceFactory func(mgr ctrl.Manager) (ClusterSource, error)
+
+var sourceFactories = map[string]SourceFactory{}
+
+// RegisterSource makes a source available by name, usually from an init function.
+func RegisterSource(name string, factory SourceFactory) {
+	sourceFactories[name] = factory
+}
+
+// SourceNames returns the names of all registered sources.
+func SourceNames() []string {
+	names := make([]string, 0, len(sourceFactories))
+	for name := range sourceFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewSource builds the registered source with the given name.
+func NewSource(name string, mgr ctrl.Manager) (ClusterSource, error) {
+	factory, ok := sourceFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cluster source %q, known sources are %v", name, SourceNames())
+	}
+	return factory(mgr)
+}
+
+// SourceReconciler registers the clusters discovered by a ClusterSource
+type SourceReconciler struct {
+	Client client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// Recorder reports source problems as Events on the source objects, and
+	// registrations on both the source objects and the registry Clusters
+	Recorder record.EventRecorder
+
+	Source ClusterSource
+
+	// DeletionPolicy applies to registry Clusters the source no longer
+	// describes, unless overridden by their annotation. Defaults to Cascade.
+	DeletionPolicy clusterregistryv1beta1.DeletionPolicy
+
+	// EndpointRules names a ConfigMap of endpoint rules deriving the server
+	// endpoints of registry Clusters. Unset keeps the kubeconfig server.
+	EndpointRules client.ObjectKey
+
+	// Interval is the first requeue delay while a cluster is not ready,
+	// doubled on every further check up to MaxInterval
+	Interval time.Duration
+	// MaxInterval caps the requeue delay
+	MaxInterval time.Duration
+
+	// per cluster backoff, reset once the cluster is registered
+	backoff workqueue.RateLimiter
+}
+
+// +kubebuilder:rbac:groups=clusterregistry.k8s.io,resources=clusters,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile never blocks waiting for a cluster: a cluster that is not ready
+// is requeued with backoff, and readiness changes arrive as watch events.
+func (r *SourceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("source", r.Source.Name(), "cluster", req.NamespacedName)
+
+	obj := r.Source.NewObject()
+	if err := r.Client.Get(ctx, req.NamespacedName, obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.backoff.Forget(req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "unable fetch source cluster")
+		return ctrl.Result{}, err
+	}
+
+	owner, err := meta.Accessor(obj)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if owner.GetDeletionTimestamp() != nil {
+		r.backoff.Forget(req.NamespacedName)
+		return ctrl.Result{}, r.reconcileDelete(ctx, obj, owner)
+	}
+	if selective, ok := r.Source.(SelectiveSource); ok && !selective.Selects(obj) {
+		r.backoff.Forget(req.NamespacedName)
+		if err := r.prune(ctx, owner, nil); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, r.removeFinalizer(ctx, obj, owner)
+	}
+
+	ready, reason, err := r.Source.Ready(ctx, obj)
+	if err != nil {
+		log.Error(err, "unable check source cluster readiness")
+		return ctrl.Result{}, err
+	}
+	if !ready {
+		delay := r.backoff.When(req.NamespacedName)
+		log.Info("Cluster not ready", "reason", reason, "requeueAfter", delay)
+		return ctrl.Result{RequeueAfter: delay}, r.reportBlocked(ctx, obj, owner, reason)
+	}
+
+	log.Info("Cluster ready")
+	desired, err := r.Source.Describe(ctx, obj)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			delay := r.backoff.When(req.NamespacedName)
+			log.Info("Cluster not describable yet", "reason", err.Error(), "requeueAfter", delay)
+			r.Recorder.Event(obj, corev1.EventTypeNormal, ReasonWaitingForKubeconfig, err.Error())
+			return ctrl.Result{RequeueAfter: delay}, nil
+		}
+		if invalid, ok := err.(*InvalidSourceError); ok {
+			delay := r.backoff.When(req.NamespacedName)
+			log.Info("Cluster source invalid", "reason", invalid.Reason, "error", invalid.Error(), "requeueAfter", delay)
+			return ctrl.Result{RequeueAfter: delay}, r.reportInvalid(ctx, obj, owner, invalid)
+		}
+		log.Error(err, "unable describe source cluster")
+		return ctrl.Result{}, err
+	}
+	if err := r.applyEndpointRules(ctx, obj, owner, desired); err != nil {
+		if invalid, ok := err.(*InvalidSourceError); ok {
+			delay := r.backoff.When(req.NamespacedName)
+			log.Info("Cluster endpoint rules invalid", "error", invalid.Error(), "requeueAfter", delay)
+			return ctrl.Result{RequeueAfter: delay}, r.reportInvalid(ctx, obj, owner, invalid)
+		}
+		log.Error(err, "unable derive Cluster registry endpoints")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.addFinalizer(ctx, obj, owner); err != nil {
+		return ctrl.Result{}, err
+	}
+	credentials, hasCredentials := r.Source.(CredentialSource)
+	for _, want := range desired {
+		if hasCredentials {
+			want.Spec.AuthInfo.Controller = &clusterregistryv1beta1.ObjectReference{
+				Kind:      "Secret",
+				Name:      want.Name + credentialSuffix,
+				Namespace: want.Namespace,
+			}
+		}
+		clusterreg, err := r.createOrUpdate(ctx, owner, want)
+		if apierrors.IsInvalid(err) {
+			// rejected by validation, e.g. a CA bundle that is not PEM
+			delay := r.backoff.When(req.NamespacedName)
+			log.Info("Cluster registry invalid", "error", err.Error(), "requeueAfter", delay)
+			return ctrl.Result{RequeueAfter: delay}, r.reportInvalid(ctx, obj, owner, &InvalidSourceError{Reason: ReasonRegistrationInvalid, Err: err})
+		}
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if hasCredentials {
+			if err := r.syncCredential(ctx, obj, clusterreg, credentials); err != nil {
+				if apierrors.IsNotFound(err) {
+					delay := r.backoff.When(req.NamespacedName)
+					log.Info("Cluster credential not available yet", "reason", err.Error(), "requeueAfter", delay)
+					r.Recorder.Event(obj, corev1.EventTypeNormal, ReasonWaitingForCredential, err.Error())
+					return ctrl.Result{RequeueAfter: delay}, nil
+				}
+				log.Error(err, "unable sync Cluster registry credential")
+				return ctrl.Result{}, err
+			}
+		}
+		if err := r.setCondition(ctx, clusterreg, clusterregistryv1beta1.KubeconfigValid, corev1.ConditionTrue, "KubeconfigResolved", "kubeconfig resolved to the registered endpoints"); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.setCondition(ctx, clusterreg, clusterregistryv1beta1.SourceReady, corev1.ConditionTrue, ReasonReadinessGatesPassed, "the source passes all readiness gates"); err != nil {
+			return ctrl.Result{}, err
+		}
+		if c := clusterreg.Status.GetCondition(clusterregistryv1beta1.Decommissioned); c != nil && c.Status == corev1.ConditionTrue {
+			if err := r.setCondition(ctx, clusterreg, clusterregistryv1beta1.Decommissioned, corev1.ConditionFalse, "SourceRestored", "the source describes this cluster again"); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+	if err := r.prune(ctx, owner, desired); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	r.backoff.Forget(req.NamespacedName)
+	return ctrl.Result{}, nil
+}
+
+// Create the registry Cluster, or patch its endpoints, CA bundle and the
+// labels and annotations set by the source when they no longer match
+func (r *SourceReconciler) createOrUpdate(ctx context.Context, owner metav1.Object, desired *clusterregistryv1beta1.Cluster) (*clusterregistryv1beta1.Cluster, error) {
+	log := r.Log.WithValues("source", r.Source.Name(), "ClusterRegistry", client.ObjectKey{Namespace: desired.Namespace, Name: desired.Name})
+
+	// compare with what the defaulting webhook would store
+	desired.Default()
+	if desired.Labels == nil {
+		desired.Labels = map[string]string{}
+	}
+	desired.Labels[clusterregistryv1beta1.SourceLabel] = r.Source.Name()
+	if desired.Annotations == nil {
+		desired.Annotations = map[string]string{}
+	}
+	delete(desired.Annotations, clusterregistryv1beta1.SourceLabelsAnnotation)
+	delete(desired.Annotations, clusterregistryv1beta1.SourceAnnotationsAnnotation)
+	desired.Annotations[clusterregistryv1beta1.SourceAnnotationsAnnotation] = joinKeys(desired.Annotations)
+	desired.Annotations[clusterregistryv1beta1.SourceLabelsAnnotation] = joinKeys(desired.Labels)
+	if err := controllerutil.SetControllerReference(owner, desired, r.Scheme); err != nil {
+		return nil, err
+	}
+	gvk, err := apiutil.GVKForObject(owner.(runtime.Object), r.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	desired.Spec.Source = &clusterregistryv1beta1.SourceReference{
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+		Namespace:  owner.GetNamespace(),
+		Name:       owner.GetName(),
+		UID:        owner.GetUID(),
+	}
+
+	clusterreg := &clusterregistryv1beta1.Cluster{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: desired.Namespace, Name: desired.Name}, clusterreg); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Error(err, "unable fetch Cluster registry")
+			return nil, err
+		}
+		log.Info("Create Cluster registry")
+		if err := r.Client.Create(ctx, desired); err != nil {
+			log.Error(err, "Create Cluster registry fail")
+			return nil, err
+		}
+		registrationDuration.WithLabelValues(r.Source.Name()).Observe(time.Since(owner.GetCreationTimestamp().Time).Seconds())
+		r.recordRegistration(owner, desired, ReasonRegistered, "Registered")
+		return desired, nil
+	}
+
+	patch := client.MergeFrom(clusterreg.DeepCopy())
+	changed := !apiequality.Semantic.DeepEqual(clusterreg.Spec.KubernetesAPIEndpoints, desired.Spec.KubernetesAPIEndpoints)
+	if metav1.GetControllerOf(clusterreg) == nil {
+		// adopt an entry orphaned or retained earlier, e.g. when a context comes back
+		log.Info("Adopt Cluster registry")
+		clusterreg.OwnerReferences = append(clusterreg.OwnerReferences, desired.OwnerReferences...)
+		changed = true
+	}
+	clusterreg.Spec.KubernetesAPIEndpoints = desired.Spec.KubernetesAPIEndpoints
+	if !apiequality.Semantic.DeepEqual(clusterreg.Spec.Source, desired.Spec.Source) {
+		clusterreg.Spec.Source = desired.Spec.Source
+		changed = true
+	}
+	if desired.Spec.AuthInfo.Controller != nil && !apiequality.Semantic.DeepEqual(clusterreg.Spec.AuthInfo.Controller, desired.Spec.AuthInfo.Controller) {
+		clusterreg.Spec.AuthInfo.Controller = desired.Spec.AuthInfo.Controller
+		changed = true
+	}
+	ownedLabels := splitKeys(clusterreg.Annotations[clusterregistryv1beta1.SourceLabelsAnnotation])
+	ownedAnnotations := splitKeys(clusterreg.Annotations[clusterregistryv1beta1.SourceAnnotationsAnnotation])
+	clusterreg.Labels, changed = mergeStrings(clusterreg.Labels, desired.Labels, ownedLabels, changed)
+	clusterreg.Annotations, changed = mergeStrings(clusterreg.Annotations, desired.Annotations, ownedAnnotations, changed)
+	if !changed {
+		log.Info("Cluster registry up to date")
+		return clusterreg, nil
+	}
+
+	log.Info("Update Cluster registry")
+	if err := r.Client.Patch(ctx, clusterreg, patch); err != nil {
+		log.Error(err, "Update Cluster registry fail")
+		return nil, err
+	}
+	r.recordRegistration(owner, clusterreg, ReasonRegistryUpdated, "Updated")
+	return clusterreg, nil
+}
+
+// Record a registration on both the source object and the registry Cluster
+func (r *SourceReconciler) recordRegistration(owner metav1.Object, clusterreg *clusterregistryv1beta1.Cluster, reason, verb string) {
+	if obj, ok := owner.(runtime.Object); ok {
+		r.Recorder.Eventf(obj, corev1.EventTypeNormal, reason, "%s registry Cluster %s", verb, clusterreg.Name)
+	}
+	r.Recorder.Eventf(clusterreg, corev1.EventTypeNormal, reason, "%s from %s %s/%s",
+		verb, clusterreg.Spec.Source.Kind, clusterreg.Spec.Source.Namespace, clusterreg.Spec.Source.Name)
+}
+
+// Set the keys of from in into, and remove the keys the source owned before
+// but no longer sets, reporting whether anything changed
+func mergeStrings(into, from map[string]string, owned []string, changed bool) (map[string]string, bool) {
+	for _, k := range owned {
+		if _, ok := into[k]; !ok {
+			continue
+		}
+		if _, ok := from[k]; !ok {
+			delete(into, k)
+			changed = true
+		}
+	}
+	for k, v := range from {
+		if old, ok := into[k]; ok && old == v {
+			continue
+		}
+		if into == nil {
+			into = map[string]string{}
+		}
+		into[k] = v
+		changed = true
+	}
+	return into, changed
+}
+
+// The sorted, comma separated keys of a map
+func joinKeys(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// The keys joined by joinKeys
+func splitKeys(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// Set a condition of the registry Cluster, writing status only when it changes
+func (r *SourceReconciler) setCondition(ctx context.Context, clusterreg *clusterregistryv1beta1.Cluster, conditionType clusterregistryv1beta1.ClusterConditionType, status corev1.ConditionStatus, reason, message string) error {
+	if c := clusterreg.Status.GetCondition(conditionType); c != nil &&
+		c.Status == status && c.Reason == reason && c.Message == message {
+		return nil
+	}
+	clusterreg.Status.SetCondition(clusterregistryv1beta1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: clusterreg.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+	return r.Client.Status().Update(ctx, clusterreg)
+}
+
+// Report an object the source cannot register as it is: a Warning Event on
+// the object and a False KubeconfigValid condition and Warning Event on the
+// registry Clusters already registered from it, which keep their last good endpoints
+func (r *SourceReconciler) reportInvalid(ctx context.Context, obj runtime.Object, owner metav1.Object, invalid *InvalidSourceError) error {
+	r.Recorder.Event(obj, corev1.EventTypeWarning, invalid.Reason, invalid.Error())
+	sourceInvalid.WithLabelValues(r.Source.Name(), invalid.Reason).Inc()
+
+	owned, err := r.owned(ctx, owner)
+	if err != nil {
+		return err
+	}
+	for _, clusterreg := range owned {
+		r.Recorder.Event(clusterreg, corev1.EventTypeWarning, invalid.Reason, invalid.Error())
+		if err := r.setCondition(ctx, clusterreg, clusterregistryv1beta1.KubeconfigValid, corev1.ConditionFalse, invalid.Reason, invalid.Error()); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+	}
+	return nil
+}
+
+// Report a source object that does not pass its readiness gates: an Event
+// on the object, and a False SourceReady condition on the registry Clusters
+// already registered from it, which are left as they are
+func (r *SourceReconciler) reportBlocked(ctx context.Context, obj runtime.Object, owner metav1.Object, reason string) error {
+	r.Recorder.Event(obj, corev1.EventTypeNormal, ReasonRegistrationBlocked, reason)
+
+	owned, err := r.owned(ctx, owner)
+	if err != nil {
+		return err
+	}
+	for _, clusterreg := range owned {
+		if err := r.setCondition(ctx, clusterreg, clusterregistryv1beta1.SourceReady, corev1.ConditionFalse, ReasonReadinessGateBlocked, reason); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+	}
+	return nil
+}
+
+// List the registry Clusters this source created for owner
+func (r *SourceReconciler) owned(ctx context.Context, owner metav1.Object) ([]*clusterregistryv1beta1.Cluster, error) {
+	list := &clusterregistryv1beta1.ClusterList{}
+	if err := r.Client.List(ctx, list,
+		client.InNamespace(owner.GetNamespace()),
+		client.MatchingLabels{clusterregistryv1beta1.SourceLabel: r.Source.Name()}); err != nil {
+		return nil, err
+	}
+	owned := []*clusterregistryv1beta1.Cluster{}
+	for i := range list.Items {
+		if ref := metav1.GetControllerOf(&list.Items[i]); ref != nil && ref.UID == owner.GetUID() {
+			owned = append(owned, &list.Items[i])
+		}
+	}
+	return owned, nil
+}
+
+// Apply the deletion policy to registry Clusters this source created for
+// owner that it no longer describes
+func (r *SourceReconciler) prune(ctx context.Context, owner metav1.Object, desired []*clusterregistryv1beta1.Cluster) error {
+	keep := map[string]bool{}
+	for _, clusterreg := range desired {
+		keep[clusterreg.Name] = true
+	}
+
+	owned, err := r.owned(ctx, owner)
+	if err != nil {
+		return err
+	}
+	for _, clusterreg := range owned {
+		if keep[clusterreg.Name] {
+			continue
+		}
+		if err := r.release(ctx, owner, clusterreg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Setup method for controller
+func (r *SourceReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	if r.Interval <= 0 {
+		r.Interval = defaultInterval
+	}
+	if r.MaxInterval <= 0 {
+		r.MaxInterval = defaultMaxInterval
+	}
+	if r.MaxInterval < r.Interval {
+		r.MaxInterval = r.Interval
+	}
+	r.backoff = workqueue.NewItemExponentialFailureRateLimiter(r.Interval, r.MaxInterval)
+
+	// source objects band with the cluster-registry entries they own
+	builder := ctrl.NewControllerManagedBy(mgr).
+		Named(r.Source.Name()).
+		For(r.Source.NewObject()).
+		Owns(&clusterregistryv1beta1.Cluster{})
+	for _, w := range r.Source.Watches() {
+		builder = builder.Watches(w.Source, w.Handler)
+	}
+	if len(r.configMaps()) > 0 {
+		builder = builder.Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.configMapToSources),
+		})
+	}
+	if selective, ok := r.Source.(SelectiveSource); ok {
+		filter, err := r.selectedObjects(selective)
+		if err != nil {
+			return err
+		}
+		builder = builder.WithEventFilter(filter)
+	}
+	return builder.WithOptions(options).Complete(r)
+}
+
+// Filter the events of the source's own objects to those it selects, keeping
+// the update of an object it no longer selects and objects still holding the
+// source finalizer, so their registry Clusters are removed. Events of other
+// kinds pass.
+func (r *SourceReconciler) selectedObjects(selective SelectiveSource) (predicate.Funcs, error) {
+	gvk, err := apiutil.GVKForObject(r.Source.NewObject(), r.Scheme)
+	if err != nil {
+		return predicate.Funcs{}, err
+	}
+	selected := func(meta metav1.Object, obj runtime.Object) bool {
+		if objGVK, err := apiutil.GVKForObject(obj, r.Scheme); err != nil || objGVK != gvk {
+			return true
+		}
+		return selective.Selects(obj) || containsString(meta.GetFinalizers(), clusterregistryv1beta1.SourceFinalizer)
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return selected(e.Meta, e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return selected(e.MetaOld, e.ObjectOld) || selected(e.MetaNew, e.ObjectNew)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return selected(e.Meta, e.Object)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return selected(e.Meta, e.Object)
+		},
+	}, nil
+}
+
+// The ConfigMaps configuring every object of the source
+func (r *SourceReconciler) configMaps() []client.ObjectKey {
+	keys := []client.ObjectKey{}
+	if r.EndpointRules.Name != "" {
+		keys = append(keys, r.EndpointRules)
+	}
+	if configured, ok := r.Source.(ConfiguredSource); ok {
+		keys = append(keys, configured.ConfigMaps()...)
+	}
+	return keys
+}
+
+// Map a ConfigMap configuring the source to all of its objects
+func (r *SourceReconciler) configMapToSources(o handler.MapObject) []ctrl.Request {
+	key := client.ObjectKey{Namespace: o.Meta.GetNamespace(), Name: o.Meta.GetName()}
+	for _, configMap := range r.configMaps() {
+		if key != configMap {
+			continue
+		}
+		requests, err := r.sourceRequests(context.Background())
+		if err != nil {
+			r.Log.Error(err, "unable list sources", "source", r.Source.Name(), "configMap", key)
+		}
+		return requests
+	}
+	return nil
+}
+
+// Requests for every object of the source it selects
+func (r *SourceReconciler) sourceRequests(ctx context.Context) ([]ctrl.Request, error) {
+	obj := r.Source.NewObject()
+	gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	gvk.Kind += "List"
+	var list runtime.Object
+	if _, ok := obj.(*unstructured.Unstructured); ok {
+		unstructuredList := &unstructured.UnstructuredList{}
+		unstructuredList.SetGroupVersionKind(gvk)
+		list = unstructuredList
+	} else if list, err = r.Scheme.New(gvk); err != nil {
+		return nil, err
+	}
+	if err := r.Client.List(ctx, list); err != nil {
+		return nil, err
+	}
+
+	requests := []ctrl.Request{}
+	selective, isSelective := r.Source.(SelectiveSource)
+	err = meta.EachListItem(list, func(item runtime.Object) error {
+		if isSelective && !selective.Selects(item) {
+			return nil
+		}
+		accessor, err := meta.Accessor(item)
+		if err != nil {
+			return err
+		}
+		requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKey{Namespace: accessor.GetNamespace(), Name: accessor.GetName()}})
+		return nil
+	})
+	return requests, err
+}
@@ -0,0 +1,33 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cluster sources", func() {
+	It("registers the Cluster API source", func() {
+		Expect(SourceNames()).To(ContainElement(ClusterApiSourceName))
+	})
+
+	It("rejects an unknown source", func() {
+		_, err := NewSource("does-not-exist", nil)
+		Expect(err).To(MatchError(ContainSubstring("unknown cluster source")))
+	})
+})
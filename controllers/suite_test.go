@@ -17,17 +17,23 @@ limitations under the License.
 package controllers
 
 import (
+	"go/build"
 	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
-	clusterregistryv1alpha1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1alpha1"
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
 
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -40,6 +46,7 @@ import (
 var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
+var stopMgr chan struct{}
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -54,7 +61,10 @@ var _ = BeforeSuite(func(done Done) {
 
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
-		CRDDirectoryPaths: []string{filepath.Join("..", "config", "crd", "bases")},
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "config", "crd", "bases"),
+			filepath.Join(build.Default.GOPATH, "pkg", "mod", "sigs.k8s.io", "cluster-api@v0.3.2", "config", "crd", "bases"),
+		},
 	}
 
 	var err error
@@ -62,7 +72,10 @@ var _ = BeforeSuite(func(done Done) {
 	Expect(err).ToNot(HaveOccurred())
 	Expect(cfg).ToNot(BeNil())
 
-	err = clusterregistryv1alpha1.AddToScheme(scheme.Scheme)
+	err = clusterregistryv1beta1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = clusterv1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
 
 	// +kubebuilder:scaffold:scheme
@@ -71,11 +84,57 @@ var _ = BeforeSuite(func(done Done) {
 	Expect(err).ToNot(HaveOccurred())
 	Expect(k8sClient).ToNot(BeNil())
 
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{Scheme: scheme.Scheme, MetricsBindAddress: "0"})
+	Expect(err).ToNot(HaveOccurred())
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(cfg)
+	Expect(err).ToNot(HaveOccurred())
+	version, err := DetectClusterAPIVersion(discoveryClient)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(version).To(Equal(clusterv1.GroupVersion.Version))
+
+	// a single worker, so a reconcile that blocks on one cluster starves all others
+	err = (&SourceReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName(ClusterApiSourceName),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("cluster-registry-controller"),
+		Source:   &ClusterApiSource{Client: mgr.GetClient(), Log: ctrl.Log.WithName("sources").WithName("Cluster-Api"), Version: version},
+		Interval: time.Second,
+	}).SetupWithManager(mgr, controller.Options{MaxConcurrentReconciles: 1})
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&SourceReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName(KubeconfigSecretSourceName),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("cluster-registry-controller"),
+		Source:   &KubeconfigSecretSource{Client: mgr.GetClient(), Log: ctrl.Log.WithName("sources").WithName("Kubeconfig-Secret")},
+		Interval: time.Second,
+	}).SetupWithManager(mgr, controller.Options{})
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&ClusterReconciler{
+		Client:          mgr.GetClient(),
+		Log:             ctrl.Log.WithName("controllers").WithName("Cluster-Registry"),
+		Scheme:          mgr.GetScheme(),
+		HeartbeatPeriod: time.Second,
+		ProbeTimeout:    time.Second,
+	}).SetupWithManager(mgr, controller.Options{})
+	Expect(err).ToNot(HaveOccurred())
+
+	stopMgr = make(chan struct{})
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(stopMgr)).To(Succeed())
+	}()
+
 	close(done)
 }, 60)
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
+	close(stopMgr)
 	err := testEnv.Stop()
 	Expect(err).ToNot(HaveOccurred())
 })
@@ -4,11 +4,16 @@ go 1.13
 
 require (
 	github.com/go-logr/logr v0.1.0
+	github.com/google/gofuzz v1.1.0
 	github.com/onsi/ginkgo v1.12.0
 	github.com/onsi/gomega v1.9.0
+	github.com/prometheus/client_golang v1.5.0
+	github.com/spf13/pflag v1.0.5
 	k8s.io/api v0.17.2
+	k8s.io/apiextensions-apiserver v0.17.2
 	k8s.io/apimachinery v0.17.2
 	k8s.io/client-go v0.17.2
 	sigs.k8s.io/cluster-api v0.3.2
 	sigs.k8s.io/controller-runtime v0.5.1
+	sigs.k8s.io/yaml v1.2.0
 )
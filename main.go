@@ -18,19 +18,24 @@ package main
 
 import (
 	clusterregistryv1alpha1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1alpha1"
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
 
 	"flag"
-	"k8s.io/client-go/util/workqueue"
+	"net"
 	"os"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
-	"time"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	"github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/controllers"
+	"github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/options"
+	"github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/restapi"
 
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
-	clusterv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -47,44 +52,75 @@ func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 
 	_ = clusterregistryv1alpha1.AddToScheme(scheme)
-
-	_ = clusterv1alpha3.AddToScheme(scheme)
+	_ = clusterregistryv1beta1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
-	var metricsAddr string
-	var enableLeaderElection bool
-	var concurrent int
-	var interval int
-
-	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
-	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
-		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
-	flag.StringVar(&controllers.Phase,"cluster-phase","Provisioned","The Phase of cluster-phase.")
-	flag.IntVar(&concurrent,"concurrency number",10,"The number of controller run")
-	flag.IntVar(&interval,"Log interval time",5,"The time of log output")
-
-	flag.Parse()
-
-
 	ctrl.SetLogger(zap.New(func(o *zap.Options) {
 		o.Development = true
 	}))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		LeaderElection:     enableLeaderElection,
-		Port:               9443,
-	})
+	config, err := options.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		setupLog.Error(err, "invalid configuration")
+		os.Exit(1)
+	}
+	// webhooks need serving certificates, so they can be turned off for local runs
+	if os.Getenv("ENABLE_WEBHOOKS") == "false" {
+		config.Webhook.Enabled = false
+	}
+	controllers.Phase = config.Sources.ClusterPhase
+
+	mgrOptions := ctrl.Options{
+		Scheme:                  scheme,
+		MetricsBindAddress:      config.MetricsBindAddress,
+		HealthProbeBindAddress:  config.HealthProbeBindAddress,
+		LeaderElection:          config.LeaderElection.LeaderElect,
+		LeaderElectionNamespace: config.LeaderElection.ResourceNamespace,
+		LeaderElectionID:        config.LeaderElection.ResourceName,
+		Host:                    config.Webhook.Host,
+		Port:                    config.Webhook.Port,
+		CertDir:                 config.Webhook.CertDir,
+	}
+	if len(config.Namespaces) == 1 {
+		mgrOptions.Namespace = config.Namespaces[0]
+	} else if len(config.Namespaces) > 1 {
+		mgrOptions.NewCache = cache.MultiNamespacedCacheBuilder(config.Namespaces)
+	}
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), mgrOptions)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	if config.Webhook.Enabled {
+		if err = (&clusterregistryv1beta1.Cluster{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Cluster")
+			os.Exit(1)
+		}
+		if err = (&clusterregistryv1alpha1.Cluster{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Cluster", "version", "v1alpha1")
+			os.Exit(1)
+		}
+	}
+
+	// the configuration is validated, so parsing its fields again cannot fail
+	clientIP := net.ParseIP(config.ClientIP)
 	setupChecks(mgr)
-	setupReconcilers(mgr,concurrent,interval)
+	setupMetrics(mgr)
+	setupReconcilers(mgr, config, clientIP)
+	if len(config.Exporters.Enabled) > 0 {
+		setupExporters(mgr, &config.Exporters, clientIP)
+	}
+	setupStaleHoldRelease(mgr, config)
+	if config.RegistryAPI.BindAddress != "" {
+		setupRegistryAPI(mgr, &restapi.Server{
+			Addr:     config.RegistryAPI.BindAddress,
+			CertFile: config.RegistryAPI.TLSCertFile,
+			KeyFile:  config.RegistryAPI.TLSKeyFile,
+		})
+	}
 
 	// +kubebuilder:scaffold:builder
 
@@ -96,28 +132,158 @@ func main() {
 }
 
 // set Reconciler
-func setupReconcilers(mgr ctrl.Manager,concurrent int,interval int) {
-
+func setupReconcilers(mgr ctrl.Manager, config *options.ManagerConfiguration, clientIP net.IP) {
 	if err := (&controllers.ClusterReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Cluster-Registry"),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+		Client:                  mgr.GetClient(),
+		Log:                     ctrl.Log.WithName("controllers").WithName("Cluster-Registry"),
+		Scheme:                  mgr.GetScheme(),
+		HeartbeatPeriod:         config.Cluster.HeartbeatPeriod.Duration,
+		InventoryPeriod:         config.Cluster.InventoryPeriod.Duration,
+		CertificateExpiryWindow: config.Cluster.CertificateExpiryWindow.Duration,
+		ClientIP:                clientIP,
+		Recorder:                mgr.GetEventRecorderFor("cluster-registry-controller"),
+	}).SetupWithManager(mgr, concurrency(config.Cluster.Concurrency)); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Cluster")
 		os.Exit(1)
 	}
+	if err := (&controllers.ClusterSetReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("ClusterSet"),
+	}).SetupWithManager(mgr, concurrency(config.Cluster.Concurrency)); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterSet")
+		os.Exit(1)
+	}
 
-	if err := (&controllers.ClusterApiReconciler{
-		Client:         mgr.GetClient(),
-		Log:            ctrl.Log.WithName("controllers").WithName("Cluster-Api"),
-		Scheme:         mgr.GetScheme(),
-		Workqueue:      workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
-		Interval:       time.Duration(interval),
-	}).SetupWithManager(mgr, concurrency(concurrent)); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Cluster")
+	policy, _ := controllers.ParseDeletionPolicy(config.Sources.DeletionPolicy)
+	endpointRules, _ := options.ParseObjectKey(config.Sources.EndpointRules)
+	bootstrap, _ := options.ParseObjectKey(config.Sources.BootstrapTemplate)
+	readiness, _ := options.ParseObjectKey(config.Sources.ReadinessPolicies)
+	selector, _ := labels.Parse(config.Sources.ClusterSelector)
+
+	// one registering controller per enabled cluster source
+	for _, name := range config.Sources.Enabled {
+		source, err := controllers.NewSource(name, mgr)
+		if err != nil {
+			setupLog.Error(err, "unable to create cluster source")
+			os.Exit(1)
+		}
+		capi, isClusterApi := source.(*controllers.ClusterApiSource)
+		if isClusterApi {
+			capi.ReadinessPolicies = readiness
+			capi.Selector = selector
+			capi.PropagateLabels = config.Sources.PropagateLabels
+			capi.PropagateAnnotations = config.Sources.PropagateAnnotations
+		}
+		if isClusterApi && bootstrap.Name != "" {
+			capi.Bootstrap = &controllers.ServiceAccountBootstrap{
+				Client:   mgr.GetClient(),
+				Log:      ctrl.Log.WithName("bootstrap"),
+				Template: bootstrap,
+			}
+			if err := (&controllers.ServiceAccountRevoker{
+				Client:    mgr.GetClient(),
+				Log:       ctrl.Log.WithName("controllers").WithName("ServiceAccount-Revoker"),
+				Recorder:  mgr.GetEventRecorderFor("cluster-registry-controller"),
+				Bootstrap: capi.Bootstrap,
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "ServiceAccountRevoker")
+				os.Exit(1)
+			}
+		}
+		if err := (&controllers.SourceReconciler{
+			Client:         mgr.GetClient(),
+			Log:            ctrl.Log.WithName("controllers").WithName(source.Name()),
+			Scheme:         mgr.GetScheme(),
+			Recorder:       mgr.GetEventRecorderFor("cluster-registry-controller"),
+			Source:         source,
+			Interval:       config.Sources.RequeueInterval.Duration,
+			DeletionPolicy: policy,
+			EndpointRules:  endpointRules,
+		}).SetupWithManager(mgr, concurrency(config.Sources.Concurrency)); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", source.Name())
+			os.Exit(1)
+		}
+	}
+
+}
+
+// one exporting controller per enabled exporter
+func setupExporters(mgr ctrl.Manager, config *options.ExportersConfiguration, clientIP net.IP) {
+	for _, name := range config.Enabled {
+		exporter, err := controllers.NewExporter(name, mgr)
+		if err != nil {
+			setupLog.Error(err, "unable to create cluster exporter")
+			os.Exit(1)
+		}
+		if argocd, ok := exporter.(*controllers.ArgoCDExporter); ok {
+			argocd.Namespace = config.ArgoCDNamespace
+			argocd.ClientIP = clientIP
+		}
+		if kubeconfig, ok := exporter.(*controllers.KubeconfigExporter); ok {
+			kubeconfig.Namespace = config.KubeconfigNamespace
+			kubeconfig.Key = config.KubeconfigKey
+			if config.KubeconfigClientCIDR != "" {
+				_, kubeconfig.ClientCIDR, _ = net.ParseCIDR(config.KubeconfigClientCIDR)
+			}
+		}
+		if err := (&controllers.ExportReconciler{
+			Client:   mgr.GetClient(),
+			Log:      ctrl.Log.WithName("controllers").WithName("export-" + exporter.Name()),
+			Exporter: exporter,
+		}).SetupWithManager(mgr, concurrency(config.Concurrency)); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "export-"+exporter.Name())
+			os.Exit(1)
+		}
+	}
+}
+
+// release the registry Clusters and sources held by sources, exporters and
+// the ServiceAccount bootstrap of an earlier configuration
+func setupStaleHoldRelease(mgr ctrl.Manager, config *options.ManagerConfiguration) {
+	bootstrap := false
+	for _, name := range config.Sources.Enabled {
+		if name == controllers.ClusterApiSourceName {
+			bootstrap = config.Sources.BootstrapTemplate != ""
+		}
+	}
+	if err := mgr.Add(&controllers.StaleHoldRelease{
+		Client:    mgr.GetClient(),
+		Reader:    mgr.GetAPIReader(),
+		Log:       ctrl.Log.WithName("stale-hold-release"),
+		Sources:   config.Sources.Enabled,
+		Exporters: config.Exporters.Enabled,
+		Bootstrap: bootstrap,
+	}); err != nil {
+		setupLog.Error(err, "unable to create stale hold release")
 		os.Exit(1)
 	}
+}
 
+// serve the read-only registry API from the manager's cache
+func setupRegistryAPI(mgr ctrl.Manager, server *restapi.Server) {
+	hub, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create registry API")
+		os.Exit(1)
+	}
+	server.Reader = mgr.GetClient()
+	server.Hub = hub
+	server.Log = ctrl.Log.WithName("registry-api")
+	if err := mgr.Add(server); err != nil {
+		setupLog.Error(err, "unable to create registry API")
+		os.Exit(1)
+	}
+}
+
+// registry metrics, read from the manager cache on every scrape
+func setupMetrics(mgr ctrl.Manager) {
+	if err := metrics.Registry.Register(&controllers.ClusterCollector{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("metrics"),
+	}); err != nil {
+		setupLog.Error(err, "unable to register metrics")
+		os.Exit(1)
+	}
 }
 
 // health check
@@ -135,4 +301,4 @@ func setupChecks(mgr ctrl.Manager) {
 
 func concurrency(c int) controller.Options {
 	return controller.Options{MaxConcurrentReconciles: c}
-}
\ No newline at end of file
+}
@@ -0,0 +1,514 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package options holds the configuration of the cluster registry manager,
+// given as flags and an optional configuration file
+package options
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+	"github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/controllers"
+)
+
+// APIVersion and Kind of configuration files
+const (
+	APIVersion = "config.clusterregistry.k8s.io/v1alpha1"
+	Kind       = "ManagerConfiguration"
+)
+
+// ManagerConfiguration configures the cluster registry manager. Every field
+// has a flag; flags given on the command line win over the configuration file.
+type ManagerConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// MetricsBindAddress is the address the metrics endpoint binds to, "0" to disable it
+	MetricsBindAddress string `json:"metricsBindAddress,omitempty"`
+
+	// HealthProbeBindAddress is the address /healthz and /readyz are served on,
+	// "0" to disable them
+	HealthProbeBindAddress string `json:"healthProbeBindAddress,omitempty"`
+
+	// Namespaces the manager watches, all namespaces when empty
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// ClientIP is the IP the manager reaches registered clusters from,
+	// picking their server endpoint
+	ClientIP string `json:"clientIP,omitempty"`
+
+	Webhook        WebhookConfiguration        `json:"webhook"`
+	LeaderElection LeaderElectionConfiguration `json:"leaderElection"`
+	Cluster        ClusterConfiguration        `json:"cluster"`
+	Sources        SourcesConfiguration        `json:"sources"`
+	Exporters      ExportersConfiguration      `json:"exporters"`
+	RegistryAPI    RegistryAPIConfiguration    `json:"registryAPI"`
+}
+
+// WebhookConfiguration configures the admission and conversion webhook server
+type WebhookConfiguration struct {
+	// Enabled serves the webhooks, which need serving certificates
+	Enabled bool `json:"enabled"`
+	// Host the webhook server binds to, all interfaces when empty
+	Host string `json:"host,omitempty"`
+	// Port the webhook server binds to
+	Port int `json:"port,omitempty"`
+	// CertDir holds the tls.crt and tls.key of the webhook server
+	CertDir string `json:"certDir,omitempty"`
+}
+
+// LeaderElectionConfiguration configures leader election among manager replicas
+type LeaderElectionConfiguration struct {
+	// LeaderElect enables leader election, so only one replica is active
+	LeaderElect bool `json:"leaderElect"`
+	// ResourceNamespace holds the election lock, the namespace of the manager when empty
+	ResourceNamespace string `json:"resourceNamespace,omitempty"`
+	// ResourceName names the election lock
+	ResourceName string `json:"resourceName,omitempty"`
+}
+
+// ClusterConfiguration configures the controller probing registry Clusters
+type ClusterConfiguration struct {
+	// Concurrency is the number of registry Clusters reconciled in parallel
+	Concurrency int `json:"concurrency,omitempty"`
+	// HeartbeatPeriod is how often registered clusters are probed for health
+	HeartbeatPeriod metav1.Duration `json:"heartbeatPeriod,omitempty"`
+	// InventoryPeriod is how often the inventory of reachable clusters is collected
+	InventoryPeriod metav1.Duration `json:"inventoryPeriod,omitempty"`
+	// CertificateExpiryWindow is how long before a certificate expires the
+	// CertificateExpiring condition of its registry Cluster turns True
+	CertificateExpiryWindow metav1.Duration `json:"certificateExpiryWindow,omitempty"`
+}
+
+// SourcesConfiguration configures the controllers registering clusters
+type SourcesConfiguration struct {
+	// Enabled lists the cluster sources to register clusters from
+	Enabled []string `json:"enabled,omitempty"`
+	// Concurrency is the number of source objects each source reconciles in parallel
+	Concurrency int `json:"concurrency,omitempty"`
+	// RequeueInterval is the first delay before source objects that are not
+	// ready are looked at again, backing off from there
+	RequeueInterval metav1.Duration `json:"requeueInterval,omitempty"`
+	// ClusterPhase is the phase cluster-api clusters without a Ready condition
+	// are registered in, unless a readiness policy applies
+	ClusterPhase string `json:"clusterPhase,omitempty"`
+	// DeletionPolicy applies to registry Clusters whose source goes away:
+	// Cascade, Orphan or Retain
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+	// EndpointRules is the namespace/name of a ConfigMap of rules deriving
+	// server endpoints per client CIDR
+	EndpointRules string `json:"endpointRules,omitempty"`
+	// BootstrapTemplate is the namespace/name of a ConfigMap scoping a
+	// ServiceAccount bootstrapped in each cluster-api cluster
+	BootstrapTemplate string `json:"bootstrapTemplate,omitempty"`
+	// ReadinessPolicies is the namespace/name of a ConfigMap of readiness
+	// policies gating the registration of cluster-api clusters
+	ReadinessPolicies string `json:"readinessPolicies,omitempty"`
+	// ClusterSelector is a label selector limiting the cluster-api clusters
+	// registered, all when empty
+	ClusterSelector string `json:"clusterSelector,omitempty"`
+	// PropagateLabels lists the keys of the labels copied from cluster-api
+	// clusters to their registry Clusters, entries ending in * as prefixes
+	PropagateLabels []string `json:"propagateLabels,omitempty"`
+	// PropagateAnnotations lists the keys of the annotations copied from
+	// cluster-api clusters to their registry Clusters, entries ending in * as prefixes
+	PropagateAnnotations []string `json:"propagateAnnotations,omitempty"`
+}
+
+// ExportersConfiguration configures the controllers exporting registry Clusters
+type ExportersConfiguration struct {
+	// Enabled lists the exporters publishing registry Clusters as Secrets
+	Enabled []string `json:"enabled,omitempty"`
+	// Concurrency is the number of registry Clusters each exporter reconciles in parallel
+	Concurrency int `json:"concurrency,omitempty"`
+	// ArgoCDNamespace is the namespace Argo CD cluster Secrets are exported to
+	ArgoCDNamespace string `json:"argoCDNamespace,omitempty"`
+	// KubeconfigNamespace is the namespace kubeconfig Secrets are exported
+	// to, the namespace of each registry Cluster when empty
+	KubeconfigNamespace string `json:"kubeconfigNamespace,omitempty"`
+	// KubeconfigKey is the key of the kubeconfig in exported kubeconfig Secrets
+	KubeconfigKey string `json:"kubeconfigKey,omitempty"`
+	// KubeconfigClientCIDR picks the server endpoint of exported kubeconfigs,
+	// the first one when empty
+	KubeconfigClientCIDR string `json:"kubeconfigClientCIDR,omitempty"`
+}
+
+// RegistryAPIConfiguration configures the read-only registry API
+type RegistryAPIConfiguration struct {
+	// BindAddress of the registry API, disabled when empty
+	BindAddress string `json:"bindAddress,omitempty"`
+	// TLSCertFile is the TLS certificate of the registry API, plain HTTP when empty
+	TLSCertFile string `json:"tlsCertFile,omitempty"`
+	// TLSKeyFile is the TLS key of the registry API
+	TLSKeyFile string `json:"tlsKeyFile,omitempty"`
+}
+
+// NewManagerConfiguration returns the default configuration, reaching
+// registered clusters from $POD_IP
+func NewManagerConfiguration() *ManagerConfiguration {
+	return &ManagerConfiguration{
+		TypeMeta:               metav1.TypeMeta{APIVersion: APIVersion, Kind: Kind},
+		MetricsBindAddress:     ":8080",
+		HealthProbeBindAddress: ":8081",
+		ClientIP:               os.Getenv("POD_IP"),
+		Webhook:                WebhookConfiguration{Enabled: true, Port: 9443},
+		LeaderElection:         LeaderElectionConfiguration{ResourceName: "cluster-registry-controller.clusterregistry.k8s.io"},
+		Cluster: ClusterConfiguration{
+			Concurrency:             1,
+			HeartbeatPeriod:         metav1.Duration{Duration: time.Minute},
+			InventoryPeriod:         metav1.Duration{Duration: 10 * time.Minute},
+			CertificateExpiryWindow: metav1.Duration{Duration: 30 * 24 * time.Hour},
+		},
+		Sources: SourcesConfiguration{
+			Enabled:         []string{controllers.ClusterApiSourceName},
+			Concurrency:     10,
+			RequeueInterval: metav1.Duration{Duration: 5 * time.Second},
+			ClusterPhase:    controllers.Phase,
+			DeletionPolicy:  string(clusterregistryv1beta1.DeletionPolicyCascade),
+		},
+		Exporters: ExportersConfiguration{
+			Concurrency:     10,
+			ArgoCDNamespace: "argocd",
+			KubeconfigKey:   "value",
+		},
+	}
+}
+
+// AddFlags binds a flag to every field of the configuration
+func (c *ManagerConfiguration) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.MetricsBindAddress, "metrics-addr", c.MetricsBindAddress, "The address the metric endpoint binds to, 0 to disable it.")
+	fs.StringVar(&c.HealthProbeBindAddress, "health-probe-addr", c.HealthProbeBindAddress,
+		"The address /healthz and /readyz are served on, 0 to disable them.")
+	fs.Var((*stringList)(&c.Namespaces), "namespaces", "Comma separated list of namespaces to watch. All namespaces when empty.")
+	fs.StringVar(&c.ClientIP, "client-ip", c.ClientIP,
+		"The IP the controller reaches registered clusters from, picking their server endpoint. Defaults to $POD_IP.")
+
+	fs.BoolVar(&c.Webhook.Enabled, "enable-webhooks", c.Webhook.Enabled,
+		"Serve the admission and conversion webhooks, which need serving certificates. Also turned off by ENABLE_WEBHOOKS=false.")
+	fs.StringVar(&c.Webhook.Host, "webhook-host", c.Webhook.Host, "The address the webhook server binds to. All interfaces when empty.")
+	fs.IntVar(&c.Webhook.Port, "webhook-port", c.Webhook.Port, "The port the webhook server binds to.")
+	fs.StringVar(&c.Webhook.CertDir, "webhook-cert-dir", c.Webhook.CertDir,
+		"The directory holding tls.crt and tls.key of the webhook server. Defaults to the controller-runtime one.")
+
+	fs.BoolVar(&c.LeaderElection.LeaderElect, "enable-leader-election", c.LeaderElection.LeaderElect,
+		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
+	fs.StringVar(&c.LeaderElection.ResourceNamespace, "leader-election-namespace", c.LeaderElection.ResourceNamespace,
+		"The namespace of the leader election lock. Defaults to the namespace the manager runs in.")
+	fs.StringVar(&c.LeaderElection.ResourceName, "leader-election-id", c.LeaderElection.ResourceName, "The name of the leader election lock.")
+
+	fs.IntVar(&c.Cluster.Concurrency, "cluster-concurrency", c.Cluster.Concurrency, "The number of registry Clusters probed in parallel.")
+	fs.DurationVar(&c.Cluster.HeartbeatPeriod.Duration, "heartbeat-period", c.Cluster.HeartbeatPeriod.Duration,
+		"How often registered clusters are probed for health.")
+	fs.DurationVar(&c.Cluster.InventoryPeriod.Duration, "inventory-period", c.Cluster.InventoryPeriod.Duration,
+		"How often the version, nodes, capacity and network plugin of reachable clusters are collected.")
+	fs.DurationVar(&c.Cluster.CertificateExpiryWindow.Duration, "certificate-expiry-window", c.Cluster.CertificateExpiryWindow.Duration,
+		"How long before a certificate of a registry Cluster expires its CertificateExpiring condition turns True.")
+
+	fs.Var((*stringList)(&c.Sources.Enabled), "sources",
+		"Comma separated list of cluster sources to register clusters from. Known sources: "+strings.Join(controllers.SourceNames(), ", "))
+	fs.IntVar(&c.Sources.Concurrency, "source-concurrency", c.Sources.Concurrency, "The number of source objects each cluster source reconciles in parallel.")
+	fs.DurationVar(&c.Sources.RequeueInterval.Duration, "requeue-interval", c.Sources.RequeueInterval.Duration,
+		"The requeue interval for clusters that are not ready, backing off from there.")
+	fs.StringVar(&c.Sources.ClusterPhase, "cluster-phase", c.Sources.ClusterPhase,
+		"The phase cluster-api clusters without a Ready condition are registered in, unless a readiness policy applies.")
+	fs.StringVar(&c.Sources.DeletionPolicy, "deletion-policy", c.Sources.DeletionPolicy,
+		"What happens to a registry Cluster when its source goes away: Cascade, Orphan or Retain. Overridden by the "+
+			clusterregistryv1beta1.DeletionPolicyAnnotation+" annotation.")
+	fs.StringVar(&c.Sources.EndpointRules, "endpoint-rules", c.Sources.EndpointRules,
+		"namespace/name of a ConfigMap of rules deriving server endpoints per client CIDR. Overridden by the "+
+			clusterregistryv1beta1.ServerEndpointsAnnotation+" annotation on source objects.")
+	fs.StringVar(&c.Sources.BootstrapTemplate, "bootstrap-template", c.Sources.BootstrapTemplate,
+		"namespace/name of a ConfigMap scoping a ServiceAccount bootstrapped in each cluster-api cluster, "+
+			"handed to controllers instead of the admin kubeconfig. Disabled when empty.")
+	fs.StringVar(&c.Sources.ReadinessPolicies, "readiness-policies", c.Sources.ReadinessPolicies,
+		"namespace/name of a ConfigMap of readiness policies gating the registration of cluster-api clusters.")
+	fs.StringVar(&c.Sources.ClusterSelector, "cluster-selector", c.Sources.ClusterSelector,
+		"Label selector limiting the cluster-api clusters registered. Registry Clusters of clusters no longer selected are removed.")
+	fs.Var((*stringList)(&c.Sources.PropagateLabels), "propagate-labels",
+		"Comma separated list of the label keys copied from cluster-api clusters to their registry Clusters. Keys ending in * are prefixes.")
+	fs.Var((*stringList)(&c.Sources.PropagateAnnotations), "propagate-annotations",
+		"Comma separated list of the annotation keys copied from cluster-api clusters to their registry Clusters. Keys ending in * are prefixes.")
+
+	fs.Var((*stringList)(&c.Exporters.Enabled), "exporters",
+		"Comma separated list of exporters publishing registry Clusters as Secrets. Known exporters: "+strings.Join(controllers.ExporterNames(), ", "))
+	fs.IntVar(&c.Exporters.Concurrency, "exporter-concurrency", c.Exporters.Concurrency, "The number of registry Clusters each exporter reconciles in parallel.")
+	fs.StringVar(&c.Exporters.ArgoCDNamespace, "argocd-namespace", c.Exporters.ArgoCDNamespace, "The namespace Argo CD cluster Secrets are exported to.")
+	fs.StringVar(&c.Exporters.KubeconfigNamespace, "kubeconfig-export-namespace", c.Exporters.KubeconfigNamespace,
+		"The namespace kubeconfig Secrets are exported to. Defaults to the namespace of each registry Cluster.")
+	fs.StringVar(&c.Exporters.KubeconfigKey, "kubeconfig-export-key", c.Exporters.KubeconfigKey, "The key of the kubeconfig in exported kubeconfig Secrets.")
+	fs.StringVar(&c.Exporters.KubeconfigClientCIDR, "kubeconfig-export-client-cidr", c.Exporters.KubeconfigClientCIDR,
+		"The client CIDR picking the server endpoint of exported kubeconfigs. Defaults to the first endpoint.")
+
+	fs.StringVar(&c.RegistryAPI.BindAddress, "registry-api-addr", c.RegistryAPI.BindAddress,
+		"The address the read-only registry API binds to, e.g. :8443. Disabled when empty.")
+	fs.StringVar(&c.RegistryAPI.TLSCertFile, "registry-api-tls-cert-file", c.RegistryAPI.TLSCertFile, "The TLS certificate of the registry API, plain HTTP when empty.")
+	fs.StringVar(&c.RegistryAPI.TLSKeyFile, "registry-api-tls-key-file", c.RegistryAPI.TLSKeyFile, "The TLS key of the registry API.")
+}
+
+// Load the configuration from the command line: the defaults, overridden by
+// the file given with --config, overridden by the flags set on the command line
+func Load(fs *flag.FlagSet, args []string) (*ManagerConfiguration, error) {
+	c := NewManagerConfiguration()
+	c.AddFlags(fs)
+	file := fs.String("config", "", "The manager configuration file. Flags set on the command line override it.")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *file != "" {
+		set := map[string]string{}
+		fs.Visit(func(f *flag.Flag) {
+			set[f.Name] = f.Value.String()
+		})
+		if err := c.loadFile(*file); err != nil {
+			return nil, err
+		}
+		for name, value := range set {
+			if err := fs.Set(name, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, c.Validate()
+}
+
+// Decode a configuration file over the configuration, rejecting unknown fields
+func (c *ManagerConfiguration) loadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("unable to parse %s: %v", path, err)
+	}
+	if c.APIVersion != APIVersion || c.Kind != Kind {
+		return fmt.Errorf("%s is a %s %s, not a %s %s", path, c.APIVersion, c.Kind, APIVersion, Kind)
+	}
+	return nil
+}
+
+// Validate the configuration, reporting all invalid fields at once
+func (c *ManagerConfiguration) Validate() error {
+	var errs field.ErrorList
+	errs = append(errs, validateAddress(c.MetricsBindAddress, true, field.NewPath("metricsBindAddress"))...)
+	errs = append(errs, validateAddress(c.HealthProbeBindAddress, true, field.NewPath("healthProbeBindAddress"))...)
+	for i, namespace := range c.Namespaces {
+		if namespace == "" {
+			errs = append(errs, field.Required(field.NewPath("namespaces").Index(i), ""))
+		}
+	}
+	if c.ClientIP != "" && net.ParseIP(c.ClientIP) == nil {
+		errs = append(errs, field.Invalid(field.NewPath("clientIP"), c.ClientIP, "must be an IP address"))
+	}
+
+	if c.Webhook.Port <= 0 || c.Webhook.Port > 65535 {
+		errs = append(errs, field.Invalid(field.NewPath("webhook", "port"), c.Webhook.Port, "must be a port number"))
+	}
+	if c.LeaderElection.LeaderElect && c.LeaderElection.ResourceName == "" {
+		errs = append(errs, field.Required(field.NewPath("leaderElection", "resourceName"), "needed for leader election"))
+	}
+
+	cluster := field.NewPath("cluster")
+	errs = append(errs, validatePositive(c.Cluster.Concurrency, cluster.Child("concurrency"))...)
+	errs = append(errs, validatePositive(c.Cluster.HeartbeatPeriod.Duration, cluster.Child("heartbeatPeriod"))...)
+	errs = append(errs, validatePositive(c.Cluster.InventoryPeriod.Duration, cluster.Child("inventoryPeriod"))...)
+	errs = append(errs, validatePositive(c.Cluster.CertificateExpiryWindow.Duration, cluster.Child("certificateExpiryWindow"))...)
+
+	sources := field.NewPath("sources")
+	errs = append(errs, validateNames(c.Sources.Enabled, controllers.SourceNames(), sources.Child("enabled"))...)
+	errs = append(errs, validatePositive(c.Sources.Concurrency, sources.Child("concurrency"))...)
+	errs = append(errs, validatePositive(c.Sources.RequeueInterval.Duration, sources.Child("requeueInterval"))...)
+	if _, err := controllers.ParseDeletionPolicy(c.Sources.DeletionPolicy); err != nil {
+		errs = append(errs, field.Invalid(sources.Child("deletionPolicy"), c.Sources.DeletionPolicy, err.Error()))
+	}
+	for name, value := range map[string]string{
+		"endpointRules":     c.Sources.EndpointRules,
+		"bootstrapTemplate": c.Sources.BootstrapTemplate,
+		"readinessPolicies": c.Sources.ReadinessPolicies,
+	} {
+		if _, err := ParseObjectKey(value); err != nil {
+			errs = append(errs, field.Invalid(sources.Child(name), value, err.Error()))
+		}
+	}
+
+	if _, err := labels.Parse(c.Sources.ClusterSelector); err != nil {
+		errs = append(errs, field.Invalid(sources.Child("clusterSelector"), c.Sources.ClusterSelector, err.Error()))
+	}
+	errs = append(errs, validateKeyFilters(c.Sources.PropagateLabels, sources.Child("propagateLabels"))...)
+	errs = append(errs, validateKeyFilters(c.Sources.PropagateAnnotations, sources.Child("propagateAnnotations"))...)
+
+	exporters := field.NewPath("exporters")
+	errs = append(errs, validateNames(c.Exporters.Enabled, controllers.ExporterNames(), exporters.Child("enabled"))...)
+	errs = append(errs, validatePositive(c.Exporters.Concurrency, exporters.Child("concurrency"))...)
+	if c.Exporters.KubeconfigKey == "" {
+		errs = append(errs, field.Required(exporters.Child("kubeconfigKey"), ""))
+	}
+	if cidr := c.Exporters.KubeconfigClientCIDR; cidr != "" {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, field.Invalid(exporters.Child("kubeconfigClientCIDR"), cidr, "must be a CIDR"))
+		}
+	}
+
+	registryAPI := field.NewPath("registryAPI")
+	errs = append(errs, validateAddress(c.RegistryAPI.BindAddress, false, registryAPI.Child("bindAddress"))...)
+	if (c.RegistryAPI.TLSCertFile == "") != (c.RegistryAPI.TLSKeyFile == "") {
+		errs = append(errs, field.Invalid(registryAPI, c.RegistryAPI.TLSCertFile, "tlsCertFile and tlsKeyFile must be set together"))
+	}
+	errs = append(errs, c.validateNamespaces()...)
+	return errs.ToAggregate()
+}
+
+// With Namespaces set the manager only caches and watches those, so the
+// ConfigMaps it reads and the Secrets exporters write must be in one of them
+func (c *ManagerConfiguration) validateNamespaces() field.ErrorList {
+	if len(c.Namespaces) == 0 {
+		return nil
+	}
+	var errs field.ErrorList
+	watched := func(path *field.Path, value, namespace string) {
+		for _, n := range c.Namespaces {
+			if n == namespace {
+				return
+			}
+		}
+		errs = append(errs, field.Invalid(path, value, "must be in one of the namespaces "+strings.Join(c.Namespaces, ",")))
+	}
+
+	sources := field.NewPath("sources")
+	for _, ref := range []struct{ name, value string }{
+		{"endpointRules", c.Sources.EndpointRules},
+		{"bootstrapTemplate", c.Sources.BootstrapTemplate},
+		{"readinessPolicies", c.Sources.ReadinessPolicies},
+	} {
+		if key, err := ParseObjectKey(ref.value); err == nil && key.Name != "" {
+			watched(sources.Child(ref.name), ref.value, key.Namespace)
+		}
+	}
+
+	exporters := field.NewPath("exporters")
+	for _, name := range c.Exporters.Enabled {
+		switch {
+		case name == controllers.ArgoCDExporterName:
+			watched(exporters.Child("argoCDNamespace"), c.Exporters.ArgoCDNamespace, c.Exporters.ArgoCDNamespace)
+		case name == controllers.KubeconfigExporterName && c.Exporters.KubeconfigNamespace != "":
+			watched(exporters.Child("kubeconfigNamespace"), c.Exporters.KubeconfigNamespace, c.Exporters.KubeconfigNamespace)
+		}
+	}
+	return errs
+}
+
+// ParseObjectKey parses a namespace/name reference, empty if not given
+func ParseObjectKey(value string) (types.NamespacedName, error) {
+	if value == "" {
+		return types.NamespacedName{}, nil
+	}
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return types.NamespacedName{}, fmt.Errorf("must be namespace/name")
+	}
+	return types.NamespacedName{Namespace: parts[0], Name: parts[1]}, nil
+}
+
+// Validate label or annotation keys, or their prefixes when ending in *
+func validateKeyFilters(filters []string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, filter := range filters {
+		key := filter
+		if strings.HasSuffix(filter, "*") {
+			// complete a prefix, e.g. example.com/, to a key to check it
+			key = strings.TrimSuffix(filter, "*") + "x"
+		}
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, field.Invalid(path.Index(i), filter, msg))
+		}
+	}
+	return errs
+}
+
+// Validate a bind address: host:port, empty to disable and "0" as
+// controller-runtime takes it to disable an endpoint when allowed
+func validateAddress(address string, zero bool, path *field.Path) field.ErrorList {
+	if address == "" || zero && address == "0" {
+		return nil
+	}
+	_, port, err := net.SplitHostPort(address)
+	if err == nil {
+		_, err = strconv.ParseUint(port, 10, 16)
+	}
+	if err != nil {
+		return field.ErrorList{field.Invalid(path, address, "must be host:port")}
+	}
+	return nil
+}
+
+func validatePositive(value interface{}, path *field.Path) field.ErrorList {
+	switch v := value.(type) {
+	case int:
+		if v > 0 {
+			return nil
+		}
+	case time.Duration:
+		if v > 0 {
+			return nil
+		}
+	}
+	return field.ErrorList{field.Invalid(path, fmt.Sprint(value), "must be positive")}
+}
+
+func validateNames(names, known []string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, name := range names {
+		found := false
+		for _, k := range known {
+			found = found || name == k
+		}
+		if !found {
+			errs = append(errs, field.NotSupported(path.Index(i), name, known))
+		}
+	}
+	return errs
+}
+
+// stringList is a comma separated flag, replacing the list when set
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const managerConfiguration = `apiVersion: config.clusterregistry.k8s.io/v1alpha1
+kind: ManagerConfiguration
+namespaces: [clusters, argocd]
+webhook:
+  enabled: true
+  port: 9444
+leaderElection:
+  leaderElect: true
+  resourceNamespace: cluster-registry-system
+cluster:
+  concurrency: 4
+  heartbeatPeriod: 30s
+sources:
+  enabled: [cluster-api, kubeconfig-secret]
+  requeueInterval: 10s
+exporters:
+  enabled: [argocd]
+`
+
+func load(t *testing.T, config string, args ...string) (*ManagerConfiguration, error) {
+	if config != "" {
+		dir, err := ioutil.TempDir("", "options")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "config.yaml")
+		if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"--config", path}, args...)
+	}
+	fs := flag.NewFlagSet("manager", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return Load(fs, args)
+}
+
+func TestLoad(t *testing.T) {
+	config, err := load(t, "")
+	if err != nil {
+		t.Fatalf("expected the defaults to be valid, got %v", err)
+	}
+	if !reflect.DeepEqual(config, NewManagerConfiguration()) {
+		t.Errorf("expected the defaults, got %+v", config)
+	}
+
+	config, err = load(t, "", "--sources=cluster-api, kubeconfig-secret", "--webhook-port=10443", "--requeue-interval=1m",
+		"--cluster-selector=env in (prod)", "--propagate-labels=env,example.com/*")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(config.Sources.Enabled, []string{"cluster-api", "kubeconfig-secret"}) ||
+		config.Webhook.Port != 10443 || config.Sources.RequeueInterval.Duration != time.Minute ||
+		config.Sources.ClusterSelector != "env in (prod)" || !reflect.DeepEqual(config.Sources.PropagateLabels, []string{"env", "example.com/*"}) {
+		t.Errorf("expected the flags to be applied, got %+v", config)
+	}
+
+	config, err = load(t, managerConfiguration, "--webhook-port=10443", "--exporters=kubeconfig")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.Webhook.Port != 10443 || !reflect.DeepEqual(config.Exporters.Enabled, []string{"kubeconfig"}) {
+		t.Errorf("expected the flags to override the file, got %+v, %v", config.Webhook, config.Exporters.Enabled)
+	}
+	if !reflect.DeepEqual(config.Namespaces, []string{"clusters", "argocd"}) || !config.LeaderElection.LeaderElect ||
+		config.LeaderElection.ResourceNamespace != "cluster-registry-system" || config.Cluster.Concurrency != 4 ||
+		config.Cluster.HeartbeatPeriod.Duration != 30*time.Second || len(config.Sources.Enabled) != 2 {
+		t.Errorf("expected the file to be applied, got %+v", config)
+	}
+	if config.LeaderElection.ResourceName != NewManagerConfiguration().LeaderElection.ResourceName ||
+		config.Exporters.Concurrency != 10 || config.Cluster.InventoryPeriod.Duration != 10*time.Minute {
+		t.Errorf("expected the defaults for fields missing from the file, got %+v", config)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for name, tc := range map[string]struct {
+		config string
+		args   []string
+		errors []string
+	}{
+		"unknown field": {
+			config: managerConfiguration + "unknown: true\n",
+			errors: []string{"unknown field"},
+		},
+		"wrong kind": {
+			config: strings.Replace(managerConfiguration, "kind: ManagerConfiguration", "kind: Config", 1),
+			errors: []string{"not a config.clusterregistry.k8s.io/v1alpha1 ManagerConfiguration"},
+		},
+		"invalid flags": {
+			args: []string{
+				"--source-concurrency=0", "--sources=cluster-api,unknown", "--deletion-policy=Never",
+				"--endpoint-rules=rules", "--kubeconfig-export-client-cidr=10.0.0.0", "--webhook-port=0",
+				"--registry-api-addr=8443", "--registry-api-tls-cert-file=tls.crt", "--client-ip=pod",
+				"--certificate-expiry-window=0", "--cluster-selector=env in prod", "--propagate-annotations=example.com/*,example.com/team/*,-team",
+			},
+			errors: []string{
+				"sources.concurrency", "sources.enabled[1]", "sources.deletionPolicy", "sources.endpointRules",
+				"exporters.kubeconfigClientCIDR", "webhook.port", "registryAPI.bindAddress", "registryAPI", "clientIP",
+				"cluster.certificateExpiryWindow", "sources.clusterSelector", "sources.propagateAnnotations[1]",
+				"sources.propagateAnnotations[2]",
+			},
+		},
+		"namespaces not watched": {
+			config: managerConfiguration,
+			args: []string{
+				"--endpoint-rules=registry/endpoint-rules", "--readiness-policies=clusters/readiness",
+				"--exporters=argocd,kubeconfig", "--argocd-namespace=gitops", "--kubeconfig-export-namespace=flux-system",
+			},
+			errors: []string{"sources.endpointRules", "exporters.argoCDNamespace", "exporters.kubeconfigNamespace"},
+		},
+		"invalid file": {
+			config: strings.Replace(managerConfiguration, "leaderElection:\n", "metricsBindAddress: localhost\nleaderElection:\n  resourceName: \"\"\n", 1),
+			errors: []string{"metricsBindAddress", "leaderElection.resourceName"},
+		},
+	} {
+		_, err := load(t, tc.config, tc.args...)
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+			continue
+		}
+		for _, want := range tc.errors {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected %q in %v", name, want, err)
+			}
+		}
+	}
+}
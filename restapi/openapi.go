@@ -0,0 +1,189 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restapi
+
+import (
+	"net/http"
+)
+
+// openAPI documents the registry API, kept by hand in line with Server
+const openAPI = `{
+  "openapi": "3.0.0",
+  "info": {
+    "title": "Cluster registry API",
+    "description": "Read-only view of the registry Clusters. Callers need a bearer token of the hub cluster allowed to get or list clusters.clusterregistry.k8s.io.",
+    "version": "v1"
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": {"type": "http", "scheme": "bearer"}
+    },
+    "parameters": {
+      "namespace": {"name": "namespace", "in": "path", "required": true, "schema": {"type": "string"}},
+      "name": {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}},
+      "labelSelector": {
+        "name": "labelSelector", "in": "query", "schema": {"type": "string"},
+        "description": "Kubernetes label selector, e.g. clusterregistry.k8s.io/environment=prod"
+      },
+      "condition": {
+        "name": "condition", "in": "query", "style": "form", "explode": true,
+        "schema": {"type": "array", "items": {"type": "string"}},
+        "description": "Condition type the clusters report True, or type=True|False|Unknown. Repeated conditions must all match."
+      }
+    },
+    "responses": {
+      "error": {
+        "description": "Error",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "list": {
+        "description": "Registry clusters",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ClusterList"}}}
+      }
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "properties": {
+          "code": {"type": "integer"},
+          "message": {"type": "string"}
+        }
+      },
+      "ClusterList": {
+        "type": "object",
+        "properties": {
+          "items": {"type": "array", "items": {"$ref": "#/components/schemas/Cluster"}}
+        }
+      },
+      "Cluster": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "namespace": {"type": "string"},
+          "labels": {"type": "object", "additionalProperties": {"type": "string"}},
+          "serverEndpoints": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "clientCIDR": {"type": "string"},
+                "serverAddress": {"type": "string"}
+              }
+            }
+          },
+          "hasCABundle": {"type": "boolean"},
+          "source": {
+            "type": "object",
+            "properties": {
+              "apiVersion": {"type": "string"},
+              "kind": {"type": "string"},
+              "namespace": {"type": "string"},
+              "name": {"type": "string"},
+              "uid": {"type": "string"}
+            }
+          },
+          "conditions": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "type": {"type": "string"},
+                "status": {"type": "string", "enum": ["True", "False", "Unknown"]},
+                "observedGeneration": {"type": "integer", "format": "int64"},
+                "lastTransitionTime": {"type": "string", "format": "date-time"},
+                "reason": {"type": "string"},
+                "message": {"type": "string"}
+              }
+            }
+          },
+          "version": {"type": "string"},
+          "provider": {"type": "string"},
+          "region": {"type": "string"},
+          "nodes": {
+            "type": "object",
+            "properties": {
+              "total": {"type": "integer", "format": "int32"},
+              "roles": {"type": "object", "additionalProperties": {"type": "integer", "format": "int32"}}
+            }
+          },
+          "cni": {"type": "string"}
+        }
+      }
+    }
+  },
+  "security": [{"bearer": []}],
+  "paths": {
+    "/api/v1/clusters": {
+      "get": {
+        "summary": "List registry clusters in all namespaces",
+        "parameters": [{"$ref": "#/components/parameters/labelSelector"}, {"$ref": "#/components/parameters/condition"}],
+        "responses": {"200": {"$ref": "#/components/responses/list"}, "default": {"$ref": "#/components/responses/error"}}
+      }
+    },
+    "/api/v1/namespaces/{namespace}/clusters": {
+      "get": {
+        "summary": "List registry clusters in a namespace",
+        "parameters": [
+          {"$ref": "#/components/parameters/namespace"},
+          {"$ref": "#/components/parameters/labelSelector"},
+          {"$ref": "#/components/parameters/condition"}
+        ],
+        "responses": {"200": {"$ref": "#/components/responses/list"}, "default": {"$ref": "#/components/responses/error"}}
+      }
+    },
+    "/api/v1/namespaces/{namespace}/clusters/{name}": {
+      "get": {
+        "summary": "Get a registry cluster",
+        "parameters": [{"$ref": "#/components/parameters/namespace"}, {"$ref": "#/components/parameters/name"}],
+        "responses": {
+          "200": {
+            "description": "Registry cluster",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Cluster"}}}
+          },
+          "default": {"$ref": "#/components/responses/error"}
+        }
+      }
+    },
+    "/api/v1/namespaces/{namespace}/clusters/{name}/ca.crt": {
+      "get": {
+        "summary": "Download the CA bundle of a registry cluster",
+        "parameters": [{"$ref": "#/components/parameters/namespace"}, {"$ref": "#/components/parameters/name"}],
+        "responses": {
+          "200": {
+            "description": "PEM encoded CA certificates",
+            "content": {"application/x-pem-file": {"schema": {"type": "string"}}}
+          },
+          "default": {"$ref": "#/components/responses/error"}
+        }
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "summary": "This document",
+        "security": [],
+        "responses": {"200": {"description": "OpenAPI document"}}
+      }
+    }
+  }
+}
+`
+
+// Serve the OpenAPI document, which needs no token
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(openAPI))
+}
@@ -0,0 +1,326 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package restapi serves the cluster registry as a read-only HTTP/JSON API
+// for tools that do not speak Kubernetes.
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+// shutdownTimeout bounds how long in-flight requests may finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// Server serves registry Clusters read-only under /api/v1, and the OpenAPI
+// document of the API under /openapi.json. Requests carry a bearer token,
+// authenticated with a TokenReview and authorized with a SubjectAccessReview
+// against the hub, as if they read clusters.clusterregistry.k8s.io there.
+type Server struct {
+	// Addr is the address the API listens on
+	Addr string
+	// CertFile and KeyFile serve the API over TLS, plain HTTP when unset
+	CertFile string
+	KeyFile  string
+
+	// Reader reads registry Clusters, usually the manager's cache
+	Reader client.Reader
+	// Hub reviews the tokens and access of callers
+	Hub kubernetes.Interface
+	Log logr.Logger
+}
+
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// NeedLeaderElection is false: every replica of the manager serves the API.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start serves the API until stop is closed.
+func (s *Server) Start(stop <-chan struct{}) error {
+	server := &http.Server{Addr: s.Addr, Handler: s.Handler()}
+	errs := make(chan error, 1)
+	go func() {
+		s.Log.Info("serving registry API", "addr", s.Addr, "tls", s.CertFile != "")
+		if s.CertFile != "" {
+			errs <- server.ListenAndServeTLS(s.CertFile, s.KeyFile)
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-stop:
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}
+}
+
+// Handler routes the requests of the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/openapi.json", serveOpenAPI)
+	mux.HandleFunc("/api/v1/clusters", s.authorized(s.list))
+	mux.HandleFunc("/api/v1/namespaces/", s.authorized(s.namespaced))
+	return mux
+}
+
+// Cluster is a registry Cluster as the API serves it.
+type Cluster struct {
+	Name            string                                             `json:"name"`
+	Namespace       string                                             `json:"namespace"`
+	Labels          map[string]string                                  `json:"labels,omitempty"`
+	ServerEndpoints []clusterregistryv1beta1.ServerAddressByClientCIDR `json:"serverEndpoints,omitempty"`
+	HasCABundle     bool                                               `json:"hasCABundle"`
+	Source          *clusterregistryv1beta1.SourceReference            `json:"source,omitempty"`
+	Conditions      []clusterregistryv1beta1.Condition                 `json:"conditions,omitempty"`
+	Version         string                                             `json:"version,omitempty"`
+	Provider        string                                             `json:"provider,omitempty"`
+	Region          string                                             `json:"region,omitempty"`
+	Nodes           *clusterregistryv1beta1.NodeSummary                `json:"nodes,omitempty"`
+	CNI             string                                             `json:"cni,omitempty"`
+}
+
+// ClusterList is a list of registry Clusters as the API serves it.
+type ClusterList struct {
+	Items []Cluster `json:"items"`
+}
+
+// Error is the body of a failed request.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func toCluster(clusterreg *clusterregistryv1beta1.Cluster) Cluster {
+	return Cluster{
+		Name:            clusterreg.Name,
+		Namespace:       clusterreg.Namespace,
+		Labels:          clusterreg.Labels,
+		ServerEndpoints: clusterreg.Spec.KubernetesAPIEndpoints.ServerEndpoints,
+		HasCABundle:     len(clusterreg.Spec.KubernetesAPIEndpoints.CABundle) > 0,
+		Source:          clusterreg.Spec.Source,
+		Conditions:      clusterreg.Status.Conditions,
+		Version:         clusterreg.Status.Version,
+		Provider:        clusterreg.Status.Provider,
+		Region:          clusterreg.Status.Region,
+		Nodes:           clusterreg.Status.Nodes,
+		CNI:             clusterreg.Status.CNI,
+	}
+}
+
+// request is what a call reads, for authorization
+type request struct {
+	verb      string
+	namespace string
+	name      string
+	ca        bool
+}
+
+// Parse the path of a call, false when it is not one of the API
+func parseRequest(path string) (request, bool) {
+	if path == "/api/v1/clusters" {
+		return request{verb: "list"}, true
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/api/v1/namespaces/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] != "clusters" {
+		return request{}, false
+	}
+	switch {
+	case len(parts) == 2:
+		return request{verb: "list", namespace: parts[0]}, true
+	case len(parts) == 3 && parts[2] != "":
+		return request{verb: "get", namespace: parts[0], name: parts[2]}, true
+	case len(parts) == 4 && parts[2] != "" && parts[3] == "ca.crt":
+		return request{verb: "get", namespace: parts[0], name: parts[2], ca: true}, true
+	}
+	return request{}, false
+}
+
+// Authenticate the bearer token of a call and authorize it to read the
+// registry Clusters it asks for
+func (s *Server) authorized(handle func(http.ResponseWriter, *http.Request, request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "the registry API is read-only")
+			return
+		}
+		req, ok := parseRequest(r.URL.Path)
+		if !ok {
+			writeError(w, http.StatusNotFound, "no such path")
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == r.Header.Get("Authorization") {
+			writeError(w, http.StatusUnauthorized, "a bearer token is required")
+			return
+		}
+
+		review, err := s.Hub.AuthenticationV1().TokenReviews().Create(&authenticationv1.TokenReview{
+			Spec: authenticationv1.TokenReviewSpec{Token: token},
+		})
+		if err != nil {
+			s.Log.Error(err, "unable review token")
+			writeError(w, http.StatusInternalServerError, "unable to review the token")
+			return
+		}
+		if !review.Status.Authenticated {
+			writeError(w, http.StatusUnauthorized, "invalid bearer token")
+			return
+		}
+		user := review.Status.User
+		extra := map[string]authorizationv1.ExtraValue{}
+		for key, value := range user.Extra {
+			extra[key] = authorizationv1.ExtraValue(value)
+		}
+		access, err := s.Hub.AuthorizationV1().SubjectAccessReviews().Create(&authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:   user.Username,
+				UID:    user.UID,
+				Groups: user.Groups,
+				Extra:  extra,
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Group:     clusterregistryv1beta1.GroupVersion.Group,
+					Resource:  "clusters",
+					Verb:      req.verb,
+					Namespace: req.namespace,
+					Name:      req.name,
+				},
+			},
+		})
+		if err != nil {
+			s.Log.Error(err, "unable review access")
+			writeError(w, http.StatusInternalServerError, "unable to review access")
+			return
+		}
+		if !access.Status.Allowed {
+			writeError(w, http.StatusForbidden, user.Username+" may not "+req.verb+" registry clusters")
+			return
+		}
+		handle(w, r, req)
+	}
+}
+
+// Serve a namespaced call
+func (s *Server) namespaced(w http.ResponseWriter, r *http.Request, req request) {
+	if req.verb == "list" {
+		s.list(w, r, req)
+		return
+	}
+	clusterreg := &clusterregistryv1beta1.Cluster{}
+	if err := s.Reader.Get(r.Context(), client.ObjectKey{Namespace: req.namespace, Name: req.name}, clusterreg); err != nil {
+		if apierrors.IsNotFound(err) {
+			writeError(w, http.StatusNotFound, "registry cluster "+req.namespace+"/"+req.name+" not found")
+			return
+		}
+		s.Log.Error(err, "unable get Cluster registry")
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if req.ca {
+		if len(clusterreg.Spec.KubernetesAPIEndpoints.CABundle) == 0 {
+			writeError(w, http.StatusNotFound, "registry cluster "+req.namespace+"/"+req.name+" has no CA bundle")
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		_, _ = w.Write(clusterreg.Spec.KubernetesAPIEndpoints.CABundle)
+		return
+	}
+	writeJSON(w, http.StatusOK, toCluster(clusterreg))
+}
+
+// List the registry Clusters matching the labelSelector and all condition
+// parameters, each a condition type with True status or type=status
+func (s *Server) list(w http.ResponseWriter, r *http.Request, req request) {
+	query := r.URL.Query()
+	selector, err := labels.Parse(query.Get("labelSelector"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid labelSelector: "+err.Error())
+		return
+	}
+	conditions := map[clusterregistryv1beta1.ClusterConditionType]corev1.ConditionStatus{}
+	for _, condition := range query["condition"] {
+		parts := strings.SplitN(condition, "=", 2)
+		status := corev1.ConditionTrue
+		if len(parts) == 2 {
+			status = corev1.ConditionStatus(parts[1])
+		}
+		if parts[0] == "" || (status != corev1.ConditionTrue && status != corev1.ConditionFalse && status != corev1.ConditionUnknown) {
+			writeError(w, http.StatusBadRequest, "invalid condition "+condition+", must be type or type=True|False|Unknown")
+			return
+		}
+		conditions[clusterregistryv1beta1.ClusterConditionType(parts[0])] = status
+	}
+
+	clusters := &clusterregistryv1beta1.ClusterList{}
+	if err := s.Reader.List(r.Context(), clusters, client.InNamespace(req.namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		s.Log.Error(err, "unable list Cluster registry")
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	list := ClusterList{Items: []Cluster{}}
+	for i := range clusters.Items {
+		if matchesConditions(&clusters.Items[i], conditions) {
+			list.Items = append(list.Items, toCluster(&clusters.Items[i]))
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// Whether a registry Cluster reports all conditions with their status.
+// A missing condition only matches Unknown.
+func matchesConditions(clusterreg *clusterregistryv1beta1.Cluster, conditions map[clusterregistryv1beta1.ClusterConditionType]corev1.ConditionStatus) bool {
+	for conditionType, status := range conditions {
+		actual := corev1.ConditionUnknown
+		if c := clusterreg.Status.GetCondition(conditionType); c != nil {
+			actual = c.Status
+		}
+		if actual != status {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, Error{Code: code, Message: message})
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2020 zh.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterregistryv1beta1 "github.com/minsheng-fintech-corp-ltd/cluster-registry-controller/api/v1beta1"
+)
+
+func registryCluster(namespace, name, environment string, ok corev1.ConditionStatus) *clusterregistryv1beta1.Cluster {
+	return &clusterregistryv1beta1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{clusterregistryv1beta1.EnvironmentLabel: environment},
+		},
+		Spec: clusterregistryv1beta1.ClusterSpec{
+			KubernetesAPIEndpoints: clusterregistryv1beta1.KubernetesAPIEndpoints{
+				ServerEndpoints: []clusterregistryv1beta1.ServerAddressByClientCIDR{
+					{ClientCIDR: "0.0.0.0/0", ServerAddress: "https://" + name + ".example.com:6443"},
+				},
+				CABundle: []byte("-----BEGIN CERTIFICATE-----\n"),
+			},
+		},
+		Status: clusterregistryv1beta1.ClusterStatus{
+			Conditions: []clusterregistryv1beta1.Condition{{Type: clusterregistryv1beta1.ClusterOK, Status: ok, Reason: "Probed"}},
+			Version:    "v1.17.3",
+		},
+	}
+}
+
+// newServer serves three registry Clusters to the users "reader", who may
+// read the clusters namespace only, and "admin", who may read all
+func newServer(t *testing.T) http.Handler {
+	scheme := runtime.NewScheme()
+	if err := clusterregistryv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	reader := fake.NewFakeClientWithScheme(scheme,
+		registryCluster("clusters", "prod-1", "prod", corev1.ConditionTrue),
+		registryCluster("clusters", "prod-2", "prod", corev1.ConditionFalse),
+		registryCluster("staging", "staging-1", "staging", corev1.ConditionTrue),
+	)
+
+	hub := kubefake.NewSimpleClientset()
+	hub.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "reader-token" || review.Spec.Token == "admin-token" {
+			review.Status.Authenticated = true
+			review.Status.User.Username = review.Spec.Token[:len(review.Spec.Token)-len("-token")]
+		}
+		return true, review, nil
+	})
+	hub.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = attributes.Group == clusterregistryv1beta1.GroupVersion.Group && attributes.Resource == "clusters" &&
+			(review.Spec.User == "admin" || attributes.Namespace == "clusters")
+		return true, review, nil
+	})
+
+	return (&Server{Reader: reader, Hub: hub, Log: ctrl.Log}).Handler()
+}
+
+func get(t *testing.T, handler http.Handler, token, path string, body interface{}) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if raw, ok := body.(*string); ok {
+		*raw = rec.Body.String()
+	} else if body != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), body); err != nil {
+			t.Fatalf("%s: %v: %s", path, err, rec.Body.String())
+		}
+	}
+	return rec.Code
+}
+
+func names(list *ClusterList) []string {
+	var names []string
+	for _, cluster := range list.Items {
+		names = append(names, cluster.Namespace+"/"+cluster.Name)
+	}
+	return names
+}
+
+func TestAuthorization(t *testing.T) {
+	handler := newServer(t)
+	for _, tc := range []struct {
+		token string
+		path  string
+		code  int
+	}{
+		{"", "/api/v1/clusters", http.StatusUnauthorized},
+		{"wrong-token", "/api/v1/clusters", http.StatusUnauthorized},
+		{"reader-token", "/api/v1/clusters", http.StatusForbidden},
+		{"reader-token", "/api/v1/namespaces/staging/clusters", http.StatusForbidden},
+		{"reader-token", "/api/v1/namespaces/clusters/clusters", http.StatusOK},
+		{"reader-token", "/api/v1/namespaces/clusters/clusters/prod-1", http.StatusOK},
+		{"reader-token", "/api/v1/namespaces/clusters/clusters/missing", http.StatusNotFound},
+		{"admin-token", "/api/v1/clusters", http.StatusOK},
+		{"admin-token", "/api/v1/namespaces/clusters/secrets", http.StatusNotFound},
+		{"", "/openapi.json", http.StatusOK},
+	} {
+		if code := get(t, handler, tc.token, tc.path, nil); code != tc.code {
+			t.Errorf("%s %s: expected %d, got %d", tc.token, tc.path, tc.code, code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/namespaces/clusters/clusters/prod-1", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected the API to be read-only, got %d", rec.Code)
+	}
+}
+
+func TestListAndGet(t *testing.T) {
+	handler := newServer(t)
+	for _, tc := range []struct {
+		path     string
+		code     int
+		clusters int
+	}{
+		{"/api/v1/clusters", http.StatusOK, 3},
+		{"/api/v1/namespaces/clusters/clusters", http.StatusOK, 2},
+		{"/api/v1/clusters?labelSelector=clusterregistry.k8s.io/environment%3Dprod", http.StatusOK, 2},
+		{"/api/v1/clusters?condition=OK", http.StatusOK, 2},
+		{"/api/v1/clusters?condition=OK%3DFalse", http.StatusOK, 1},
+		{"/api/v1/clusters?condition=OK&labelSelector=clusterregistry.k8s.io/environment%3Dprod", http.StatusOK, 1},
+		{"/api/v1/clusters?condition=Decommissioned%3DUnknown", http.StatusOK, 3},
+		{"/api/v1/clusters?condition=OK%3DMaybe", http.StatusBadRequest, 0},
+		{"/api/v1/clusters?labelSelector=%3D%3D", http.StatusBadRequest, 0},
+	} {
+		list := &ClusterList{}
+		if code := get(t, handler, "admin-token", tc.path, list); code != tc.code || len(list.Items) != tc.clusters {
+			t.Errorf("%s: expected %d with %d clusters, got %d with %v", tc.path, tc.code, tc.clusters, code, names(list))
+		}
+	}
+
+	cluster := &Cluster{}
+	if code := get(t, handler, "reader-token", "/api/v1/namespaces/clusters/clusters/prod-1", cluster); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if cluster.Name != "prod-1" || cluster.Version != "v1.17.3" || !cluster.HasCABundle ||
+		cluster.ServerEndpoints[0].ServerAddress != "https://prod-1.example.com:6443" {
+		t.Errorf("unexpected cluster %+v", cluster)
+	}
+
+	var ca string
+	if code := get(t, handler, "reader-token", "/api/v1/namespaces/clusters/clusters/prod-1/ca.crt", &ca); code != http.StatusOK ||
+		ca != "-----BEGIN CERTIFICATE-----\n" {
+		t.Errorf("expected the CA bundle, got %d %q", code, ca)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	document := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(openAPI), &document); err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+	paths := document["paths"].(map[string]interface{})
+	for _, path := range []string{
+		"/api/v1/clusters",
+		"/api/v1/namespaces/{namespace}/clusters",
+		"/api/v1/namespaces/{namespace}/clusters/{name}",
+		"/api/v1/namespaces/{namespace}/clusters/{name}/ca.crt",
+	} {
+		if _, ok := paths[path]; !ok {
+			t.Errorf("expected %s to be documented", path)
+		}
+	}
+}